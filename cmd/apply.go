@@ -1,157 +1,408 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"os"
-	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/fatih/color"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 
 	"github.com/sap-gg/gok/internal"
-	"github.com/sap-gg/gok/internal/archive"
+	"github.com/sap-gg/gok/internal/deploy"
 	"github.com/sap-gg/gok/internal/diff"
+	"github.com/sap-gg/gok/internal/lockfile"
+	"github.com/sap-gg/gok/internal/plan"
 )
 
 var applyFlags = struct {
 	destination string
+	deployMap   string
+	plan        string
+	lockTimeout time.Duration
 	dryRun      bool
 	force       bool
+	prune       bool
+	// acceptUnsigned accepts unsigned lock files of destinations although a lock key is configured
+	acceptUnsigned bool
+	compare        compareFlags
 }{}
 
 // applyCmd represents the apply command
 var applyCmd = &cobra.Command{
-	Use:     "apply",
+	Use:     "apply <source>",
 	Short:   "Applies a rendered artifact to a destination directory.",
 	Long:    applyLongDescription,
 	Example: applyExample,
 	Args:    cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		sourceArtifact := args[0]
-		destinationDir := applyFlags.destination
-
-		log.Info().Msgf("reading desired state from artifact: %s", sourceArtifact)
-		desiredStateDir, err := os.MkdirTemp("", "gok-apply-desired-")
+		if err := applyFlags.compare.validate(); err != nil {
+			return err
+		}
+		sourceDir, cleanup, err := openDesiredState(args[0])
+		defer cleanup()
 		if err != nil {
-			return fmt.Errorf("create temp dir for desired state: %w", err)
+			return err
+		}
+
+		// the destinations (with their target, if any) to apply to
+		var (
+			targets      []string
+			destinations []*deploy.Destination
+		)
+		var savedPlan *plan.Plan
+		switch {
+		case applyFlags.plan != "":
+			if savedPlan, err = plan.Read(cmd.Context(), applyFlags.plan); err != nil {
+				return fmt.Errorf("reading plan: %w", err)
+			}
+			// compare the same way as the diff which created the plan
+			applyFlags.compare.noMerge = !savedPlan.Merge
+			applyFlags.compare.semantic = savedPlan.Semantic
+			applyFlags.compare.include, applyFlags.compare.exclude = savedPlan.Include, savedPlan.Exclude
+			targets = append(targets, savedPlan.Target)
+			destinations = append(destinations, &deploy.Destination{Path: savedPlan.Destination})
+		case applyFlags.deployMap != "":
+			deployMap, err := deploy.ReadMap(cmd.Context(), applyFlags.deployMap)
+			if err != nil {
+				return fmt.Errorf("reading deployment map: %w", err)
+			}
+			for _, target := range deployMap.TargetIDs() {
+				targets = append(targets, target)
+				destinations = append(destinations, deployMap.Destinations[target])
+			}
+		default:
+			targets = append(targets, applyFlags.compare.target)
+			destinations = append(destinations, &deploy.Destination{Path: applyFlags.destination})
 		}
-		defer os.RemoveAll(desiredStateDir)
 
-		if err := archive.Extract(sourceArtifact, desiredStateDir); err != nil {
-			return fmt.Errorf("extract artifact %q: %w", sourceArtifact, err)
+		// the destinations are locked before they are compared, so the comparison can't become outdated
+		dirs := make([]string, len(destinations))
+		for i, dest := range destinations {
+			dirs[i] = dest.Path
+		}
+		if applyFlags.dryRun {
+			for _, dir := range dirs {
+				warnIfLocked(dir)
+				warnIfInterrupted(dir)
+			}
+		} else {
+			unlock, err := lockDestinations(cmd.Context(), dirs, applyFlags.lockTimeout)
+			if err != nil {
+				return err
+			}
+			defer unlock()
+			for _, dir := range dirs {
+				if err := checkInterrupted(dir); err != nil {
+					return err
+				}
+			}
 		}
 
-		// compare desired state with current state
-		comparer := diff.NewComparer(destinationDir, desiredStateDir)
-		report, err := comparer.Compare()
+		key, err := lockKey()
 		if err != nil {
-			return fmt.Errorf("compare desired and current state: %w", err)
+			return err
+		}
+		unsigned := make(map[string]bool)
+		for _, dir := range dirs {
+			err := verifyLockSignature(dir, key, !applyFlags.dryRun)
+			if errors.Is(err, lockfile.ErrUnsigned) && applyFlags.acceptUnsigned {
+				log.Warn().Msgf("accepting the unsigned lock file of %s, it will be signed", dir)
+				unsigned[dir] = true
+			} else if err != nil {
+				return err
+			}
+		}
+
+		deployments := make([]*deployment, len(destinations))
+		for i, dest := range destinations {
+			var opts []diff.Option
+			if dest.Policy != "" {
+				opts = append(opts, diff.WithDefaultPolicy(dest.Policy))
+			}
+			d, err := newDeployment(sourceDir, dest.Path, targets[i], opts...)
+			if err != nil {
+				if applyFlags.deployMap != "" {
+					return fmt.Errorf("target %s: %w", targets[i], err)
+				}
+				return err
+			}
+			d.destinationHooks = dest.Hooks
+			d.lockKey = key
+			d.signLock = unsigned[dest.Path]
+			deployments[i] = d
+		}
+
+		if savedPlan != nil {
+			digest, err := plan.ArtifactDigest(sourceDir)
+			if err != nil {
+				return fmt.Errorf("computing artifact digest: %w", err)
+			}
+			if err := savedPlan.Verify(digest, deployments[0].report); err != nil {
+				return fmt.Errorf("refusing to apply plan %s: %w", applyFlags.plan, err)
+			}
+			log.Info().Msgf("plan %s is up to date", applyFlags.plan)
 		}
 
 		// print the changes we are going to apply
-		printDiffReport(report)
+		var conflicting []string
+		for _, d := range deployments {
+			if len(deployments) > 1 {
+				color.New(color.Bold).Printf("==> %s (%s)\n", d.target, d.destinationDir)
+			}
+			printDiffReport(d.report, applyFlags.prune, false)
+			if d.report.HasConflicts() {
+				conflicting = append(conflicting, d.destinationDir)
+			}
+		}
 
 		if applyFlags.dryRun {
 			log.Info().Msg("dry-run mode enabled, no changes will be applied")
 			return nil
 		}
 
-		if report.HasConflicts() && !applyFlags.force {
-			return fmt.Errorf("conflicts detected and --force not specified, aborting")
+		if len(conflicting) > 0 && !applyFlags.force {
+			return fmt.Errorf("conflicts detected in %s and --force not specified, aborting",
+				strings.Join(conflicting, ", "))
 		}
 
-		if !report.HasChanges() {
+		pending := slices.DeleteFunc(deployments, func(d *deployment) bool {
+			return !d.hasWork()
+		})
+		if len(pending) == 0 {
 			log.Info().Msg("no changes detected, nothing to apply")
 			return nil
 		}
 
-		log.Info().Msg("applying changes...")
-		for _, path := range report.SortedPaths() {
-			change := report.Changes[path]
-
-			srcPath := filepath.Join(desiredStateDir, path)
-			dstPath := filepath.Join(destinationDir, path)
-
-			switch change.Type {
-			case diff.Created, diff.Modified, diff.Conflict:
-				log.Info().Str("path", path).Msg("copy/update")
-				if err := copyFile(srcPath, dstPath); err != nil {
-					return fmt.Errorf("failed to copy %s: %w", path, err)
-				}
-			case diff.Removed:
-				log.Info().Str("path", path).Msg("remove")
-				if err := os.Remove(dstPath); err != nil {
-					if os.IsNotExist(err) {
-						log.Warn().Msgf("file %s already removed", path)
-						continue
-					}
-					return fmt.Errorf("failed to remove %s: %w", path, err)
-				}
-			default:
-				// we don't care about unchanged files
-			}
-		}
-
-		log.Info().Msg("updating lock file in destination")
-		srcLockPath := filepath.Join(desiredStateDir, internal.LockFileName)
-		dstLockPath := filepath.Join(destinationDir, internal.LockFileName)
-		if err := copyFile(srcLockPath, dstLockPath); err != nil {
-			return fmt.Errorf("failed to update lock file: %w", err)
+		err = applyAll(cmd.Context(), pending)
+		// post-apply hooks might fail after the changes were applied
+		printBackups(pending)
+		if err != nil {
+			return err
 		}
-
 		log.Info().Msg("apply completed successfully")
 		return nil
 	},
 }
 
-func copyFile(srcPath, dstPath string) error {
-	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
-		return fmt.Errorf("create parent directories for %q: %w", dstPath, err)
-	}
+// sameDir returns true if both paths refer to the same directory.
+func sameDir(a, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
 
-	srcFile, err := os.Open(srcPath)
-	if err != nil {
-		return fmt.Errorf("open source file %q: %w", srcPath, err)
+// ownershipApplier applies the ownership declared in the lock file to applied files.
+type ownershipApplier struct {
+	// skipped contains the paths whose ownership could not be changed because we are not root
+	skipped []string
+}
+
+// apply changes the owner and group of dstPath to the ones recorded for path in the lock (if any).
+// Without root privileges (or on platforms without ownership support), failures are only recorded, not returned.
+func (o *ownershipApplier) apply(lock *lockfile.LockFile, path, dstPath string) error {
+	entry, ok := lock.Files[path]
+	if !ok {
+		entry, ok = lock.Seeds[path]
+	}
+	if !ok || !entry.HasOwnership() {
+		return nil
 	}
-	defer srcFile.Close()
 
-	dstFile, err := os.Create(dstPath)
-	if err != nil {
-		return fmt.Errorf("create destination file %q: %w", dstPath, err)
+	uid, gid := entry.Ownership()
+	if err := os.Lchown(dstPath, uid, gid); err != nil {
+		if os.Geteuid() != 0 {
+			log.Debug().Err(err).Str("path", path).Msgf("cannot change ownership to %s", entry.FormatOwnership())
+			o.skipped = append(o.skipped, path)
+			return nil
+		}
+		return fmt.Errorf("failed to change ownership of %s: %w", path, err)
 	}
-	defer dstFile.Close()
+	log.Debug().Str("path", path).Msgf("changed ownership to %s", entry.FormatOwnership())
+	return nil
+}
 
-	_, err = io.Copy(dstFile, srcFile)
-	return err
+// warnSkipped warns if the ownership of any file could not be changed.
+func (o *ownershipApplier) warnSkipped() {
+	if len(o.skipped) > 0 {
+		log.Warn().Int("files", len(o.skipped)).
+			Msg("not running as root, the ownership of some files could not be changed (see debug log)")
+	}
 }
 
 func init() {
 	rootCmd.AddCommand(applyCmd)
 
 	applyCmd.Flags().StringVarP(&applyFlags.destination, "destination", "d", "",
-		"The destination directory to apply the artifact to. (required)")
-	_ = applyCmd.MarkFlagRequired("destination")
+		"The destination directory to apply the artifact to.")
+	applyCmd.Flags().StringVar(&applyFlags.deployMap, "deploy-map", "",
+		"A deployment map assigning targets to destination directories, applied all-or-nothing.")
+
+	applyCmd.Flags().StringVar(&applyFlags.plan, "plan", "",
+		"A plan saved by 'gok diff --out', only applied if the artifact and destination still match it.")
+	applyCmd.Flags().DurationVar(&applyFlags.lockTimeout, "lock-timeout", 0,
+		"How long to wait for another apply to the same destination to finish, e.g. 5m (default: fail immediately).")
 
 	applyCmd.Flags().BoolVarP(&applyFlags.dryRun, "dry-run", "n", false,
 		"Preview the changes without applying them.")
 
 	applyCmd.Flags().BoolVarP(&applyFlags.force, "force", "f", false,
 		"Force apply even if conflicts are detected.")
+
+	applyCmd.Flags().BoolVar(&applyFlags.prune, "prune", false,
+		"Remove untracked files inside directories the templates declare as exclusively managed.")
+
+	applyCmd.Flags().BoolVar(&applyFlags.acceptUnsigned, "accept-unsigned-lock", false,
+		"Accept unsigned lock files of destinations although a lock key is configured and sign them.")
+
+	applyFlags.compare.register(applyCmd)
+
+	applyCmd.MarkFlagsOneRequired("destination", "deploy-map", "plan")
+	applyCmd.MarkFlagsMutuallyExclusive("destination", "deploy-map", "plan")
+	applyCmd.MarkFlagsMutuallyExclusive("target", "deploy-map", "plan")
+	// the plan determines how the destination is compared
+	applyCmd.MarkFlagsMutuallyExclusive("no-merge", "plan")
+	applyCmd.MarkFlagsMutuallyExclusive("semantic", "plan")
+	applyCmd.MarkFlagsMutuallyExclusive("prune", "plan")
+	applyCmd.MarkFlagsMutuallyExclusive("include", "plan")
+	applyCmd.MarkFlagsMutuallyExclusive("exclude", "plan")
 }
 
 var (
-	applyLongDescription = `The apply command takes a rendered artifact (a .tar.gz file) and applies its
-contents to a specified output directory.
+	applyLongDescription = `The apply command takes a rendered artifact (.tar, .tar.gz, .tar.zst or .zip),
+a directory produced by 'gok render -o <dir>', or '-' to read an artifact from stdin,
+and applies its contents to a specified output directory.
 
 It performs the same comparison as 'gok diff' to ensure safety.
 The command will only create, update or delete files as necessary.
 
+An artifact rendered with multiple targets contains the output directories of all
+targets. Use '--target <id>' to apply only the output of a single target; the
+destination then receives a lock file containing only the files of this target.
+
+DEPLOYMENT MAPS
+---------------
+With '--deploy-map', several targets are applied to their own destinations at once.
+The map assigns target IDs to destination directories (relative paths are relative
+to the map) and optionally sets a conflict policy for files without a policy:
+
+  version: 1
+  destinations:
+    survival:
+      path: /opt/minecraft/survival
+    lobby:
+      path: /opt/minecraft/lobby
+      policy: keep-local
+
+The changes are printed per destination. Destinations are applied all-or-nothing:
+if a conflict is found in any destination, nothing is applied (unless '--force'),
+and if applying fails, the changes to all destinations are rolled back.
+
+SAVED PLANS
+-----------
+'gok diff <source> <dir> --out <file>' saves the reviewed changes as a plan.
+'gok apply <source> --plan <file>' applies the artifact to the destination (and
+target) of the plan, but refuses to proceed if the artifact is not the planned one,
+if a file affected by the plan was changed in the destination since, or if the
+changes differ from the planned ones in any other way. Conflicts still require
+'--force', even if they were part of the plan.
+
+HOOKS
+-----
+Targets can declare 'preApply' and 'postApply' hooks in the manifest, destinations
+of a deployment map can declare additional 'hooks'. Hooks only run if the output of
+the target changed: pre-apply hooks of all destinations before anything is changed,
+post-apply hooks after all destinations were changed. They run in the target's
+directory and receive the changes as JSON on stdin and in the environment variables
+GOK_HOOK_PHASE, GOK_TARGET, GOK_DIRECTORY, GOK_CHANGED_PATHS and GOK_CONFLICTS.
+
+Instead of a command, a hook can send commands to the server using RCON:
+
+  postApply:
+    - rcon:
+        commands: ["say Reloading plugins", "reload confirm"]
+      when: ["plugins/**"]   # only if a matching path changed
+
+The RCON port and password are read from the server.properties in the target's
+directory (enable-rcon, rcon.port, rcon.password), unless 'port' and 'passwordEnv'
+(the name of an environment variable containing the password) are set.
+
+A failing (or timed out) hook aborts the apply, unless its 'onFailure' is 'ignore'.
+With 'onFailure: rollback', a failing post-apply hook rolls back the changes to all
+destinations. Note that only files are rolled back, hooks which already ran are not.
+
 SAFETY
 ------
+While applying, each destination is locked ('` + internal.StateDirName + `/apply.lock', containing the
+PID, host and start time of the apply), so concurrent applies to the same destination
+fail instead of interleaving their changes. Use '--lock-timeout' to wait for the
+other apply to finish instead. Locks of processes which no longer exist on this host
+are taken over automatically (by a single process only), as well as empty or unreadable
+locks older than a minute.
+
+All operations of an apply are recorded in a journal ('` + internal.StateDirName + `/transaction/') before
+the first file is changed, and their progress as they are executed. If an apply is
+interrupted (e.g. by a crash), the next apply refuses to run until the destination is
+recovered with 'gok recover', which rolls the interrupted apply forward or back.
+
 By default, 'gok apply' will abort if it detects that files in the destination
 directory have been modified externally (a 'conflict'). To proceed and
 overwrite these manual changes, you can use the '--force' flag.
+
+For structured files (YAML, JSON, TOML and .properties), conflicts are first
+resolved with a key-level three-way merge between the last-applied content
+(kept in '` + internal.StateDirName + `/' inside the destination), the current file and the
+desired file. Only keys changed on both sides are reported as conflicts.
+The merged keys are written into the current file, its comments and formatting
+are kept (TOML files only if they are formatted canonically). Documents which
+aren't mappings, like the arrays of ops.json, are merged as a whole.
+Use '--no-merge' to disable this behavior.
+
+Templates can declare conflict policies per path in '` + internal.TemplateManifestFileName + `'
+(fail, keep-local, take-desired, merge, backup-and-replace). Conflicts resolved by
+a policy don't abort the apply.
+
+Before a conflicting file is overwritten with '--force' or by the 'backup-and-replace'
+policy, its content is saved to '` + internal.StateDirName + `/backups/<time>/'. The replaced files are
+listed at the end of the apply and can be restored using 'gok backups restore'.
+
+LOCK FILE SIGNATURES
+--------------------
+The '` + internal.LockFileName + `' of a destination is the baseline for detecting manual changes,
+so editing a file together with its hash in the lock file would hide the change.
+If a key is configured (GOK_LOCK_KEY, 'lock.key' in the config file or
+'--lock-key-file'), the lock file is signed with an HMAC-SHA256 of its content.
+'diff', 'status' and 'apply' verify the signature and fail if the lock file was
+altered outside of gok. An unsigned lock file is rejected as well; use
+'--accept-unsigned-lock' once to sign the lock file of an existing destination.
+A signed lock file is never replaced by an unsigned one, so apply fails without key.
+
+PERMISSIONS
+-----------
+File modes are preserved from the artifact. Ownership (uid/gid) declared by the
+'permissions' rules of templates is applied as well. This requires root privileges,
+otherwise a warning is printed and the files keep their current owner.
+
+UNTRACKED FILES
+---------------
+With '--untracked', files which are not managed by gok but located next to managed
+files are listed (use '--ignore' to skip e.g. worlds, logs or caches). With '--prune',
+untracked files inside directories which templates declare as 'exclusiveDirs' are removed.
+
+Directories containing managed files are recorded in '` + internal.LockFileName + `'. Once a directory is
+no longer managed (e.g. all files of a removed plugin were deleted), it's removed if it
+became empty. Directories still containing untracked files are kept.
+
+PARTIAL APPLY
+-------------
+With '--include' and '--exclude' globs, only the selected paths are compared and
+applied, e.g. to hot-fix a single plugin config from a full artifact. Only their
+entries are updated in the '` + internal.LockFileName + `' of the destination, all other entries are
+kept, so the remaining changes are still shown by the next diff.
 `
 
 	applyExample = `
@@ -162,5 +413,24 @@ gok apply ./new-build.tar.gz --destination /opt/server --dry-run
 gok apply ./new-build.tar.gz --destination /opt/server
 
 # Apply the artifact and overwrite any conflicting files.
-gok apply ./new-build.tar.gz --destination /opt/server --force`
+gok apply ./new-build.tar.gz --destination /opt/server --force
+
+# Apply only the output of one target of a multi-target artifact
+gok apply ./all-targets.tar.gz --target survival-prod --destination /opt/minecraft/survival
+
+# Apply exactly the changes reviewed using 'gok diff --out survival.plan.yaml'
+gok apply ./all-targets.tar.gz --plan survival.plan.yaml
+
+# Apply multiple targets to their destinations, all-or-nothing
+gok apply ./all-targets.tar.gz --deploy-map deploy.yaml
+
+# Apply a rendered directory directly, or an artifact streamed from another host
+gok apply ./out --destination /opt/server
+ssh build-host cat /builds/server.tar.gz | gok apply - --destination /opt/server
+
+# Apply the artifact and remove stray files from exclusively managed directories (e.g. plugins/)
+gok apply ./new-build.tar.gz --destination /opt/server --prune
+
+# Apply only the config of a single plugin from a full artifact
+gok apply ./new-build.tar.gz --destination /opt/server --include plugins/LuckPerms/config.yml`
 )
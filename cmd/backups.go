@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/sap-gg/gok/internal"
+	"github.com/sap-gg/gok/internal/state"
+)
+
+var backupsFlags = struct {
+	backup      string
+	lockTimeout time.Duration
+}{}
+
+// backupsCmd represents the backups command
+var backupsCmd = &cobra.Command{
+	Use:   "backups",
+	Short: "Lists and restores files replaced by 'gok apply'.",
+	Long:  backupsLongDescription,
+}
+
+var backupsListCmd = &cobra.Command{
+	Use:     "list <dir> [path]",
+	Short:   "Lists the backups of a destination directory.",
+	Example: backupsListExample,
+	Args:    cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backups, err := state.New(args[0]).Backups()
+		if err != nil {
+			return err
+		}
+
+		var path string
+		if len(args) > 1 {
+			path = cleanBackupPath(args[1])
+		}
+		found := false
+		for _, b := range backups {
+			if path != "" && !b.Contains(path) {
+				continue
+			}
+			found = true
+			color.New(color.Bold).Printf("%s (%s)\n", b.ID, b.Time.Local().Format(time.DateTime))
+			for _, saved := range b.Saved() {
+				if path == "" || saved == path {
+					fmt.Printf("  %s\n", saved)
+				}
+			}
+		}
+		if !found {
+			log.Info().Msgf("no backups found in %s", args[0])
+		}
+		return nil
+	},
+}
+
+var backupsRestoreCmd = &cobra.Command{
+	Use:     "restore <dir> <path>...",
+	Short:   "Restores files of a destination directory from a backup.",
+	Example: backupsRestoreExample,
+	Args:    cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := args[0]
+
+		unlock, err := lockDestinations(cmd.Context(), []string{dir}, backupsFlags.lockTimeout)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		if err := checkInterrupted(dir); err != nil {
+			return err
+		}
+
+		store := state.New(dir)
+		for _, arg := range args[1:] {
+			path := cleanBackupPath(arg)
+
+			var backup *state.Backup
+			if backupsFlags.backup != "" {
+				backup, err = store.FindBackup(backupsFlags.backup)
+			} else {
+				backup, err = store.LatestBackup(path)
+			}
+			if err != nil {
+				return err
+			}
+			if backup == nil {
+				return fmt.Errorf("no backup of %s found in %s", path, dir)
+			}
+
+			if err := backup.Restore(path); err != nil {
+				return err
+			}
+			log.Info().Str("path", path).Msgf("restored from backup %s", backup.ID)
+		}
+		log.Info().Msg("restored files differ from the lock file, they are reported as conflicts by the next apply")
+		return nil
+	},
+}
+
+// cleanBackupPath returns the path relative to the destination, as recorded in backups.
+func cleanBackupPath(path string) string {
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "./")
+}
+
+func init() {
+	rootCmd.AddCommand(backupsCmd)
+	backupsCmd.AddCommand(backupsListCmd, backupsRestoreCmd)
+
+	backupsRestoreCmd.Flags().StringVar(&backupsFlags.backup, "backup", "",
+		"The ID of the backup to restore from, as shown by 'gok backups list' (default: the latest backup of each path).")
+	backupsRestoreCmd.Flags().DurationVar(&backupsFlags.lockTimeout, "lock-timeout", 0,
+		"How long to wait for an apply to the same destination to finish, e.g. 5m (default: fail immediately).")
+}
+
+const (
+	backupsLongDescription = `Before 'gok apply' replaces a conflicting file, i.e. a file which was changed in the
+destination since the last apply, its content is saved to a backup. This happens for
+conflicts overwritten with '--force' and for conflicts resolved by the
+'backup-and-replace' policy. Conflicts resolved by 'take-desired' are replaced without backup.
+
+Backups are stored in '` + internal.StateDirName + `/backups/<id>/' inside the destination, where the ID is
+the time of the apply (UTC). They are never removed by gok.
+
+Use 'gok backups list' to show the backups and 'gok backups restore' to copy the
+backed up content of a file back into the destination.`
+
+	backupsListExample = `
+# List all backups of a server directory
+gok backups list /opt/minecraft/survival
+
+# List the backups containing a specific file
+gok backups list /opt/minecraft/survival plugins/LuckPerms/config.yml`
+
+	backupsRestoreExample = `
+# Restore the content a file had before it was last overwritten
+gok backups restore /opt/minecraft/survival server.properties
+
+# Restore a file from a specific backup
+gok backups restore /opt/minecraft/survival server.properties --backup 20250101T120000.000Z`
+)
@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fatih/color"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/sap-gg/gok/internal"
+	"github.com/sap-gg/gok/internal/blame"
+	"github.com/sap-gg/gok/internal/render"
+)
+
+// redacted replaces secret values in the blamed values
+const redacted = "********"
+
+var blameFlags = struct {
+	valuesFlags
+
+	target string
+	output string
+}{}
+
+// blameCmd represents the blame command
+var blameCmd = &cobra.Command{
+	Use:     "blame -t <target> <file> [key...]",
+	Short:   "Shows which template layer set each key or line of a rendered file.",
+	Long:    blameLongDescription,
+	Example: blameExample,
+	Args:    cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		path := filepath.ToSlash(filepath.Clean(args[0]))
+		keys := args[1:]
+
+		if blameFlags.output != "text" && blameFlags.output != "json" {
+			return fmt.Errorf("unsupported output format %q (supported: text, json)", blameFlags.output)
+		}
+
+		inputs, err := blameFlags.load(ctx)
+		if err != nil {
+			return err
+		}
+		target, ok := inputs.manifest.Targets[blameFlags.target]
+		if !ok {
+			return fmt.Errorf("target %q not found in manifest", blameFlags.target)
+		}
+
+		// the target is rendered again into a temporary directory which is always deleted afterward
+		workDir, err := os.MkdirTemp("", "gok-blame-")
+		if err != nil {
+			return fmt.Errorf("creating working directory: %w", err)
+		}
+		defer func() {
+			if rmErr := os.RemoveAll(workDir); rmErr != nil {
+				log.Debug().Err(rmErr).Msg("failed to remove temporary directory")
+			}
+		}()
+
+		workDirResolver, err := render.NewGenericPathResolver(workDir)
+		if err != nil {
+			return fmt.Errorf("work dir resolver: %w", err)
+		}
+		outputDir, err := workDirResolver.Resolve(target.Output)
+		if err != nil {
+			return fmt.Errorf("resolve output dir %q: %w", target.Output, err)
+		}
+		outputResolver, err := render.NewGenericPathResolver(outputDir)
+		if err != nil {
+			return fmt.Errorf("output dir resolver: %w", err)
+		}
+		dst, err := outputResolver.Resolve(filepath.FromSlash(path))
+		if err != nil {
+			return fmt.Errorf("resolve file %q: %w", path, err)
+		}
+
+		engine, err := inputs.newEngine(workDir)
+		if err != nil {
+			return err
+		}
+		blamer := blame.New(dst)
+		engine.Observe(blamer)
+
+		if err := engine.RenderTarget(ctx, target); err != nil {
+			return fmt.Errorf("rendering target %s: %w", target.ID, err)
+		}
+
+		result, err := blamer.Blame(path)
+		if err != nil {
+			return fmt.Errorf("blaming %s: %w", path, err)
+		}
+		result.Target = target.ID
+
+		if len(keys) > 0 {
+			if !result.Structured {
+				return fmt.Errorf("keys can only be given for structured files (yaml, json, toml, properties)")
+			}
+			result.Lines = filterKeys(result.Lines, keys)
+			if len(result.Lines) == 0 {
+				return fmt.Errorf("no key of %s matches %s", path, strings.Join(keys, ", "))
+			}
+		}
+
+		// rendered values may contain secrets, they are never printed
+		for _, line := range result.Lines {
+			for _, secret := range inputs.sensitiveStrings {
+				line.Value = strings.ReplaceAll(line.Value, secret, redacted)
+			}
+		}
+
+		if blameFlags.output == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(result); err != nil {
+				return fmt.Errorf("encoding blame result: %w", err)
+			}
+			return nil
+		}
+		return printBlameResult(os.Stdout, result)
+	},
+}
+
+// filterKeys returns the lines whose key is one of keys or nested below one of them.
+func filterKeys(lines []*blame.Line, keys []string) []*blame.Line {
+	var filtered []*blame.Line
+	for _, line := range lines {
+		for _, key := range keys {
+			if line.Key == key || strings.HasPrefix(line.Key, key+".") {
+				filtered = append(filtered, line)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+func printBlameResult(out io.Writer, result *blame.Result) error {
+	layers := make([]string, len(result.Layers))
+	for i, layer := range result.Layers {
+		layers[i] = layer.String()
+	}
+	_, _ = color.New(color.Bold).Fprintf(out, "%s (target %s)\n", result.Path, result.Target)
+	_, _ = fmt.Fprintf(out, "layers: %s\n", strings.Join(layers, ", "))
+	if result.Artifact {
+		_, _ = fmt.Fprintln(out, "the file is downloaded from an artifact spec, its content is not blamed")
+		return nil
+	}
+	_, _ = fmt.Fprintln(out)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	if result.Structured {
+		_, _ = fmt.Fprintln(w, "KEY\tVALUE\tLAYER\tVALUES")
+	} else {
+		_, _ = fmt.Fprintln(w, "LINE\tLAYER\tVALUES\tCONTENT")
+	}
+	for _, line := range result.Lines {
+		layer := "(unknown)"
+		if line.Layer != nil {
+			layer = line.Layer.String()
+		}
+		valueKeys := strings.Join(line.ValueKeys, ", ")
+		if valueKeys == "" {
+			valueKeys = "-"
+		}
+		if result.Structured {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", line.Key, line.Value, layer, valueKeys)
+		} else {
+			_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", line.Number, layer, valueKeys, line.Value)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("writing blame result: %w", err)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(blameCmd)
+
+	blameFlags.register(blameCmd)
+	blameCmd.Flags().StringVarP(&blameFlags.target, "target", "t", "",
+		"Target to render the file for")
+	blameCmd.Flags().StringVarP(&blameFlags.output, "output", "o", "text",
+		"Output format: text, json")
+	_ = blameCmd.MarkFlagRequired("target")
+}
+
+const (
+	blameLongDescription = `The blame command renders a single target again (in a temporary directory which is
+deleted afterward) and reports, for the given file of the target's output, which template
+layer set the final value of each key or line.
+
+For structured files (.yml, .yaml, .json, .toml and .properties) every key is reported in
+dot-notation, e.g. 'settings.motd', and attributed to the last layer declaring it. Layers
+which don't patch the file (e.g. copy-only) replace all keys of the previous layers. The
+blame can be limited to the given keys and the keys nested below them.
+
+For any other file every line is attributed to the layer which introduced it: lines which
+a later layer kept unchanged stay attributed to the earlier layer.
+
+If the layer rendered the file as template ('` + internal.TemplateInfix + `'), the keys of the values, secrets
+or targets it referenced and whose values appear in the final value are reported as well,
+e.g. 'values.server.motd'. Secret values are never printed.
+
+Files downloaded from an artifact spec only report the layers which declared them.
+The same values flags as for 'gok render' are supported and should match the ones used
+to render the deployed artifact.`
+
+	blameExample = `
+# Show which layer set each property of the server.properties of a target
+gok blame -t survival-prod server.properties
+
+# Only show a few keys (and the keys nested below them)
+gok blame -t survival-prod config/paper-global.yml chunk-loading proxies.velocity
+
+# Blame using the same values as the production render
+gok blame -t survival-prod -f prod-values.yaml -s secrets.yaml server.properties
+
+# Line blame of a plain text file as JSON
+gok blame -t lobby start.sh -o json`
+)
@@ -0,0 +1,421 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/fatih/color"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sap-gg/gok/internal"
+	"github.com/sap-gg/gok/internal/diff"
+	"github.com/sap-gg/gok/internal/glob"
+	"github.com/sap-gg/gok/internal/hooks"
+	"github.com/sap-gg/gok/internal/lockfile"
+	"github.com/sap-gg/gok/internal/state"
+)
+
+// deployment is the comparison of a desired state with a single destination directory,
+// which can be executed to bring the destination in line with the desired state.
+type deployment struct {
+	// target is the ID of the selected target, if any
+	target          string
+	destinationDir  string
+	desiredStateDir string
+	desiredLock     *lockfile.LockFile
+	report          *diff.Report
+	prunable        []*diff.UntrackedFile
+
+	// destinationHooks are declared for the destination (in addition to the hooks of the targets)
+	destinationHooks *lockfile.Hooks
+	// backup contains the conflicting files replaced by execute
+	backup *state.Backup
+	// filter limits the deployment to the selected paths (if set)
+	filter *glob.Filter
+	// lockKey signs the lock file written to the destination (if set)
+	lockKey []byte
+	// signLock is true if the lock file is written (and signed) even if nothing else changed
+	signLock bool
+}
+
+// newDeployment compares the desired state in sourceDir (narrowed down to target, if set) with destinationDir.
+func newDeployment(sourceDir, destinationDir, target string, opts ...diff.Option) (*deployment, error) {
+	if sameDir(sourceDir, destinationDir) {
+		return nil, fmt.Errorf("source and destination are the same directory")
+	}
+	desiredStateDir, desiredLock, err := selectTarget(sourceDir, target)
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(applyFlags.compare.options(applyFlags.prune), append(opts, diff.WithDesiredLock(desiredLock))...)
+	report, err := diff.NewComparer(destinationDir, desiredStateDir, opts...).Compare()
+	if err != nil {
+		return nil, fmt.Errorf("compare desired and current state: %w", err)
+	}
+
+	d := &deployment{
+		target:          target,
+		destinationDir:  destinationDir,
+		desiredStateDir: desiredStateDir,
+		desiredLock:     desiredLock,
+		report:          report,
+		filter:          applyFlags.compare.filter(),
+	}
+	if applyFlags.prune {
+		d.prunable = report.Prunable()
+	}
+	return d, nil
+}
+
+// hasWork returns true if executing the deployment changes the destination.
+func (d *deployment) hasWork() bool {
+	return d.report.HasChanges() || len(d.prunable) > 0 || d.signLock
+}
+
+// applyAll executes all deployments. If any deployment fails, the changes of all deployments are rolled back.
+// The pre-apply hooks of all deployments are run before anything is changed, the post-apply hooks
+// after all deployments were executed.
+func applyAll(ctx context.Context, deployments []*deployment) error {
+	for _, d := range deployments {
+		if err := d.runHooks(ctx, hooks.PreApply); err != nil {
+			return fmt.Errorf("pre-apply hook of %s: %w, nothing was changed", d.destinationDir, err)
+		}
+	}
+
+	var transactions []*state.Transaction
+	rollback := func(cause error) error {
+		log.Error().Err(cause).Msg("apply failed, rolling back all changes")
+		for _, d := range deployments {
+			// the replaced files are restored
+			d.backup = nil
+		}
+		for i := len(transactions) - 1; i >= 0; i-- {
+			if err := transactions[i].Rollback(); err != nil {
+				cause = errors.Join(cause, fmt.Errorf("rollback of %s: %w", deployments[i].destinationDir, err))
+			}
+		}
+		return cause
+	}
+
+	for _, d := range deployments {
+		tx, err := state.New(d.destinationDir).Begin()
+		if err != nil {
+			return rollback(err)
+		}
+		transactions = append(transactions, tx)
+
+		if err := d.execute(ctx, tx); err != nil {
+			return rollback(fmt.Errorf("applying to %s: %w", d.destinationDir, err))
+		}
+	}
+
+	// failing post-apply hooks don't stop the hooks of other deployments, their changes were applied as well
+	var hookErrs error
+	for _, d := range deployments {
+		if err := d.runHooks(ctx, hooks.PostApply); err != nil {
+			err = fmt.Errorf("post-apply hook of %s: %w", d.destinationDir, err)
+			var hookErr *hooks.Error
+			if errors.As(err, &hookErr) && hookErr.Policy() == lockfile.HookRollback {
+				return rollback(err)
+			}
+			hookErrs = errors.Join(hookErrs, err)
+		}
+	}
+
+	for i, tx := range transactions {
+		if err := tx.Commit(); err != nil {
+			log.Warn().Err(err).Msgf("failed to clean up transaction of %s", deployments[i].destinationDir)
+		}
+	}
+	if hookErrs != nil {
+		return fmt.Errorf("changes were applied, but %w", hookErrs)
+	}
+	return nil
+}
+
+// printBackups prints the conflicting files which were replaced by the deployments, and where their content is kept.
+func printBackups(deployments []*deployment) {
+	for _, d := range deployments {
+		if d.backup == nil || len(d.backup.Saved()) == 0 {
+			continue
+		}
+		log.Warn().Int("files", len(d.backup.Saved())).
+			Msgf("replaced conflicting files in %s, their previous content was backed up to %s", d.destinationDir, d.backup.Dir())
+		for _, path := range d.backup.Saved() {
+			color.Yellow("  %s", path)
+		}
+		log.Info().Msgf("restore a file using 'gok backups restore %s <path>'", d.destinationDir)
+	}
+}
+
+// hookSet contains the hooks run in dir for the changes of a deployment.
+type hookSet struct {
+	target string
+	dir    string
+	hooks  *lockfile.Hooks
+	// relative maps changed paths to paths relative to dir, paths of other targets are skipped
+	relative func(path string) (string, bool)
+}
+
+// hookSets returns the hooks of all targets in the desired lock, followed by the hooks of the destination.
+func (d *deployment) hookSets() []*hookSet {
+	ids := make([]string, 0, len(d.desiredLock.Targets))
+	for id := range d.desiredLock.Targets {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	var sets []*hookSet
+	for _, id := range ids {
+		entry := d.desiredLock.Targets[id]
+		if entry.Hooks.IsEmpty() {
+			continue
+		}
+		sets = append(sets, &hookSet{
+			target: id,
+			dir:    filepath.Join(d.destinationDir, filepath.FromSlash(entry.Output)),
+			hooks:  entry.Hooks,
+			relative: func(path string) (string, bool) {
+				return d.desiredLock.TargetPath(id, path)
+			},
+		})
+	}
+	if !d.destinationHooks.IsEmpty() {
+		sets = append(sets, &hookSet{
+			target: d.target,
+			dir:    d.destinationDir,
+			hooks:  d.destinationHooks,
+			relative: func(path string) (string, bool) {
+				return path, true
+			},
+		})
+	}
+	return sets
+}
+
+// runHooks runs the hooks of the phase for all targets with changes.
+func (d *deployment) runHooks(ctx context.Context, phase hooks.Phase) error {
+	for _, set := range d.hookSets() {
+		list := phase.Select(set.hooks)
+		if len(list) == 0 {
+			continue
+		}
+		dir, err := filepath.Abs(set.dir)
+		if err != nil {
+			return fmt.Errorf("resolving hook directory: %w", err)
+		}
+		event := hooks.NewEvent(phase, set.target, dir, d.report, d.prunable, set.relative)
+		if !event.HasChanges() {
+			log.Debug().Str("target", set.target).Msgf("no changes, skipping %s hooks", phase)
+			continue
+		}
+		if _, err := os.Stat(dir); phase == hooks.PreApply && os.IsNotExist(err) {
+			// there is nothing to prepare in a directory which is created by the apply
+			log.Info().Str("target", set.target).Msgf("%s does not exist yet, skipping %s hooks", dir, phase)
+			continue
+		}
+		if err := hooks.Run(ctx, list, event, os.Stderr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execute applies the changes of the report to the destination. All operations are recorded in the journal
+// of tx before the first one is executed, so an interrupted apply can be rolled forward or back.
+func (d *deployment) execute(ctx context.Context, tx *state.Transaction) error {
+	log.Info().Msgf("applying changes to %s...", d.destinationDir)
+	previous, err := lockfile.Read(d.destinationDir)
+	if err != nil {
+		return fmt.Errorf("reading lock file of destination: %w", err)
+	}
+	lock, err := d.resultLock(previous)
+	if err != nil {
+		return err
+	}
+	operations, err := d.operations(ctx, lock)
+	if err != nil {
+		return err
+	}
+	if err := tx.Intend(operations); err != nil {
+		return fmt.Errorf("failed to record intended changes: %w", err)
+	}
+
+	owners := &ownershipApplier{}
+	for _, op := range operations {
+		if err := tx.Execute(op); err != nil {
+			return err
+		}
+		if !op.Remove {
+			if err := owners.apply(d.desiredLock, op.Path, filepath.Join(d.destinationDir, op.Path)); err != nil {
+				return err
+			}
+		}
+	}
+	owners.warnSkipped()
+	d.backup = tx.Backup()
+	if err := d.removeEmptyDirs(tx, previous, lock); err != nil {
+		return err
+	}
+
+	// remember the applied content of structured files for future three-way merges.
+	// This is not part of the transaction, outdated content is detected by its hash.
+	store := state.New(d.destinationDir)
+	if err := store.SyncBase(d.desiredStateDir, lock, d.filter); err != nil {
+		return fmt.Errorf("failed to record last-applied content: %w", err)
+	}
+	// reformatted files are kept, their content in the destination is the last-applied one
+	for _, path := range d.report.Reformatted {
+		if err := store.RecordBase(path, filepath.Join(d.destinationDir, path)); err != nil {
+			return fmt.Errorf("failed to record last-applied content: %w", err)
+		}
+	}
+	return nil
+}
+
+// resultLock returns the lock file of the destination after the deployment, given its previous lock file.
+// With a path filter, only the entries of the selected paths are updated.
+// Reformatted files are not written, their entries record the content kept in the destination.
+// The entries of merged files record the merged content and the desired content it's based on.
+func (d *deployment) resultLock(previous *lockfile.LockFile) (*lockfile.LockFile, error) {
+	lock := d.desiredLock
+	if d.filter != nil {
+		lock = previous.Overlay(d.desiredLock, d.filter)
+	}
+	var merged []string
+	for _, path := range d.report.SortedPaths() {
+		if change := d.report.Changes[path]; change.Type == diff.Conflict && change.Resolution == diff.Merged {
+			merged = append(merged, path)
+		}
+	}
+	if len(d.report.Reformatted) == 0 && len(d.report.Merged) == 0 && len(merged) == 0 {
+		return lock, nil
+	}
+
+	result := *lock
+	result.Files = maps.Clone(lock.Files)
+	for _, path := range d.report.Reformatted {
+		actual, err := lockfile.EntryFor(filepath.Join(d.destinationDir, path))
+		if err != nil {
+			return nil, err
+		}
+		entry := *result.Files[path]
+		entry.Hash, entry.MTime, entry.Size, entry.DataHash = actual.Hash, actual.MTime, actual.Size, actual.DataHash
+		result.Files[path] = &entry
+	}
+	// merged files which are kept as-is
+	for _, path := range d.report.Merged {
+		actual, err := lockfile.EntryFor(filepath.Join(d.destinationDir, path))
+		if err != nil {
+			return nil, err
+		}
+		entry := *result.Files[path]
+		entry.BaseHash = entry.Hash
+		entry.Hash, entry.MTime, entry.Size, entry.DataHash = actual.Hash, actual.MTime, actual.Size, actual.DataHash
+		result.Files[path] = &entry
+	}
+	// merged files which are written by the deployment
+	for _, path := range merged {
+		content := d.report.Changes[path].Merged
+		entry := *result.Files[path]
+		entry.BaseHash = entry.Hash
+		entry.Hash, entry.Size = lockfile.SHA256(content), int64(len(content))
+		entry.DataHash = lockfile.ContentDataHash(path, content)
+		result.Files[path] = &entry
+	}
+	return &result, nil
+}
+
+// removeEmptyDirs removes the directories which were managed according to the previous lock file,
+// but no longer are and were left empty, deepest first. Directories containing untracked files are kept.
+func (d *deployment) removeEmptyDirs(tx *state.Transaction, previous, lock *lockfile.LockFile) error {
+	managed := make(map[string]struct{})
+	for _, dir := range lock.ManagedDirs() {
+		managed[dir] = struct{}{}
+	}
+	// parents sort before their children
+	for _, dir := range slices.Backward(previous.ManagedDirs()) {
+		if _, ok := managed[dir]; ok {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(d.destinationDir, dir))
+		if err != nil || len(entries) > 0 {
+			// already removed, replaced by a file or still containing files
+			continue
+		}
+		op := state.RemoveOperation(dir)
+		if err := tx.Intend([]*state.Operation{op}); err != nil {
+			return fmt.Errorf("failed to record intended changes: %w", err)
+		}
+		if err := tx.Execute(op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// operations returns the operations bringing the destination in line with the desired state,
+// ending with writing the lock file.
+func (d *deployment) operations(ctx context.Context, lock *lockfile.LockFile) ([]*state.Operation, error) {
+	var operations []*state.Operation
+	for _, path := range d.report.SortedPaths() {
+		change := d.report.Changes[path]
+		srcPath := filepath.Join(d.desiredStateDir, path)
+
+		backup := false
+		switch change.Type {
+		case diff.Conflict:
+			switch change.Resolution {
+			case diff.Merged:
+				log.Info().Str("path", path).Msg("merge")
+				operations = append(operations, state.ContentOperation(path, change.Merged, srcPath))
+				continue
+			case diff.KeepLocal:
+				log.Info().Str("path", path).Msg("keep local changes")
+				continue
+			case diff.BackupAndReplace:
+				log.Info().Str("path", path).Msg("backup")
+				backup = true
+			case diff.Unresolved:
+				log.Warn().Str("path", path).Msg("overwriting conflicting file (forced)")
+				backup = true
+			default:
+				// TakeDesired: replace according to the desired state
+			}
+		case diff.Renamed:
+			operations = append(operations, state.RenameOperation(change.OldPath, path, srcPath))
+			continue
+		case diff.Created, diff.Modified, diff.Removed:
+		default:
+			// we don't care about unchanged files
+			continue
+		}
+
+		op := state.CopyOperation(path, srcPath)
+		if change.NewHash == "" {
+			op = state.RemoveOperation(path)
+		}
+		op.Backup = backup
+		operations = append(operations, op)
+	}
+	for _, u := range d.prunable {
+		log.Info().Str("path", u.Path).Msg("prune untracked file")
+		operations = append(operations, state.RemoveOperation(u.Path))
+	}
+
+	// with --target, only the part of the lock file belonging to the target is written
+	content, err := lockfile.Marshal(ctx, lock)
+	if err != nil {
+		return nil, err
+	}
+	if d.lockKey != nil {
+		content = lockfile.Sign(content, d.lockKey)
+	}
+	return append(operations, state.ContentOperation(internal.LockFileName, content, "")), nil
+}
@@ -1,49 +1,138 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"os"
+	"strings"
 
 	"github.com/fatih/color"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 
 	"github.com/sap-gg/gok/internal"
-	"github.com/sap-gg/gok/internal/archive"
 	"github.com/sap-gg/gok/internal/diff"
+	"github.com/sap-gg/gok/internal/glob"
+	"github.com/sap-gg/gok/internal/plan"
 )
 
+var diffFlags = struct {
+	out     string
+	content bool
+	compare compareFlags
+}{}
+
+// compareFlags are the flags shared by all commands comparing a desired with a current state.
+type compareFlags struct {
+	target    string
+	noMerge   bool
+	semantic  bool
+	untracked bool
+	ignore    []string
+	include   []string
+	exclude   []string
+}
+
+func (f *compareFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.target, "target", "",
+		"Only use the output of this target from a multi-target artifact as the desired state.")
+	cmd.Flags().BoolVar(&f.noMerge, "no-merge", false,
+		"Do not attempt a three-way merge of conflicting structured files.")
+	cmd.Flags().BoolVar(&f.semantic, "semantic", false,
+		"Treat structured files (YAML, JSON, TOML, properties) as unchanged if only their formatting differs.")
+	cmd.Flags().BoolVar(&f.untracked, "untracked", false,
+		"List untracked files next to managed files and inside exclusively managed directories.")
+	cmd.Flags().StringSliceVar(&f.ignore, "ignore", []string{},
+		"Globs of untracked files or directories to ignore, e.g. world/ or logs/ (comma-separated)")
+	cmd.Flags().StringSliceVar(&f.include, "include", []string{},
+		"Only compare paths matching these globs, e.g. plugins/LuckPerms/ (comma-separated)")
+	cmd.Flags().StringSliceVar(&f.exclude, "exclude", []string{},
+		"Do not compare paths matching these globs, even if they are included (comma-separated)")
+}
+
+// validate checks the globs of the flags.
+func (f *compareFlags) validate() error {
+	if err := (&glob.Filter{Include: f.include, Exclude: f.exclude}).Validate(); err != nil {
+		return fmt.Errorf("path filter: %w", err)
+	}
+	return nil
+}
+
+// filter returns the path filter of the flags, nil if all paths are compared.
+func (f *compareFlags) filter() *glob.Filter {
+	filter := &glob.Filter{Include: f.include, Exclude: f.exclude}
+	if filter.IsEmpty() {
+		return nil
+	}
+	return filter
+}
+
+// options returns the diff options for the flags. If untracked is true, untracked files are always reported.
+func (f *compareFlags) options(untracked bool) []diff.Option {
+	var opts []diff.Option
+	if !f.noMerge {
+		opts = append(opts, diff.WithThreeWayMerge())
+	}
+	if f.semantic {
+		opts = append(opts, diff.WithSemanticEquality())
+	}
+	if f.untracked || untracked {
+		opts = append(opts, diff.WithUntracked(f.ignore...))
+	}
+	if filter := f.filter(); filter != nil {
+		opts = append(opts, diff.WithPathFilter(filter))
+	}
+	return opts
+}
+
 // diffCmd represents the diff command.
 // It's very similar to the applyCmd (with dry run always enabled),
 // but it does not make any changes to the output directory.
 var diffCmd = &cobra.Command{
-	Use:     "diff <source-artifact.tar.gz> <output-dir>",
+	Use:     "diff <source> <output-dir>",
 	Short:   "Compares a rendered artifact with an existing output directory.",
 	Long:    diffLongDescription,
 	Example: diffExample,
 	Args:    cobra.ExactArgs(2),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		sourceArtifact := args[0]
+		source := args[0]
 		currentOutputDir := args[1]
+		if err := diffFlags.compare.validate(); err != nil {
+			return err
+		}
 
-		log.Info().Msgf("reading desired state from artifact %s", sourceArtifact)
-		tempDir, err := os.MkdirTemp("", "gok-diff-desired-")
+		sourceDir, cleanup, err := openDesiredState(source)
+		defer cleanup()
 		if err != nil {
-			return fmt.Errorf("creating temp dir: %w", err)
+			return err
 		}
-		defer os.RemoveAll(tempDir)
-
-		if err := archive.Extract(sourceArtifact, tempDir); err != nil {
-			return fmt.Errorf("extracting source artifact: %w", err)
+		desiredStateDir, desiredLock, err := selectTarget(sourceDir, diffFlags.compare.target)
+		if err != nil {
+			return err
 		}
 
-		comparer := diff.NewComparer(currentOutputDir, tempDir)
+		warnIfLocked(currentOutputDir)
+		warnIfInterrupted(currentOutputDir)
+		key, err := lockKey()
+		if err != nil {
+			return err
+		}
+		if err := verifyLockSignature(currentOutputDir, key, false); err != nil {
+			return err
+		}
+		opts := append(diffFlags.compare.options(false), diff.WithDesiredLock(desiredLock))
+		comparer := diff.NewComparer(currentOutputDir, desiredStateDir, opts...)
 		report, err := comparer.Compare()
 		if err != nil {
 			return fmt.Errorf("comparing states: %w", err)
 		}
 
-		printDiffReport(report)
+		printDiffReport(report, false, diffFlags.content)
+
+		if diffFlags.out != "" {
+			if err := writePlan(cmd.Context(), sourceDir, currentOutputDir, report); err != nil {
+				return err
+			}
+		}
 
 		if report.HasConflicts() {
 			log.Warn().Msg("conflicts detected. Please resolve them before applying changes.")
@@ -59,30 +148,118 @@ var diffCmd = &cobra.Command{
 	},
 }
 
-func printDiffReport(report *diff.Report) {
-	if !report.HasChanges() {
-		return
+// writePlan saves the report as plan to diffFlags.out, for a later 'gok apply --plan'.
+func writePlan(ctx context.Context, sourceDir, destinationDir string, report *diff.Report) error {
+	digest, err := plan.ArtifactDigest(sourceDir)
+	if err != nil {
+		return fmt.Errorf("computing artifact digest: %w", err)
+	}
+	p, err := plan.New(digest, diffFlags.compare.target, destinationDir, !diffFlags.compare.noMerge,
+		diffFlags.compare.filter(), report)
+	if err != nil {
+		return fmt.Errorf("creating plan: %w", err)
 	}
+	p.Semantic = diffFlags.compare.semantic
+	if err := p.Write(ctx, diffFlags.out); err != nil {
+		return err
+	}
+	log.Info().Int("changes", len(p.Changes)).Msgf("wrote plan to %s", diffFlags.out)
+	return nil
+}
 
+// metadataSummary describes the changed permission bits and ownership of a change.
+func metadataSummary(change *diff.Change) string {
+	var parts []string
+	if change.OldMode != change.NewMode {
+		parts = append(parts, fmt.Sprintf("mode %s -> %s", change.OldMode, change.NewMode))
+	}
+	if change.OldOwner != change.NewOwner {
+		parts = append(parts, fmt.Sprintf("owner %s -> %s", change.OldOwner, change.NewOwner))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// printChangeContent prints the hashes of a changed file and the targets and template layers producing it.
+func printChangeContent(change *diff.Change) {
+	fmt.Printf("    hash:   %s -> %s\n", shortHash(change.OldHash), shortHash(change.NewHash))
+	if change.NewSource != nil {
+		fmt.Printf("    source: %s\n", change.NewSource)
+	}
+	if change.OldSource != nil && !change.OldSource.Equal(change.NewSource) {
+		fmt.Printf("    was:    %s\n", change.OldSource)
+	}
+}
+
+// shortHash returns the first characters of a hash, which are enough to tell hashes apart.
+func shortHash(hash string) string {
+	if hash == "" {
+		return "-"
+	}
+	return hash[:min(len(hash), 12)]
+}
+
+// printDiffReport prints all changes and untracked files of the report.
+// If prune is true, untracked files which are going to be pruned are marked as such.
+// If content is true, the hashes and sources of changed files are printed as well.
+func printDiffReport(report *diff.Report, prune, content bool) {
 	for _, path := range report.SortedPaths() {
 		change := report.Changes[path]
 		switch change.Type {
 		case diff.Created:
-			color.Green("+ %s", path)
+			if change.Seed {
+				color.Green("+ %s (seed)", path)
+			} else {
+				color.Green("+ %s", path)
+			}
 		case diff.Modified:
-			color.Yellow("~ %s", path)
+			if change.IsMetadataOnly() {
+				color.Yellow("~ %s (%s)", path, metadataSummary(change))
+			} else {
+				color.Yellow("~ %s", path)
+			}
 		case diff.Removed:
 			color.Red("- %s", path)
+		case diff.Renamed:
+			color.Blue("R %s -> %s", change.OldPath, path)
 		case diff.Conflict:
-			color.HiRed("! %s (conflict)", path)
+			switch {
+			case change.Resolution == diff.Merged:
+				color.Cyan("M %s (merged)", path)
+			case change.Resolution != diff.Unresolved:
+				color.Magenta("! %s (conflict, %s)", path, change.Resolution)
+			case len(change.ConflictKeys) > 0:
+				color.HiRed("! %s (conflict: %s)", path, strings.Join(change.ConflictKeys, ", "))
+			default:
+				color.HiRed("! %s (conflict)", path)
+			}
 		case diff.Unchanged:
 			// do nothing
 		}
+		if content && change.Type != diff.Unchanged {
+			printChangeContent(change)
+		}
+	}
+	if len(report.Reformatted) > 0 {
+		log.Info().Strs("paths", report.Reformatted).Msg("only the formatting differs, treating as unchanged")
+	}
+	for _, u := range report.Untracked {
+		if prune && u.Exclusive {
+			color.Red("? %s (untracked, will be pruned)", u.Path)
+		} else {
+			color.White("? %s (untracked)", u.Path)
+		}
 	}
 }
 
 func init() {
 	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringVar(&diffFlags.out, "out", "",
+		"Save the changes as a plan file, which can be applied using 'gok apply <source> --plan <file>'.")
+	diffCmd.Flags().BoolVar(&diffFlags.content, "content", false,
+		"Show the hashes of changed files and the target and template layers their content comes from.")
+
+	diffFlags.compare.register(diffCmd)
 }
 
 const (
@@ -91,14 +268,61 @@ made by applying a rendered artifact. It is similar to the 'apply' command,
 but it does not modify any files in the output directory.
 
 It performs a three-way comparison between:
-1. The 'desired state' (the contents of the <source-artifact.tar.gz> file)
+1. The 'desired state' (the contents of the <source>)
 2. The 'last known state' (from the` + internal.LockFileName + ` file in the <output-dir>)
 3. The 'actual current state' (the real files on the disk in the <output-dir>
 
 This allows it to detect not only pending changes but also 'conflicts' or 'drift',
-which occur when files have been modified on the target outside of the gok workflow.`
+which occur when files have been modified on the target outside of the gok workflow.
+
+Conflicting structured files (YAML, JSON, TOML and .properties) are merged on a
+per-key basis if the edits don't overlap. These are shown as 'M' (merged).
+
+Files which were moved or renamed without changing their content (e.g. from
+'plugins/Foo/config.yml' to 'plugins/foo/config.yml') are shown as 'R old -> new'
+and renamed by 'gok apply' instead of being removed and created again.
+
+Merging structured files re-encodes them, so a template change which doesn't change
+any data can still change the bytes of a file. With '--semantic', structured files
+whose parsed data is equal are treated as unchanged, e.g. when only the indentation,
+quoting, key order or comments differ. This also applies to files the server rewrote
+without changing their settings. The lock file records a hash of the normalized data
+of every structured file next to its byte hash for this comparison.
+
+The <source> is either a rendered artifact (.tar, .tar.gz, .tar.zst or .zip),
+a directory produced by 'gok render -o <dir>', or '-' to read an artifact from stdin.
+
+With '--out <file>', the reviewed changes are saved as a plan. The plan records the
+digest of the artifact (covering the content of all its files, it must contain a lock
+file), the hashes of the affected files in the output directory and
+the exact list of changes. 'gok apply <source> --plan <file>' refuses to apply if
+any of them no longer match.
+
+With '--content', the hashes of each changed file are shown together with its source
+as recorded in the lock files: the target, the template layers which wrote or patched
+it (in order) and the strategy each layer used. Files declared by an artifact spec
+show the 'artifact' strategy.
+
+Use '--include' and '--exclude' globs to only compare some paths, e.g. '--include plugins/'.
+Plans record these filters, so the plan is applied with the same ones.`
 
 	diffExample = `
 # Compare the newly rendered artifact with the current server state
-gok diff ./new-build.tar.gz /opt/minecraft/server'`
+gok diff ./new-build.tar.gz /opt/minecraft/server
+
+# Compare a rendered directory without creating an archive first
+gok diff ./out /opt/minecraft/server
+
+# Compare an artifact streamed from another host
+ssh build-host cat /builds/survival.tar.zst | gok diff - /opt/minecraft/server
+
+# Ignore changes which only affect the formatting of structured files
+gok diff ./new-build.tar.gz /opt/minecraft/server --semantic
+
+# Show which templates produced the changed files
+gok diff ./new-build.tar.gz /opt/minecraft/server --content
+
+# Save the reviewed changes as a plan and apply exactly these changes later
+gok diff ./new-build.tar.gz /opt/minecraft/server --out survival.plan.yaml
+gok apply ./new-build.tar.gz --plan survival.plan.yaml`
 )
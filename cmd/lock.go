@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sap-gg/gok/internal/state"
+)
+
+// lockDestinations takes the locks of all destination directories (in a stable order, to avoid deadlocks
+// between concurrent applies) and returns a function releasing them.
+func lockDestinations(ctx context.Context, dirs []string, timeout time.Duration) (func(), error) {
+	dirs = slices.Sorted(slices.Values(dirs))
+
+	var locks []*state.Lock
+	unlock := func() {
+		for _, lock := range slices.Backward(locks) {
+			if err := lock.Unlock(); err != nil {
+				log.Warn().Err(err).Msg("failed to release destination lock")
+			}
+		}
+	}
+	for _, dir := range dirs {
+		lock, err := state.New(dir).Lock(ctx, timeout)
+		if err != nil {
+			unlock()
+			var lockedErr *state.LockedError
+			if errors.As(err, &lockedErr) {
+				return nil, fmt.Errorf("%s: %w, retry later or wait using --lock-timeout", dir, err)
+			}
+			return nil, fmt.Errorf("locking %s: %w", dir, err)
+		}
+		locks = append(locks, lock)
+	}
+	return unlock, nil
+}
+
+// warnIfLocked logs a warning if an apply to the destination directory is in progress,
+// i.e. its files might be changing while they are read.
+func warnIfLocked(dir string) {
+	holder, err := state.New(dir).LockHolder()
+	if err != nil {
+		log.Debug().Err(err).Msgf("cannot check the lock of %s", dir)
+		return
+	}
+	if holder != nil {
+		log.Warn().Msgf("an apply to %s is in progress (%s), the results may be inconsistent", dir, holder)
+	}
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/sap-gg/gok/internal"
+	"github.com/sap-gg/gok/internal/lockfile"
+	"github.com/sap-gg/gok/internal/state"
+)
+
+var recoverFlags = struct {
+	rollForward bool
+	rollBack    bool
+	lockTimeout time.Duration
+}{}
+
+// recoverCmd represents the recover command
+var recoverCmd = &cobra.Command{
+	Use:     "recover <dir>",
+	Short:   "Finishes or undoes an interrupted apply of a destination directory.",
+	Long:    recoverLongDescription,
+	Example: recoverExample,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := args[0]
+
+		unlock, err := lockDestinations(cmd.Context(), []string{dir}, recoverFlags.lockTimeout)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		store := state.New(dir)
+		journal, err := store.Interrupted()
+		if err != nil {
+			return fmt.Errorf("reading journal: %w", err)
+		}
+		if journal == nil {
+			log.Info().Msgf("no interrupted apply found in %s", dir)
+			return nil
+		}
+
+		if !recoverFlags.rollForward && !recoverFlags.rollBack {
+			printJournal(journal)
+			return fmt.Errorf("the apply to %s was interrupted, use --roll-forward or --roll-back", dir)
+		}
+
+		tx, err := store.Resume()
+		if err != nil {
+			return err
+		}
+		if recoverFlags.rollBack {
+			if err := tx.Rollback(); err != nil {
+				return fmt.Errorf("rolling back: %w", err)
+			}
+			log.Info().Int("paths", len(journal.Changed)).Msg("interrupted apply rolled back")
+			return nil
+		}
+
+		written, err := tx.RollForward()
+		if err != nil {
+			return fmt.Errorf("rolling forward: %w", err)
+		}
+		// the lock file was written last, it declares the ownership of the written files
+		lock, err := lockfile.Read(dir)
+		if err != nil {
+			return err
+		}
+		owners := &ownershipApplier{}
+		for _, path := range written {
+			if err := owners.apply(lock, path, filepath.Join(dir, path)); err != nil {
+				return err
+			}
+		}
+		owners.warnSkipped()
+		log.Info().Int("operations", len(journal.Operations)-len(journal.Done)).Msg("interrupted apply rolled forward")
+		return nil
+	},
+}
+
+// printJournal prints the done and pending operations of an interrupted apply.
+func printJournal(journal *state.Journal) {
+	fmt.Printf("The apply started at %s was interrupted:\n", journal.StartedAt.Local().Format(time.DateTime))
+	if len(journal.Operations) == 0 {
+		color.Yellow("  no intended operations were recorded, it can only be rolled back (%d paths changed)",
+			len(journal.Changed))
+		return
+	}
+
+	done := make(map[string]struct{}, len(journal.Done))
+	for _, path := range journal.Done {
+		done[path] = struct{}{}
+	}
+	for _, op := range journal.Operations {
+		action := "write " + op.Path
+		switch {
+		case op.Remove:
+			action = "remove " + op.Path
+		case op.From != "":
+			action = fmt.Sprintf("rename %s -> %s", op.From, op.Path)
+		}
+		if _, ok := done[op.Path]; ok {
+			color.Green("  done    %s", action)
+		} else {
+			color.Yellow("  pending %s", action)
+		}
+	}
+}
+
+// checkInterrupted returns an error if the last apply to dir was interrupted.
+func checkInterrupted(dir string) error {
+	journal, err := state.New(dir).Interrupted()
+	if err != nil {
+		return fmt.Errorf("reading journal of %s: %w", dir, err)
+	}
+	if journal != nil {
+		return fmt.Errorf("%s: %w", dir, &state.InterruptedError{StartedAt: journal.StartedAt})
+	}
+	return nil
+}
+
+// warnIfInterrupted logs a warning if the last apply to dir was interrupted, i.e. its files might be inconsistent.
+func warnIfInterrupted(dir string) {
+	if err := checkInterrupted(dir); err != nil {
+		log.Warn().Msg(err.Error())
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(recoverCmd)
+
+	recoverCmd.Flags().BoolVar(&recoverFlags.rollForward, "roll-forward", false,
+		"Finish the interrupted apply, i.e. execute its remaining operations.")
+	recoverCmd.Flags().BoolVar(&recoverFlags.rollBack, "roll-back", false,
+		"Undo the interrupted apply, i.e. restore all changed paths.")
+	recoverCmd.Flags().DurationVar(&recoverFlags.lockTimeout, "lock-timeout", 0,
+		"How long to wait for another apply to the same destination to finish, e.g. 5m (default: fail immediately).")
+	recoverCmd.MarkFlagsMutuallyExclusive("roll-forward", "roll-back")
+}
+
+const (
+	recoverLongDescription = `The recover command brings a destination directory back to a consistent state
+after an apply was interrupted, e.g. by a crash or power loss.
+
+Before changing anything, 'gok apply' records all intended operations in a journal
+inside '` + internal.StateDirName + `/transaction/', together with a copy of the desired content and of every
+original file it replaces. Completed operations are marked in the journal as the
+apply progresses. If the journal of an interrupted apply is found, 'gok apply' refuses
+to run and 'gok status' prints a warning.
+
+Without flags, the done and pending operations of the interrupted apply are listed.
+- --roll-forward executes the remaining operations and writes the new '` + internal.LockFileName + `'
+- --roll-back restores the original state of all paths changed by the apply
+
+Hooks are not run by recover. The destination is locked while it's recovered.`
+
+	recoverExample = `
+# Show what the interrupted apply did and what's left to do
+gok recover /opt/minecraft/survival
+
+# Finish the interrupted apply
+gok recover /opt/minecraft/survival --roll-forward
+
+# Restore the state before the interrupted apply
+gok recover /opt/minecraft/survival --roll-back`
+)
@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
 	"strings"
@@ -18,11 +20,111 @@ import (
 	"github.com/sap-gg/gok/internal/templ"
 )
 
-var renderFlags = struct {
+// valuesFlags are the flags which select the manifest and the values targets are rendered with
+type valuesFlags struct {
 	manifestPath    string
 	valuesFiles     []string // for external value files, merged from left to right
 	secretFiles     []string
 	valueOverwrites map[string]string
+}
+
+func (f *valuesFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&f.manifestPath, "manifest", "m", internal.ManifestFileName,
+		"Path to the manifest file")
+	cmd.Flags().StringSliceVarP(&f.valuesFiles, "values-from", "f", []string{},
+		"Additional values files to merge, merged left to right")
+	cmd.Flags().StringToStringVarP(&f.valueOverwrites, "values-overwrites", "v",
+		make(map[string]string), "Additional values to overwrite. These have the highest precedence.")
+	cmd.Flags().StringSliceVarP(&f.secretFiles, "secrets", "s", []string{},
+		"Additional secrets files to merge, merged left to right")
+}
+
+// renderInputs are the manifest and all values loaded from the valuesFlags
+type renderInputs struct {
+	manifest    *render.Manifest
+	manifestDir string
+
+	secretValues         render.Values
+	externalFilesValues  *render.ValuesOverwritesSpec
+	flagValueOverwrites  *render.ValuesOverwritesSpec
+	resolvedTargetValues map[string]render.Values
+
+	// sensitiveStrings are the secret values which must never be printed
+	sensitiveStrings []string
+}
+
+// load reads the manifest and all values. It also sets up the logging redaction for the secret values.
+func (f *valuesFlags) load(ctx context.Context) (*renderInputs, error) {
+	manifest, manifestDir, err := render.ReadManifest(ctx, f.manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+
+	// load any external values files (-f)
+	externalFilesValues, err := render.ParseValuesOverwrites(ctx, f.valuesFiles)
+	if err != nil {
+		return nil, fmt.Errorf("loading external values files: %w", err)
+	}
+
+	flagValueOverwrites, err := render.ParseStringToStringValuesOverwrites(ctx, f.valueOverwrites)
+	if err != nil {
+		return nil, fmt.Errorf("loading flag string overwrites: %w", err)
+	}
+
+	secretValues, err := render.LoadValuesFiles(ctx, f.secretFiles)
+	if err != nil {
+		return nil, fmt.Errorf("loading secret values files: %w", err)
+	}
+
+	// setup logging redaction for sensitive values
+	sensitiveStrings := render.CollectStrings(secretValues)
+	logging.Init(sensitiveStrings)
+	log.Debug().Int("count", len(sensitiveStrings)).
+		Msg("initialized logging with sensitive values redaction")
+
+	log.Debug().Msg("pre-computing final values for all targets...")
+	resolvedTargetValues, err := render.PreComputeAllTargetValues(manifest, externalFilesValues, flagValueOverwrites)
+	if err != nil {
+		return nil, fmt.Errorf("pre-computing target values: %w", err)
+	}
+
+	return &renderInputs{
+		manifest:             manifest,
+		manifestDir:          manifestDir,
+		secretValues:         secretValues,
+		externalFilesValues:  externalFilesValues,
+		flagValueOverwrites:  flagValueOverwrites,
+		resolvedTargetValues: resolvedTargetValues,
+		sensitiveStrings:     sensitiveStrings,
+	}, nil
+}
+
+// newEngine creates a render engine which renders into workDir.
+func (in *renderInputs) newEngine(workDir string) (*render.Engine, error) {
+	registry, err := newStrategyRegistry()
+	if err != nil {
+		return nil, fmt.Errorf("creating strategy registry: %w", err)
+	}
+
+	engine, err := render.NewEngine(
+		in.manifestDir,
+		workDir,
+		templ.NewTemplateRenderer(),
+		registry,
+		in.manifest.Values,
+		in.secretValues,
+		in.externalFilesValues,  // raw -f values
+		in.flagValueOverwrites,  // raw -v values
+		in.resolvedTargetValues, // pre-computed target values for .targets scope
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating render engine: %w", err)
+	}
+	return engine, nil
+}
+
+var renderFlags = struct {
+	valuesFlags
 
 	// target selector flags:
 	targets    []string
@@ -49,13 +151,11 @@ var renderCmd = &cobra.Command{
 			}
 		}
 
-		renderer := templ.NewTemplateRenderer()
-
-		manifest, manifestDir, err := render.ReadManifest(ctx, renderFlags.manifestPath)
+		inputs, err := renderFlags.load(ctx)
 		if err != nil {
-			return fmt.Errorf("reading manifest: %w", err)
+			return err
 		}
-		targets, err := render.SelectTargets(manifest, renderFlags.allTargets, renderFlags.targets, renderFlags.tags)
+		targets, err := render.SelectTargets(inputs.manifest, renderFlags.allTargets, renderFlags.targets, renderFlags.tags)
 		if err != nil {
 			return fmt.Errorf("selecting targets: %w", err)
 		}
@@ -66,34 +166,6 @@ var renderCmd = &cobra.Command{
 			log.Info().Msgf("selected render target: %s", t.ID)
 		}
 
-		// load any external values files (-f)
-		externalFilesValues, err := render.ParseValuesOverwrites(ctx, renderFlags.valuesFiles)
-		if err != nil {
-			return fmt.Errorf("loading external values files: %w", err)
-		}
-
-		flagValueOverwrites, err := render.ParseStringToStringValuesOverwrites(ctx, renderFlags.valueOverwrites)
-		if err != nil {
-			return fmt.Errorf("loading flag string overwrites: %w", err)
-		}
-
-		secretValues, err := render.LoadValuesFiles(ctx, renderFlags.secretFiles)
-		if err != nil {
-			return fmt.Errorf("loading secret values files: %w", err)
-		}
-
-		// setup logging redaction for sensitive values
-		sensitiveStrings := render.CollectStrings(secretValues)
-		logging.Init(sensitiveStrings)
-		log.Debug().Int("count", len(sensitiveStrings)).
-			Msg("initialized logging with sensitive values redaction")
-
-		log.Debug().Msg("pre-computing final values for all targets...")
-		resolvedTargetValues, err := render.PreComputeAllTargetValues(manifest, externalFilesValues, flagValueOverwrites)
-		if err != nil {
-			return fmt.Errorf("pre-computing target values: %w", err)
-		}
-
 		// rendering always happens in a temporary directory, and this directory will _always_ be deleted after rendering
 		// when --no-compress and an output is specified, this directory will be _moved_ after rendering
 		workDir, err := os.MkdirTemp("", "gok-workdir-")
@@ -114,24 +186,9 @@ var renderCmd = &cobra.Command{
 		}()
 		log.Debug().Msgf("created temporary directory: %s", workDir)
 
-		registry, err := newStrategyRegistry()
-		if err != nil {
-			return fmt.Errorf("creating strategy registry: %w", err)
-		}
-
-		engine, err := render.NewEngine(
-			manifestDir,
-			workDir,
-			renderer,
-			registry,
-			manifest.Values,
-			secretValues,
-			externalFilesValues,  // raw -f values
-			flagValueOverwrites,  // raw -v values
-			resolvedTargetValues, // pre-computed target values for .targets scope
-		)
+		engine, err := inputs.newEngine(workDir)
 		if err != nil {
-			return fmt.Errorf("creating render engine: %w", err)
+			return err
 		}
 
 		if err := engine.RenderTargets(ctx, targets); err != nil {
@@ -142,7 +199,11 @@ var renderCmd = &cobra.Command{
 			return fmt.Errorf("resolving artifacts: %w", err)
 		}
 
-		if err := lockfile.Create(ctx, workDir); err != nil {
+		if err := engine.ApplyPermissions(); err != nil {
+			return fmt.Errorf("applying permissions: %w", err)
+		}
+
+		if err := lockfile.Create(ctx, workDir, engine); err != nil {
 			return fmt.Errorf("creating lock file: %w", err)
 		}
 
@@ -170,17 +231,21 @@ var renderCmd = &cobra.Command{
 			return nil
 		}
 
-		compress := false
-		switch {
-		case strings.HasSuffix(renderFlags.outPath, ".tar.gz"):
-			compress = true
-		case strings.HasSuffix(renderFlags.outPath, ".tar"):
-			compress = false
-		default:
-			return fmt.Errorf("unsupported archive extension %q (supported: .tar.gz)", ext)
+		format, ok := archive.FormatFor(renderFlags.outPath)
+		if !ok {
+			return fmt.Errorf("unsupported archive extension %q (supported: %s)",
+				ext, strings.Join(archive.SupportedExtensions(), ", "))
+		}
+
+		lock, err := lockfile.Read(workDir)
+		if err != nil {
+			return fmt.Errorf("reading lock file: %w", err)
 		}
+		ownership := make(lockfile.LockFiles, len(lock.Files)+len(lock.Seeds))
+		maps.Copy(ownership, lock.Files)
+		maps.Copy(ownership, lock.Seeds)
 
-		if err := archive.Create(workDir, renderFlags.outPath, compress); err != nil {
+		if err := archive.Create(workDir, renderFlags.outPath, format, archive.WithOwnership(ownership)); err != nil {
 			return fmt.Errorf("creating archive %q: %w", renderFlags.outPath, err)
 		}
 		log.Info().Str("path", renderFlags.outPath).Msg("wrote rendered files to archive")
@@ -191,14 +256,7 @@ var renderCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(renderCmd)
 
-	renderCmd.Flags().StringVarP(&renderFlags.manifestPath, "manifest", "m", internal.ManifestFileName,
-		"Path to the manifest file")
-	renderCmd.Flags().StringSliceVarP(&renderFlags.valuesFiles, "values-from", "f", []string{},
-		"Additional values files to merge, merged left to right")
-	renderCmd.Flags().StringToStringVarP(&renderFlags.valueOverwrites, "values-overwrites", "v",
-		make(map[string]string), "Additional values to overwrite. These have the highest precedence.")
-	renderCmd.Flags().StringSliceVarP(&renderFlags.secretFiles, "secrets", "s", []string{},
-		"Additional secrets files to merge, merged left to right")
+	renderFlags.register(renderCmd)
 
 	renderCmd.Flags().StringSliceVarP(&renderFlags.targets, "targets", "t", []string{},
 		"List of targets to render (comma-separated)")
@@ -213,7 +271,7 @@ func init() {
 	renderCmd.MarkFlagsOneRequired("targets", "tags", "all-targets")
 
 	renderCmd.Flags().StringVarP(&renderFlags.outPath, "out", "o", "",
-		"Output path for rendered files")
+		"Output path for rendered files: a directory (no extension) or an archive (.tar, .tar.gz, .tgz, .tar.zst, .zip)")
 }
 
 func newStrategyRegistry() (*strategy.Registry, error) {
@@ -268,6 +326,8 @@ FILE OPERATIONS
 - Files ending in '.properties' are merged, not overwritten.
 - Files with a '` + internal.TemplateInfix + `' extension (e.g., 'server` + internal.TemplateInfix + `.properties') are processed by the
   Go template engine before being written to their final destination (e.g., 'server.properties').
+- Files with a '` + internal.SeedInfix + `' infix (e.g., 'permissions` + internal.SeedInfix + `.yml') are seeds: they are only created
+  in the destination if absent and never managed afterward.
 - A '` + internal.DeletionFileName + `' file within a template directory can be used to explicitly remove files
   that were added by a previously applied (e.g., inherited) template.
 
@@ -288,6 +348,10 @@ order of precedence (later values override earlier ones):
   # Render a target using an external values file for environment-specific config
   gok render -t survival -f survival-prod-values.yaml -o survival.tar.gz
   
+  # Render a zstd-compressed or zip archive
+  gok render -t survival -o survival.tar.zst
+  gok render -t survival -o survival.zip
+
   # Override values by specifying multiple files (last one wins)
   gok render -t proxy -f common.yaml -f dev.yaml`
 )
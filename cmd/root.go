@@ -66,6 +66,10 @@ func init() {
 	rootCmd.PersistentFlags().Bool("no-color", false, "disable color output")
 	_ = viper.BindPFlag(LogNoColorKey, rootCmd.PersistentFlags().Lookup("no-color"))
 
+	rootCmd.PersistentFlags().String("lock-key-file", "",
+		"file containing the key used to sign and verify destination lock files (or set GOK_LOCK_KEY)")
+	_ = viper.BindPFlag(LockKeyFileKey, rootCmd.PersistentFlags().Lookup("lock-key-file"))
+
 	viper.SetEnvPrefix("GOK")
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
 	viper.AutomaticEnv() // read in environment variables that match
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+
+	"github.com/sap-gg/gok/internal/lockfile"
+)
+
+const (
+	// LockKeyKey is the key signing the lock files of destinations, only read from the config or GOK_LOCK_KEY
+	// to keep it out of the process list
+	LockKeyKey = "lock.key"
+	// LockKeyFileKey is the path of a file containing the key, it takes precedence over LockKeyKey
+	LockKeyFileKey = "lock.key_file"
+)
+
+// lockKey returns the key for signing and verifying the lock files of destinations, nil if none is configured.
+func lockKey() ([]byte, error) {
+	if path := viper.GetString(LockKeyFileKey); path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading lock key: %w", err)
+		}
+		key := bytes.TrimSpace(content)
+		if len(key) == 0 {
+			return nil, fmt.Errorf("lock key file %s is empty", path)
+		}
+		return key, nil
+	}
+	if key := viper.GetString(LockKeyKey); key != "" {
+		return []byte(key), nil
+	}
+	return nil, nil
+}
+
+// verifyLockSignature checks the signature of the lock file of the destination directory using key.
+// Without key, signed lock files can't be verified: if rewrite is set (i.e. the lock file is going to be
+// replaced by an unsigned one), this is an error, otherwise a warning is logged.
+// Errors wrap lockfile.ErrUnsigned or lockfile.ErrTampered.
+func verifyLockSignature(dir string, key []byte, rewrite bool) error {
+	content, err := lockfile.ReadEncoded(dir)
+	if err != nil {
+		return fmt.Errorf("%s: %w", dir, err)
+	}
+	if content == nil {
+		return nil // nothing applied yet
+	}
+
+	if key == nil {
+		if !lockfile.IsSigned(content) {
+			return nil
+		}
+		if rewrite {
+			return fmt.Errorf("the lock file of %s is signed, provide its key using --lock-key-file or GOK_LOCK_KEY", dir)
+		}
+		log.Warn().Msgf("the lock file of %s is signed, but no key was provided, its signature is not verified", dir)
+		return nil
+	}
+
+	switch err := lockfile.VerifySignature(content, key); {
+	case errors.Is(err, lockfile.ErrTampered):
+		color.New(color.FgHiRed, color.Bold).Fprintf(os.Stderr,
+			"!!! The lock file of %s was modified outside of gok !!!\n"+
+				"Its entries can't be trusted to detect manual changes. Inspect the destination before applying to it.\n", dir)
+		return fmt.Errorf("%s: %w", dir, err)
+	case errors.Is(err, lockfile.ErrUnsigned):
+		return fmt.Errorf("%s: %w, but a lock key is configured, sign it once using 'gok apply --accept-unsigned-lock'",
+			dir, err)
+	case err != nil:
+		return fmt.Errorf("%s: %w", dir, err)
+	}
+	log.Debug().Msgf("verified the signature of the lock file of %s", dir)
+	return nil
+}
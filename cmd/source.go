@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sap-gg/gok/internal"
+	"github.com/sap-gg/gok/internal/archive"
+	"github.com/sap-gg/gok/internal/lockfile"
+	"github.com/sap-gg/gok/internal/render"
+)
+
+// stdinSource is the source name for reading an archive from stdin.
+const stdinSource = "-"
+
+// openDesiredState makes the desired state of source available as a directory.
+// The source is either a rendered directory (used as-is), an archive, or stdinSource to read an archive from stdin.
+// The returned cleanup function removes any temporary files and must always be called.
+func openDesiredState(source string) (dir string, cleanup func(), err error) {
+	cleanup = func() {}
+
+	if source != stdinSource {
+		info, err := os.Stat(source)
+		if err != nil {
+			return "", cleanup, fmt.Errorf("stat source %q: %w", source, err)
+		}
+		if info.IsDir() {
+			if _, err := os.Stat(filepath.Join(source, internal.LockFileName)); err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return "", cleanup, fmt.Errorf("source directory %q contains no %s, is it a rendered output?",
+						source, internal.LockFileName)
+				}
+				return "", cleanup, fmt.Errorf("check lock file in %q: %w", source, err)
+			}
+			log.Info().Msgf("reading desired state from directory %s", source)
+			return source, cleanup, nil
+		}
+	}
+
+	tempDir, err := os.MkdirTemp("", "gok-desired-")
+	if err != nil {
+		return "", cleanup, fmt.Errorf("create temp dir for desired state: %w", err)
+	}
+	cleanup = func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			log.Debug().Err(err).Msg("failed to remove temporary directory")
+		}
+	}
+
+	if source == stdinSource {
+		log.Info().Msg("reading desired state from stdin")
+		err = archive.ExtractReader(os.Stdin, tempDir)
+	} else {
+		log.Info().Msgf("reading desired state from artifact %s", source)
+		err = archive.Extract(source, tempDir)
+	}
+	if err != nil {
+		return "", cleanup, fmt.Errorf("extract source artifact: %w", err)
+	}
+	return tempDir, cleanup, nil
+}
+
+// selectTarget reads the lock file of the desired state in dir. If target is set, the directory and lock file
+// are narrowed down to the output of this target, otherwise they are returned as-is.
+func selectTarget(dir, target string) (string, *lockfile.LockFile, error) {
+	if _, err := os.Stat(filepath.Join(dir, internal.LockFileName)); err != nil {
+		return "", nil, fmt.Errorf("desired state contains no %s: %w", internal.LockFileName, err)
+	}
+	lock, err := lockfile.Read(dir)
+	if err != nil {
+		return "", nil, fmt.Errorf("reading desired lock file: %w", err)
+	}
+	if target == "" {
+		return dir, lock, nil
+	}
+
+	scoped, output, err := lock.ForTarget(target)
+	if err != nil {
+		return "", nil, err
+	}
+	resolver, err := render.NewGenericPathResolver(dir)
+	if err != nil {
+		return "", nil, fmt.Errorf("desired state resolver: %w", err)
+	}
+	// the output is read from the (untrusted) artifact, so it must not escape the desired state
+	targetDir, err := resolver.Resolve(filepath.FromSlash(output))
+	if err != nil {
+		return "", nil, fmt.Errorf("resolve output of target %q: %w", target, err)
+	}
+	log.Info().Msgf("selected target %s (output %q, %d files)", target, output, len(scoped.Files))
+	return targetDir, scoped, nil
+}
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+
+	"github.com/sap-gg/gok/internal"
+	"github.com/sap-gg/gok/internal/diff"
+)
+
+var statusFlags = struct {
+	output       string
+	ignore       []string
+	noUntracked  bool
+	allUntracked bool
+}{}
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:     "status <dir> [glob...]",
+	Short:   "Detects drift of a destination directory against its own lock file.",
+	Long:    statusLongDescription,
+	Example: statusExample,
+	Args:    cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := args[0]
+
+		if statusFlags.output != "text" && statusFlags.output != "json" {
+			return fmt.Errorf("unsupported output format %q (supported: text, json)", statusFlags.output)
+		}
+
+		warnIfLocked(dir)
+		warnIfInterrupted(dir)
+		key, err := lockKey()
+		if err != nil {
+			return err
+		}
+		if err := verifyLockSignature(dir, key, false); err != nil {
+			return err
+		}
+		report, err := diff.Status(dir, diff.StatusOptions{
+			Include:      args[1:],
+			Ignore:       statusFlags.ignore,
+			Untracked:    !statusFlags.noUntracked,
+			AllUntracked: statusFlags.allUntracked,
+		})
+		if err != nil {
+			return fmt.Errorf("checking status: %w", err)
+		}
+
+		if statusFlags.output == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				return fmt.Errorf("encoding status report: %w", err)
+			}
+		} else {
+			printStatusReport(report)
+		}
+
+		if report.HasDrift() {
+			return fmt.Errorf("drift detected in %q", dir)
+		}
+		log.Info().Msg("no drift detected. Managed files match the lock file.")
+		return nil
+	},
+}
+
+func printStatusReport(report *diff.StatusReport) {
+	for _, f := range report.Files {
+		switch f.Status {
+		case diff.Drifted:
+			color.Yellow("~ %s (drifted)", f.Path)
+		case diff.Missing:
+			color.Red("- %s (missing)", f.Path)
+		case diff.Local:
+			color.Cyan("~ %s (local, kept by policy)", f.Path)
+		case diff.Untracked:
+			color.White("? %s (untracked)", f.Path)
+		}
+		if f.Source != nil {
+			fmt.Printf("    source: %s\n", f.Source)
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	statusCmd.Flags().StringVarP(&statusFlags.output, "output", "o", "text",
+		"Output format: text, json")
+	statusCmd.Flags().StringSliceVar(&statusFlags.ignore, "ignore", []string{},
+		"Globs of untracked files or directories to ignore, e.g. world/ or logs/ (comma-separated)")
+	statusCmd.Flags().BoolVar(&statusFlags.noUntracked, "no-untracked", false,
+		"Do not report untracked files.")
+	statusCmd.Flags().BoolVar(&statusFlags.allUntracked, "all-untracked", false,
+		"Report untracked files anywhere in the destination, not only next to managed files.")
+	statusCmd.MarkFlagsMutuallyExclusive("no-untracked", "all-untracked")
+}
+
+const (
+	statusLongDescription = `The status command checks whether the files in a destination directory still
+match its own '` + internal.LockFileName + `', i.e. the state recorded by the last 'gok apply'.
+No artifact is required.
+
+It reports:
+- drifted files:   managed files whose content was changed outside of gok
+- missing files:   managed files which were removed outside of gok
+- untracked files: files which are not managed by gok (disable with --no-untracked)
+- local files:     managed files which were changed or removed outside of gok, but
+                   whose 'keep-local' conflict policy keeps the local changes on apply
+
+Like in 'gok diff', only untracked files located directly in a directory containing
+managed files or inside an exclusively managed directory are reported, so worlds, logs
+and caches are not listed. Use '--all-untracked' to report untracked files anywhere in
+the destination.
+
+The check can be limited to files matching the given globs. Seed files are never reported.
+Drifted and missing files are shown with their source recorded in the lock file, i.e.
+the target and the template layers (with their strategies) which rendered them.
+
+Files whose content is the result of a three-way merge by 'gok apply' are compared
+with the merged content, so they are only reported if they were changed since.
+
+The command exits with a non-zero exit code if drifted or missing files are found,
+so it can be used as a periodic check. Untracked and local files alone don't cause
+a failure.
+A warning is printed if an apply to the directory is in progress or was interrupted
+(see 'gok recover').
+
+If a lock key is configured (GOK_LOCK_KEY or '--lock-key-file'), the signature of the
+lock file is verified first, see 'gok apply --help'.`
+
+	statusExample = `
+# Check a server directory for manual changes
+gok status /opt/minecraft/survival
+
+# Only check plugin configs and ignore worlds and logs
+gok status /opt/minecraft/survival 'plugins/**' --ignore world/,logs/
+
+# List every file not managed by gok, e.g. before cleaning up a server
+gok status /opt/minecraft/survival --all-untracked --ignore world/,logs/
+
+# Machine-readable output for monitoring
+gok status /opt/minecraft/survival -o json`
+)
@@ -2,7 +2,9 @@ package archive
 
 import (
 	"archive/tar"
+	"archive/zip"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -10,28 +12,105 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/rs/zerolog/log"
+
+	"github.com/sap-gg/gok/internal/lockfile"
+)
+
+// Format is an archive format.
+type Format string
+
+const (
+	// FormatTar is an uncompressed tar archive (.tar)
+	FormatTar Format = "tar"
+	// FormatTarGzip is a gzip-compressed tar archive (.tar.gz, .tgz)
+	FormatTarGzip Format = "tar.gz"
+	// FormatTarZstd is a zstd-compressed tar archive (.tar.zst)
+	FormatTarZstd Format = "tar.zst"
+	// FormatZip is a zip archive (.zip). Ownership is not recorded in zip archives.
+	FormatZip Format = "zip"
 )
 
-// Create creates a tar archive from the contents of srcDir and writes it to dstPath.
-// If compress is true, the tar archive will be gzip-compressed.
-func Create(srcDir, dstPath string, compress bool) error {
+// formatsBySuffix maps file name suffixes to formats, longer suffixes first
+var formatsBySuffix = []struct {
+	suffix string
+	format Format
+}{
+	{".tar.gz", FormatTarGzip},
+	{".tgz", FormatTarGzip},
+	{".tar.zst", FormatTarZstd},
+	{".tar", FormatTar},
+	{".zip", FormatZip},
+}
+
+// FormatFor returns the archive format for the given file name based on its extension.
+func FormatFor(name string) (Format, bool) {
+	lower := strings.ToLower(name)
+	for _, f := range formatsBySuffix {
+		if strings.HasSuffix(lower, f.suffix) {
+			return f.format, true
+		}
+	}
+	return "", false
+}
+
+// SupportedExtensions returns all file extensions recognized by FormatFor.
+func SupportedExtensions() []string {
+	extensions := make([]string, 0, len(formatsBySuffix))
+	for _, f := range formatsBySuffix {
+		extensions = append(extensions, f.suffix)
+	}
+	return extensions
+}
+
+// Option configures the creation of an archive.
+type Option func(*options)
+
+type options struct {
+	ownership lockfile.LockFiles
+}
+
+// WithOwnership records the managed ownership of the given lock entries in the tar headers.
+// The uid and gid of files without managed ownership are reset to 0.
+func WithOwnership(files lockfile.LockFiles) Option {
+	return func(o *options) {
+		o.ownership = files
+	}
+}
+
+// entryWriter writes the entries of an archive of a specific format.
+type entryWriter interface {
+	// WriteEntry adds an entry for the file described by header. For regular files, content is copied.
+	WriteEntry(header *tar.Header, content io.Reader) error
+	io.Closer
+}
+
+// Create creates an archive of the given format from the contents of srcDir and writes it to dstPath.
+// Entries are added in lexical order, so the same input always results in the same order of entries.
+func Create(srcDir, dstPath string, format Format, opts ...Option) (err error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	f, err := os.Create(dstPath)
 	if err != nil {
 		return fmt.Errorf("create destination file %q: %w", dstPath, err)
 	}
-	defer f.Close()
-
-	var w io.WriteCloser = f
-	if compress {
-		gzipWriter := gzip.NewWriter(f)
-		defer gzipWriter.Close()
+	defer func() {
+		err = errors.Join(err, f.Close())
+	}()
 
-		w = gzipWriter
+	w, err := newEntryWriter(f, format)
+	if err != nil {
+		return err
 	}
-
-	tarWriter := tar.NewWriter(w)
-	defer tarWriter.Close()
+	defer func() {
+		if closeErr := w.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("finish %s archive: %w", format, closeErr))
+		}
+	}()
 
 	return filepath.Walk(srcDir, func(path string, info fs.FileInfo, err error) error {
 		if err != nil {
@@ -42,9 +121,16 @@ func Create(srcDir, dstPath string, compress bool) error {
 			return nil
 		}
 
-		header, err := tar.FileInfoHeader(info, info.Name())
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return fmt.Errorf("read symlink %q: %w", path, err)
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
 		if err != nil {
-			return fmt.Errorf("create tar header for %q: %w", path, err)
+			return fmt.Errorf("create header for %q: %w", path, err)
 		}
 
 		relPath, err := filepath.Rel(srcDir, path)
@@ -53,83 +139,115 @@ func Create(srcDir, dstPath string, compress bool) error {
 		}
 		header.Name = filepath.ToSlash(relPath)
 
-		if err := tarWriter.WriteHeader(header); err != nil {
-			return fmt.Errorf("write tar header for %q: %w", path, err)
+		if o.ownership != nil {
+			// never leak the ownership of the rendering user
+			header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+			if entry, ok := o.ownership[header.Name]; ok {
+				uid, gid := entry.Ownership()
+				header.Uid, header.Gid = max(uid, 0), max(gid, 0)
+			}
 		}
 
-		// if it's a regular file, copy its contents
-		if !info.IsDir() {
+		var content io.Reader
+		if info.Mode().IsRegular() {
 			file, err := os.Open(path)
 			if err != nil {
 				return fmt.Errorf("open file %q: %w", path, err)
 			}
 			defer file.Close()
-
-			if _, err := io.Copy(tarWriter, file); err != nil {
-				return fmt.Errorf("copy file %q to tar: %w", path, err)
-			}
-
-			log.Debug().Msgf("added file to archive: %s", header.Name)
+			content = file
 		}
 
+		if err := w.WriteEntry(header, content); err != nil {
+			return fmt.Errorf("add %q to archive: %w", path, err)
+		}
+		log.Debug().Msgf("added to archive: %s", header.Name)
 		return nil
 	})
 }
 
-func Extract(srcPath, dstDir string) error {
-	f, err := os.Open(srcPath)
-	if err != nil {
-		return fmt.Errorf("open source file %q: %w", srcPath, err)
-	}
-	defer f.Close()
-
-	var stream io.Reader = f
-	if strings.HasSuffix(srcPath, ".gz") {
-		gzipReader, err := gzip.NewReader(f)
+func newEntryWriter(w io.Writer, format Format) (entryWriter, error) {
+	switch format {
+	case FormatTar:
+		return &tarEntryWriter{tw: tar.NewWriter(w)}, nil
+	case FormatTarGzip:
+		gw := gzip.NewWriter(w)
+		return &tarEntryWriter{tw: tar.NewWriter(gw), compressor: gw}, nil
+	case FormatTarZstd:
+		zw, err := zstd.NewWriter(w)
 		if err != nil {
-			return fmt.Errorf("create gzip reader for %q: %w", srcPath, err)
+			return nil, fmt.Errorf("create zstd writer: %w", err)
 		}
-		defer gzipReader.Close()
-		stream = gzipReader
+		return &tarEntryWriter{tw: tar.NewWriter(zw), compressor: zw}, nil
+	case FormatZip:
+		return &zipEntryWriter{zw: zip.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format %q", format)
 	}
+}
 
-	tr := tar.NewReader(stream)
-	for {
-		header, err := tr.Next()
-		if err == io.EOF {
-			break // end of archive
-		}
-		if err != nil {
-			return fmt.Errorf("read tar header: %w", err)
+type tarEntryWriter struct {
+	tw *tar.Writer
+	// compressor is closed after the tar writer (if any)
+	compressor io.Closer
+}
+
+func (w *tarEntryWriter) WriteEntry(header *tar.Header, content io.Reader) error {
+	if err := w.tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("write tar header: %w", err)
+	}
+	if content != nil {
+		if _, err := io.Copy(w.tw, content); err != nil {
+			return fmt.Errorf("copy content: %w", err)
 		}
+	}
+	return nil
+}
 
-		targetPath := filepath.Join(dstDir, header.Name)
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(targetPath, fs.FileMode(header.Mode)); err != nil {
-				return fmt.Errorf("create directory %q: %w", targetPath, err)
-			}
-			log.Debug().Msgf("created directory: %s", targetPath)
-		case tar.TypeReg:
-			if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-				return fmt.Errorf("create parent directories for %q: %w", targetPath, err)
-			}
-			outFile, err := os.Create(targetPath)
-			if err != nil {
-				return fmt.Errorf("create file %q: %w", targetPath, err)
-			}
-			if _, err := io.Copy(outFile, tr); err != nil {
-				outFile.Close()
-				return fmt.Errorf("copy file contents to %q: %w", targetPath, err)
-			}
-			outFile.Close()
-			if err := os.Chmod(targetPath, fs.FileMode(header.Mode)); err != nil {
-				return fmt.Errorf("set permissions for %q: %w", targetPath, err)
-			}
-			log.Debug().Msgf("extracted file: %s", targetPath)
-		default:
-			log.Warn().Msgf("unsupported tar entry type %c for %q, skipping", header.Typeflag, header.Name)
+func (w *tarEntryWriter) Close() error {
+	err := w.tw.Close()
+	if w.compressor != nil {
+		err = errors.Join(err, w.compressor.Close())
+	}
+	return err
+}
+
+type zipEntryWriter struct {
+	zw *zip.Writer
+}
+
+func (w *zipEntryWriter) WriteEntry(header *tar.Header, content io.Reader) error {
+	info := header.FileInfo()
+	zipHeader, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return fmt.Errorf("create zip header: %w", err)
+	}
+	zipHeader.Name = header.Name
+	zipHeader.Modified = header.ModTime
+	switch {
+	case info.IsDir():
+		zipHeader.Name += "/"
+		zipHeader.Method = zip.Store
+	case info.Mode()&fs.ModeSymlink != 0:
+		// symlinks are stored with their target as content, like the Info-ZIP tools do
+		zipHeader.Method = zip.Store
+		content = strings.NewReader(header.Linkname)
+	default:
+		zipHeader.Method = zip.Deflate
+	}
+
+	fw, err := w.zw.CreateHeader(zipHeader)
+	if err != nil {
+		return fmt.Errorf("write zip header: %w", err)
+	}
+	if content != nil {
+		if _, err := io.Copy(fw, content); err != nil {
+			return fmt.Errorf("copy content: %w", err)
 		}
 	}
 	return nil
 }
+
+func (w *zipEntryWriter) Close() error {
+	return w.zw.Close()
+}
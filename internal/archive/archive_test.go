@@ -0,0 +1,236 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testEntry struct {
+	header  *tar.Header
+	content string
+}
+
+func file(name, content string) testEntry {
+	return testEntry{
+		header:  &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: int64(len(content))},
+		content: content,
+	}
+}
+
+func writeTestArchive(t *testing.T, gzipped bool, entries ...testEntry) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		require.NoError(t, tw.WriteHeader(e.header))
+		if e.content != "" {
+			_, err := tw.Write([]byte(e.content))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+
+	data := buf.Bytes()
+	if gzipped {
+		var gz bytes.Buffer
+		gw := gzip.NewWriter(&gz)
+		_, err := gw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, gw.Close())
+		data = gz.Bytes()
+	}
+
+	// intentionally without a meaningful extension, the compression must be detected by content
+	p := filepath.Join(t.TempDir(), "artifact")
+	require.NoError(t, os.WriteFile(p, data, 0o644))
+	return p
+}
+
+func TestExtract(t *testing.T) {
+	for _, gzipped := range []bool{false, true} {
+		src := writeTestArchive(t, gzipped,
+			testEntry{header: &tar.Header{Typeflag: tar.TypeDir, Name: "srv/", Mode: 0o755}},
+			file("srv/server.properties", "motd=hello"),
+			testEntry{header: &tar.Header{Typeflag: tar.TypeReg, Name: "srv/start.sh", Mode: 0o4755, Size: 2}, content: "sh"},
+			testEntry{header: &tar.Header{Typeflag: tar.TypeSymlink, Name: "srv/link.yml", Linkname: "../shared.yml"}},
+		)
+		dst := t.TempDir()
+		require.NoError(t, Extract(src, dst))
+
+		content, err := os.ReadFile(filepath.Join(dst, "srv", "server.properties"))
+		require.NoError(t, err)
+		assert.Equal(t, "motd=hello", string(content))
+
+		info, err := os.Stat(filepath.Join(dst, "srv", "start.sh"))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o755), info.Mode(), "setuid bit must be dropped")
+
+		link, err := os.Readlink(filepath.Join(dst, "srv", "link.yml"))
+		require.NoError(t, err)
+		assert.Equal(t, "../shared.yml", link)
+	}
+}
+
+func TestExtractReadOnlyDirectories(t *testing.T) {
+	src := writeTestArchive(t, false,
+		testEntry{header: &tar.Header{Typeflag: tar.TypeDir, Name: "srv/", Mode: 0o500}},
+		testEntry{header: &tar.Header{Typeflag: tar.TypeDir, Name: "srv/locked/", Mode: 0o000}},
+		file("srv/locked/server.properties", "motd=hello"),
+		file("srv/start.sh", "sh"),
+	)
+	dst := t.TempDir()
+	t.Cleanup(func() {
+		// allow the temporary directory to be removed
+		_ = os.Chmod(filepath.Join(dst, "srv"), 0o755)
+		_ = os.Chmod(filepath.Join(dst, "srv", "locked"), 0o755)
+	})
+	require.NoError(t, Extract(src, dst), "entries below read-only directories must be extracted")
+
+	info, err := os.Stat(filepath.Join(dst, "srv"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o500), info.Mode().Perm())
+	info, err = os.Stat(filepath.Join(dst, "srv", "locked"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o000), info.Mode().Perm())
+
+	require.NoError(t, os.Chmod(filepath.Join(dst, "srv", "locked"), 0o755))
+	content, err := os.ReadFile(filepath.Join(dst, "srv", "locked", "server.properties"))
+	require.NoError(t, err)
+	assert.Equal(t, "motd=hello", string(content))
+}
+
+func TestExtractRejectsMaliciousArchives(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []testEntry
+		limits  Limits
+	}{
+		{
+			name:    "parent traversal",
+			entries: []testEntry{file("../evil.txt", "x")},
+		},
+		{
+			name:    "nested parent traversal",
+			entries: []testEntry{file("srv/../../evil.txt", "x")},
+		},
+		{
+			name:    "absolute path",
+			entries: []testEntry{file("/tmp/evil.txt", "x")},
+		},
+		{
+			name: "write through symlink",
+			entries: []testEntry{
+				{header: &tar.Header{Typeflag: tar.TypeSymlink, Name: "srv", Linkname: "/etc"}},
+				file("srv/passwd", "x"),
+			},
+		},
+		{
+			name: "overwrite symlink",
+			entries: []testEntry{
+				{header: &tar.Header{Typeflag: tar.TypeSymlink, Name: "passwd", Linkname: "/etc/passwd"}},
+				file("passwd", "x"),
+			},
+		},
+		{
+			name:    "device file",
+			entries: []testEntry{{header: &tar.Header{Typeflag: tar.TypeChar, Name: "null", Devmajor: 1, Devminor: 3}}},
+		},
+		{
+			name:    "hard link",
+			entries: []testEntry{{header: &tar.Header{Typeflag: tar.TypeLink, Name: "passwd", Linkname: "/etc/passwd"}}},
+		},
+		{
+			name:    "file size limit",
+			entries: []testEntry{file("big.bin", "0123456789")},
+			limits:  Limits{MaxFileSize: 5},
+		},
+		{
+			name:    "total size limit",
+			entries: []testEntry{file("a.bin", "01234"), file("b.bin", "56789")},
+			limits:  Limits{MaxTotalSize: 8},
+		},
+		{
+			name:    "entry limit",
+			entries: []testEntry{file("a.txt", "a"), file("b.txt", "b"), file("c.txt", "c")},
+			limits:  Limits{MaxEntries: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := writeTestArchive(t, false, tt.entries...)
+			parent := t.TempDir()
+			dst := filepath.Join(parent, "dst")
+			require.NoError(t, os.Mkdir(dst, 0o755))
+
+			limits := DefaultLimits
+			if tt.limits != (Limits{}) {
+				limits = tt.limits
+			}
+			assert.Error(t, Extract(src, dst, WithLimits(limits)))
+
+			_, err := os.Stat(filepath.Join(parent, "evil.txt"))
+			assert.ErrorIs(t, err, os.ErrNotExist)
+		})
+	}
+}
+
+func TestCreateAndExtractFormats(t *testing.T) {
+	srcDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(srcDir, "srv", "logs"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "srv", "server.properties"), []byte("motd=hello"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "srv", "start.sh"), []byte("#!/bin/sh"), 0o755))
+	require.NoError(t, os.Symlink("server.properties", filepath.Join(srcDir, "srv", "link.properties")))
+
+	for _, name := range []string{"out.tar", "out.tar.gz", "out.tgz", "out.tar.zst", "out.zip"} {
+		t.Run(name, func(t *testing.T) {
+			format, ok := FormatFor(name)
+			require.True(t, ok)
+
+			// the extension is not needed for extraction
+			archivePath := filepath.Join(t.TempDir(), "artifact")
+			require.NoError(t, Create(srcDir, archivePath, format))
+
+			dstDir := t.TempDir()
+			require.NoError(t, Extract(archivePath, dstDir))
+
+			content, err := os.ReadFile(filepath.Join(dstDir, "srv", "server.properties"))
+			require.NoError(t, err)
+			assert.Equal(t, "motd=hello", string(content))
+
+			info, err := os.Stat(filepath.Join(dstDir, "srv", "start.sh"))
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0o755), info.Mode().Perm())
+
+			info, err = os.Stat(filepath.Join(dstDir, "srv", "logs"))
+			require.NoError(t, err)
+			assert.True(t, info.IsDir())
+
+			link, err := os.Readlink(filepath.Join(dstDir, "srv", "link.properties"))
+			require.NoError(t, err)
+			assert.Equal(t, "server.properties", link)
+
+			t.Run("should extract from a stream", func(t *testing.T) {
+				data, err := os.ReadFile(archivePath)
+				require.NoError(t, err)
+
+				dstDir := t.TempDir()
+				require.NoError(t, ExtractReader(bytes.NewReader(data), dstDir))
+
+				content, err := os.ReadFile(filepath.Join(dstDir, "srv", "server.properties"))
+				require.NoError(t, err)
+				assert.Equal(t, "motd=hello", string(content))
+			})
+		})
+	}
+
+	_, ok := FormatFor("out.rar")
+	assert.False(t, ok)
+}
@@ -0,0 +1,394 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rs/zerolog/log"
+
+	"github.com/sap-gg/gok/internal/render"
+)
+
+// Limits restrict the resources an archive may use when it's extracted.
+// A limit of 0 disables the check.
+type Limits struct {
+	// MaxTotalSize is the maximum sum of the sizes of all files in bytes
+	MaxTotalSize int64
+	// MaxFileSize is the maximum size of a single file in bytes
+	MaxFileSize int64
+	// MaxEntries is the maximum number of entries (files, directories and symlinks)
+	MaxEntries int
+}
+
+// DefaultLimits are the limits used by Extract if no other limits are given.
+var DefaultLimits = Limits{
+	MaxTotalSize: 8 << 30, // 8 GiB
+	MaxFileSize:  2 << 30, // 2 GiB
+	MaxEntries:   100_000,
+}
+
+// ExtractOption configures the extraction of an archive.
+type ExtractOption func(*extractOptions)
+
+type extractOptions struct {
+	limits Limits
+}
+
+// WithLimits overrides the DefaultLimits.
+func WithLimits(limits Limits) ExtractOption {
+	return func(o *extractOptions) {
+		o.limits = limits
+	}
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagic  = []byte("PK\x03\x04")
+	// zipEmptyMagic is the end of central directory record, an empty zip archive only consists of it
+	zipEmptyMagic = []byte("PK\x05\x06")
+)
+
+// Extract extracts the archive at srcPath into dstDir.
+// The format (tar, tar.gz, tar.zst or zip) is detected by the content of the archive, not by its file extension.
+//
+// Archives are treated as untrusted input: entries must not escape dstDir (neither directly nor through
+// previously extracted symlinks), device files and hard links are rejected, modes are restricted to
+// permission bits and the Limits are enforced.
+func Extract(srcPath, dstDir string, opts ...ExtractOption) error {
+	f, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("open source file %q: %w", srcPath, err)
+	}
+	defer f.Close()
+
+	if err := extract(f, dstDir, opts...); err != nil {
+		return fmt.Errorf("extract %q: %w", srcPath, err)
+	}
+	return nil
+}
+
+// ExtractReader extracts the archive read from r into dstDir, see Extract.
+// Zip archives can't be read as a stream, they are buffered in a temporary file first.
+func ExtractReader(r io.Reader, dstDir string, opts ...ExtractOption) error {
+	return extract(r, dstDir, opts...)
+}
+
+func extract(r io.Reader, dstDir string, opts ...ExtractOption) error {
+	o := extractOptions{limits: DefaultLimits}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	x, err := newExtractor(dstDir, o.limits)
+	if err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(zipMagic))
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("detect format: %w", err)
+	}
+	if bytes.HasPrefix(magic, zipMagic) || bytes.HasPrefix(magic, zipEmptyMagic) {
+		if f, ok := r.(*os.File); ok {
+			if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+				return x.extractZip(f, info.Size())
+			}
+		}
+		return x.extractZipStream(br)
+	}
+
+	stream, err := decompress(br)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	return x.extractTar(stream)
+}
+
+// extractZipStream buffers the zip archive read from r in a temporary file and extracts it.
+func (x *extractor) extractZipStream(r io.Reader) error {
+	tmp, err := os.CreateTemp("", "gok-archive-*.zip")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// the buffered archive is subject to the total size limit as well
+	if x.limits.MaxTotalSize > 0 {
+		r = io.LimitReader(r, x.limits.MaxTotalSize+1)
+	}
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		return fmt.Errorf("buffer zip archive: %w", err)
+	}
+	if x.limits.MaxTotalSize > 0 && size > x.limits.MaxTotalSize {
+		return fmt.Errorf("archive exceeds the limit of %d bytes", x.limits.MaxTotalSize)
+	}
+	return x.extractZip(tmp, size)
+}
+
+// decompress detects the compression of the stream by its magic bytes.
+func decompress(r *bufio.Reader) (io.ReadCloser, error) {
+	magic, err := r.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("detect compression: %w", err)
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gzipReader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("create gzip reader: %w", err)
+		}
+		return gzipReader, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zstdReader, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("create zstd reader: %w", err)
+		}
+		return zstdReader.IOReadCloser(), nil
+	default:
+		return io.NopCloser(r), nil
+	}
+}
+
+func newExtractor(dstDir string, limits Limits) (*extractor, error) {
+	resolver, err := render.NewGenericPathResolver(dstDir)
+	if err != nil {
+		return nil, fmt.Errorf("destination resolver: %w", err)
+	}
+	return &extractor{
+		resolver: resolver,
+		limits:   limits,
+		symlinks: make(map[string]struct{}),
+		seen:     make(map[string]struct{}),
+		dirModes: make(map[string]fs.FileMode),
+	}, nil
+}
+
+func (x *extractor) extractTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return x.applyDirModes() // end of archive
+		}
+		// insecure paths are rejected by extract
+		if err != nil && !errors.Is(err, tar.ErrInsecurePath) {
+			return fmt.Errorf("read tar header: %w", err)
+		}
+		if err := x.extract(header, tr); err != nil {
+			return fmt.Errorf("extract %q: %w", header.Name, err)
+		}
+	}
+}
+
+func (x *extractor) extractZip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	// insecure paths are rejected for every entry below
+	if err != nil && !errors.Is(err, zip.ErrInsecurePath) {
+		return fmt.Errorf("read zip archive: %w", err)
+	}
+	for _, f := range zr.File {
+		if err := x.extractZipFile(f); err != nil {
+			return fmt.Errorf("extract %q: %w", f.Name, err)
+		}
+	}
+	return x.applyDirModes()
+}
+
+// extractZipFile converts the zip entry into a tar header, so the same checks apply to all formats.
+func (x *extractor) extractZipFile(f *zip.File) error {
+	mode := f.Mode()
+	header := &tar.Header{
+		Name:    f.Name,
+		Mode:    int64(mode.Perm()),
+		Size:    int64(f.UncompressedSize64),
+		ModTime: f.Modified,
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("open zip entry: %w", err)
+	}
+	defer rc.Close()
+
+	switch {
+	case mode.IsDir():
+		header.Typeflag = tar.TypeDir
+	case mode&fs.ModeSymlink != 0:
+		header.Typeflag = tar.TypeSymlink
+		// the link target is stored as content
+		link, err := io.ReadAll(io.LimitReader(rc, 4096))
+		if err != nil {
+			return fmt.Errorf("read symlink target: %w", err)
+		}
+		header.Linkname = string(link)
+		header.Size = 0
+	case mode&(fs.ModeDevice|fs.ModeCharDevice) != 0:
+		header.Typeflag = tar.TypeChar
+	case mode&fs.ModeNamedPipe != 0:
+		header.Typeflag = tar.TypeFifo
+	case mode.IsRegular():
+		header.Typeflag = tar.TypeReg
+	default:
+		header.Typeflag = 0xff // unsupported
+	}
+	return x.extract(header, rc)
+}
+
+// extractor keeps track of the state needed to safely extract the entries of a single archive.
+type extractor struct {
+	resolver *render.GenericPathResolver
+	limits   Limits
+
+	entries   int
+	totalSize int64
+
+	// symlinks contains the cleaned, slash-separated names of all extracted symlinks
+	symlinks map[string]struct{}
+	// seen contains the cleaned, slash-separated names of all extracted non-directory entries
+	seen map[string]struct{}
+	// dirModes are the modes of the directory entries by their absolute path, applied after all entries
+	// were extracted, as read-only directories would prevent the extraction of their entries
+	dirModes map[string]fs.FileMode
+}
+
+func (x *extractor) extract(header *tar.Header, r io.Reader) error {
+	switch header.Typeflag {
+	case tar.TypeXGlobalHeader:
+		return nil // only metadata
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		return fmt.Errorf("device files are not allowed")
+	case tar.TypeLink:
+		return fmt.Errorf("hard links are not allowed")
+	}
+
+	x.entries++
+	if x.limits.MaxEntries > 0 && x.entries > x.limits.MaxEntries {
+		return fmt.Errorf("archive contains more than %d entries", x.limits.MaxEntries)
+	}
+
+	name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+	if path.IsAbs(name) {
+		// tar names are always slash-separated, which filepath.IsAbs doesn't detect on Windows
+		return fmt.Errorf("path must be relative: %q", name)
+	}
+	targetPath, err := x.resolver.Resolve(name)
+	if err != nil {
+		return err
+	}
+	if err := x.checkParents(name); err != nil {
+		return err
+	}
+	if header.Typeflag != tar.TypeDir {
+		if _, ok := x.seen[name]; ok {
+			return fmt.Errorf("duplicate entry")
+		}
+		x.seen[name] = struct{}{}
+	}
+
+	// only keep the permission bits, e.g. no setuid or sticky bits
+	mode := fs.FileMode(header.Mode).Perm()
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(targetPath, 0755); err != nil {
+			return fmt.Errorf("create directory %q: %w", targetPath, err)
+		}
+		x.dirModes[targetPath] = mode
+		log.Debug().Msgf("created directory: %s", targetPath)
+	case tar.TypeReg:
+		if x.limits.MaxFileSize > 0 && header.Size > x.limits.MaxFileSize {
+			return fmt.Errorf("file size %d exceeds the limit of %d bytes", header.Size, x.limits.MaxFileSize)
+		}
+		x.totalSize += header.Size
+		if x.limits.MaxTotalSize > 0 && x.totalSize > x.limits.MaxTotalSize {
+			return fmt.Errorf("total size exceeds the limit of %d bytes", x.limits.MaxTotalSize)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+			return fmt.Errorf("create parent directories for %q: %w", targetPath, err)
+		}
+		outFile, err := os.OpenFile(targetPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+		if err != nil {
+			return fmt.Errorf("create file %q: %w", targetPath, err)
+		}
+		// never trust the size of the header, e.g. zip entries may contain more data than declared
+		n, err := io.Copy(outFile, io.LimitReader(r, header.Size+1))
+		if err != nil {
+			outFile.Close()
+			return fmt.Errorf("copy file contents to %q: %w", targetPath, err)
+		}
+		if n > header.Size {
+			outFile.Close()
+			return fmt.Errorf("file contains more data than declared")
+		}
+		if err := outFile.Close(); err != nil {
+			return fmt.Errorf("close file %q: %w", targetPath, err)
+		}
+		// the mode passed to OpenFile is subject to the umask
+		if err := os.Chmod(targetPath, mode); err != nil {
+			return fmt.Errorf("set permissions for %q: %w", targetPath, err)
+		}
+		log.Debug().Msgf("extracted file: %s", targetPath)
+	case tar.TypeSymlink:
+		if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+			return fmt.Errorf("create parent directories for %q: %w", targetPath, err)
+		}
+		if err := os.Symlink(header.Linkname, targetPath); err != nil {
+			return fmt.Errorf("create symlink %q: %w", targetPath, err)
+		}
+		x.symlinks[name] = struct{}{}
+		log.Debug().Msgf("extracted symlink: %s -> %s", targetPath, header.Linkname)
+	default:
+		log.Warn().Msgf("unsupported entry type %c for %q, skipping", header.Typeflag, header.Name)
+	}
+	return nil
+}
+
+// applyDirModes sets the modes of all extracted directory entries, deepest directories first,
+// so restricting the mode of a directory doesn't prevent changing the modes below it.
+func (x *extractor) applyDirModes() error {
+	dirs := slices.Collect(maps.Keys(x.dirModes))
+	slices.SortFunc(dirs, func(a, b string) int {
+		return cmp.Or(
+			cmp.Compare(strings.Count(b, string(filepath.Separator)), strings.Count(a, string(filepath.Separator))),
+			strings.Compare(a, b),
+		)
+	})
+	for _, dir := range dirs {
+		if err := os.Chmod(dir, x.dirModes[dir]); err != nil {
+			return fmt.Errorf("set permissions for %q: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// checkParents returns an error if any parent of the entry is a previously extracted symlink,
+// as the entry would be written "through" the link, possibly outside the destination.
+func (x *extractor) checkParents(name string) error {
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if _, ok := x.symlinks[dir]; ok {
+			return fmt.Errorf("entry is located inside symlink %q", dir)
+		}
+	}
+	return nil
+}
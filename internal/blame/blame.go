@@ -0,0 +1,382 @@
+package blame
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/sap-gg/gok/internal/lockfile"
+	"github.com/sap-gg/gok/internal/merge"
+	"github.com/sap-gg/gok/internal/render"
+)
+
+// valueScopes are the fields of the template data which hold values, see render.buildTemplateContext
+var valueScopes = []string{"values", "secrets", "targets"}
+
+// Line is the blame of a single key of a structured file or a single line of any other file.
+type Line struct {
+	// Key is the key (in dot-notation) of structured files, empty for other files.
+	Key string `json:"key,omitempty"`
+	// Number is the line number (starting at 1) of other files, 0 for structured files.
+	Number int `json:"number,omitempty"`
+	// Value is the final value of the key or the content of the line.
+	Value string `json:"value"`
+	// Layer is the template layer which set the value, nil if it's unknown.
+	Layer *lockfile.SourceLayer `json:"layer,omitempty"`
+	// ValueKeys are the keys of the template data the value was rendered from, e.g. values.server.motd.
+	// Only set if the layer rendered the file as template.
+	ValueKeys []string `json:"valueKeys,omitempty"`
+}
+
+// Result is the blame of a single output file.
+type Result struct {
+	Path string `json:"path"`
+	// Target is the ID of the target the file was rendered for, set by the caller.
+	Target string `json:"target,omitempty"`
+	// Structured is true if the file was blamed per key instead of per line.
+	Structured bool `json:"structured"`
+	// Layers are all template layers which applied the file, in order.
+	Layers []*lockfile.SourceLayer `json:"layers"`
+	// Artifact is true if the file is downloaded from an artifact spec, its content is not blamed.
+	Artifact bool    `json:"artifact,omitempty"`
+	Lines    []*Line `json:"lines"`
+}
+
+// contribution is the content a single template layer applied to the blamed file.
+type contribution struct {
+	file *render.AppliedFile
+	// reset is true if the file was deleted, the previous contributions no longer matter
+	reset bool
+}
+
+// Blamer collects the contributions of template layers to a single output file while a target is rendered.
+// It's registered using render.Engine.Observe.
+type Blamer struct {
+	dst           string
+	contributions []*contribution
+}
+
+var _ render.Observer = (*Blamer)(nil)
+
+// New creates a Blamer for the output file at the absolute path dst.
+func New(dst string) *Blamer {
+	return &Blamer{dst: filepath.Clean(dst)}
+}
+
+// Applied records the contribution of a layer if it applied the blamed file.
+func (b *Blamer) Applied(file *render.AppliedFile) {
+	if filepath.Clean(file.Dst) == b.dst {
+		b.contributions = append(b.contributions, &contribution{file: file})
+	}
+}
+
+// Deleted drops the previous contributions if the blamed file was deleted.
+func (b *Blamer) Deleted(dst string, recursive bool) {
+	dst = filepath.Clean(dst)
+	if dst == b.dst || (recursive && strings.HasPrefix(b.dst, dst+string(filepath.Separator))) {
+		b.contributions = append(b.contributions, &contribution{reset: true})
+	}
+}
+
+// Blame attributes the keys (of structured files) or lines (of other files) of the rendered file to the template
+// layers which set them. Keys are attributed to the last layer declaring them. Lines are attributed to the layer
+// which introduced them, i.e. lines kept by a later layer which replaces the whole file stay attributed to the
+// earlier layer.
+func (b *Blamer) Blame(path string) (*Result, error) {
+	result := &Result{Path: path, Lines: []*Line{}}
+	var contributions []*contribution
+	for _, c := range b.contributions {
+		if c.reset {
+			contributions, result.Layers, result.Artifact = nil, nil, false
+			continue
+		}
+		contributions = append(contributions, c)
+		result.Layers = append(result.Layers, c.file.Layer)
+		if c.file.Layer.Strategy == lockfile.StrategyArtifact {
+			result.Artifact = true
+		}
+	}
+	if len(contributions) == 0 {
+		return nil, fmt.Errorf("%s is not rendered by any template layer", path)
+	}
+	if result.Artifact {
+		return result, nil
+	}
+
+	info, err := os.Lstat(b.dst)
+	if err != nil {
+		return nil, fmt.Errorf("reading rendered file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	final, err := os.ReadFile(b.dst)
+	if err != nil {
+		return nil, fmt.Errorf("reading rendered file: %w", err)
+	}
+
+	if format, ok := merge.FormatFor(path); ok {
+		result.Structured = true
+		result.Lines, err = blameKeys(format, contributions, final)
+	} else {
+		result.Lines, err = blameLines(contributions, final)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// blameKeys attributes every key of the final content to the last layer declaring it.
+// Layers which don't patch the file replace all keys of the previous layers.
+func blameKeys(format merge.Format, contributions []*contribution, final []byte) ([]*Line, error) {
+	owners := make(map[string]*contribution)
+	for _, c := range contributions {
+		content, err := c.content()
+		if err != nil {
+			return nil, err
+		}
+		data, err := format.Decode(content)
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s of %s: %w", format.Name(), c.file.Layer.Template, err)
+		}
+		if !strings.HasSuffix(c.file.Layer.Strategy, "-patch") {
+			clear(owners)
+		}
+		for key := range flatten("", data) {
+			owners[key] = c
+		}
+	}
+
+	data, err := format.Decode(final)
+	if err != nil {
+		return nil, fmt.Errorf("decoding rendered %s: %w", format.Name(), err)
+	}
+	values := flatten("", data)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	lines := make([]*Line, 0, len(keys))
+	for _, key := range keys {
+		line := &Line{Key: key, Value: formatValue(values[key])}
+		if owner := owners[key]; owner != nil {
+			line.Layer = owner.file.Layer
+			line.ValueKeys = owner.valueKeys(line.Value)
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// blameLines attributes every line of the final content to the layer which introduced it.
+func blameLines(contributions []*contribution, final []byte) ([]*Line, error) {
+	var (
+		previous []string
+		owners   []*contribution
+	)
+	introduce := func(lines []string, c *contribution) {
+		next := make([]*contribution, len(lines))
+		for j := range next {
+			next[j] = c
+		}
+		for _, match := range commonLines(previous, lines) {
+			next[match[1]] = owners[match[0]]
+		}
+		previous, owners = lines, next
+	}
+	for _, c := range contributions {
+		content, err := c.content()
+		if err != nil {
+			return nil, err
+		}
+		introduce(splitLines(content), c)
+	}
+	// the final content only differs if something else changed the file, its lines are unknown
+	introduce(splitLines(final), nil)
+
+	lines := make([]*Line, len(previous))
+	for i, text := range previous {
+		lines[i] = &Line{Number: i + 1, Value: text}
+		if owner := owners[i]; owner != nil {
+			lines[i].Layer = owner.file.Layer
+			lines[i].ValueKeys = owner.valueKeys(text)
+		}
+	}
+	return lines, nil
+}
+
+// content returns the content the layer applied.
+func (c *contribution) content() ([]byte, error) {
+	if c.file.Layer.Templated {
+		return c.file.Rendered, nil
+	}
+	content, err := os.ReadFile(c.file.Src)
+	if err != nil {
+		return nil, fmt.Errorf("reading template file: %w", err)
+	}
+	return content, nil
+}
+
+// valueKeys returns the keys of the template data which are referenced by the template
+// and whose value is part of the rendered value, sorted.
+func (c *contribution) valueKeys(value string) []string {
+	if !c.file.Layer.Templated {
+		return nil
+	}
+	data, ok := c.file.Data.(render.Values)
+	if !ok {
+		return nil
+	}
+	var keys []string
+	for _, key := range referencedKeys(c.file.Template) {
+		v, found := render.LookupNestedValue(data, key)
+		if !found {
+			continue
+		}
+		switch v.(type) {
+		case map[string]any, []any:
+			continue // only scalar values are rendered as-is
+		}
+		if s := fmt.Sprint(v); s != "" && strings.Contains(value, s) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// referencedKeys returns the keys of the template data which the template refers to, e.g. values.server.motd, sorted.
+func referencedKeys(text string) []string {
+	tmpl, err := template.New("blame").Parse(text)
+	if err != nil || tmpl.Tree == nil {
+		return nil
+	}
+	keys := make(map[string]struct{})
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(&n.BranchNode)
+		case *parse.RangeNode:
+			walk(&n.BranchNode)
+		case *parse.WithNode:
+			walk(&n.BranchNode)
+		case *parse.BranchNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.FieldNode:
+			if len(n.Ident) > 1 && slices.Contains(valueScopes, n.Ident[0]) {
+				keys[strings.Join(n.Ident, ".")] = struct{}{}
+			}
+		}
+	}
+	walk(tmpl.Tree.Root)
+
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	slices.Sort(sorted)
+	return sorted
+}
+
+// flatten returns all leaves of the nested data by their keys in dot-notation. Lists are leaves.
+func flatten(prefix string, data map[string]any) map[string]any {
+	leaves := make(map[string]any)
+	for key, value := range data {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]any); ok && len(nested) > 0 {
+			for k, v := range flatten(key, nested) {
+				leaves[k] = v
+			}
+			continue
+		}
+		leaves[key] = value
+	}
+	return leaves
+}
+
+// formatValue returns strings as-is and other values in JSON notation.
+func formatValue(value any) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
+
+func splitLines(content []byte) []string {
+	text := strings.TrimSuffix(string(content), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// commonLines returns the indices of the lines of a and b which are part of their longest common subsequence.
+func commonLines(a, b []string) [][2]int {
+	// lengths[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	var matches [][2]int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			matches = append(matches, [2]int{i, j})
+			i++
+			j++
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return matches
+}
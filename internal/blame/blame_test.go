@@ -0,0 +1,109 @@
+package blame
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sap-gg/gok/internal/lockfile"
+	"github.com/sap-gg/gok/internal/render"
+	"github.com/sap-gg/gok/internal/strategy"
+	"github.com/sap-gg/gok/internal/templ"
+)
+
+func TestBlamer(t *testing.T) {
+	tempDir := t.TempDir()
+	write := func(rel, content string) {
+		p := filepath.Join(tempDir, rel)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	write("gok-manifest.yaml", `
+version: 1
+values:
+  server:
+    motd: Welcome
+targets:
+  survival:
+    output: "survival"
+    templates:
+      - from: ./base
+      - from: ./survival
+`)
+	write("base/config.yml", "settings:\n  a: 1\n  b: [1, 2]\nmotd: base\n")
+	write("base/start.sh", "#!/bin/sh\necho start\njava -jar server.jar\n")
+	write("survival/gok-template.yaml", `
+version: 1
+imports:
+  values:
+    "server.motd":
+      description: "The message of the day."
+      required: true
+`)
+	write("survival/config.templ.yml", "settings:\n  a: 2\nmotd: \"{{ .values.server.motd }} to survival\"\n")
+	write("survival/start.templ.sh", "#!/bin/sh\necho {{ .values.server.motd }}\njava -jar server.jar\n")
+
+	base := &lockfile.SourceLayer{Template: "./base", Strategy: "copy-only"}
+
+	blameFile := func(t *testing.T, rel string) *Result {
+		ctx := context.Background()
+		manifest, manifestDir, err := render.ReadManifest(ctx, filepath.Join(tempDir, "gok-manifest.yaml"))
+		require.NoError(t, err)
+
+		workDir := t.TempDir()
+		registry, err := strategy.NewRegistry(&strategy.CopyOnlyStrategy{Overwrite: true},
+			map[string]strategy.FileStrategy{".yml": &strategy.YAMLPatchStrategy{}})
+		require.NoError(t, err)
+		engine, err := render.NewEngine(manifestDir, workDir, templ.NewTemplateRenderer(), registry,
+			manifest.Values, nil, render.NewValuesOverwritesSpec(), render.NewValuesOverwritesSpec(), nil)
+		require.NoError(t, err)
+
+		blamer := New(filepath.Join(workDir, "survival", rel))
+		engine.Observe(blamer)
+		require.NoError(t, engine.RenderTarget(ctx, manifest.Targets["survival"]))
+
+		result, err := blamer.Blame(rel)
+		require.NoError(t, err)
+		return result
+	}
+
+	t.Run("keys", func(t *testing.T) {
+		patch := &lockfile.SourceLayer{Template: "./survival", Strategy: "yaml-patch", Templated: true}
+		result := blameFile(t, "config.yml")
+
+		assert.True(t, result.Structured)
+		assert.Equal(t, []*lockfile.SourceLayer{base, patch}, result.Layers)
+		assert.Equal(t, []*Line{
+			{Key: "motd", Value: "Welcome to survival", Layer: patch, ValueKeys: []string{"values.server.motd"}},
+			{Key: "settings.a", Value: "2", Layer: patch},
+			{Key: "settings.b", Value: "[1,2]", Layer: base},
+		}, result.Lines)
+	})
+
+	t.Run("lines", func(t *testing.T) {
+		replace := &lockfile.SourceLayer{Template: "./survival", Strategy: "copy-only", Templated: true}
+		result := blameFile(t, "start.sh")
+
+		assert.False(t, result.Structured)
+		assert.Equal(t, []*Line{
+			{Number: 1, Value: "#!/bin/sh", Layer: base},
+			{Number: 2, Value: "echo Welcome", Layer: replace, ValueKeys: []string{"values.server.motd"}},
+			{Number: 3, Value: "java -jar server.jar", Layer: base},
+		}, result.Lines)
+	})
+
+	t.Run("not rendered", func(t *testing.T) {
+		blamer := New(filepath.Join(tempDir, "missing.txt"))
+		_, err := blamer.Blame("missing.txt")
+		assert.Error(t, err)
+	})
+}
+
+func TestReferencedKeys(t *testing.T) {
+	keys := referencedKeys(`{{ .values.a }} {{ if .secrets.b.c }}{{ range .targets.lobby.ports }}{{ . }}{{ end }}{{ end }} {{ .other.d }}`)
+	assert.Equal(t, []string{"secrets.b.c", "targets.lobby.ports", "values.a"}, keys)
+}
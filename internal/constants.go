@@ -14,9 +14,20 @@ const (
 	LockFileVersion = 1
 
 	OverwritesFileVersion = 1
+
+	DeployMapVersion = 1
+
+	PlanVersion = 1
 )
 
 const (
 	TemplateInfix  = ".templ"
+	SeedInfix      = ".seed"
 	ArtifactSuffix = ".artifact.yaml"
 )
+
+const (
+	// StateDirName is the directory inside a destination where gok keeps its bookkeeping data
+	// (e.g. the last-applied content of files).
+	StateDirName = ".gok"
+)
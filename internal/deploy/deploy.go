@@ -0,0 +1,93 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sap-gg/gok/internal"
+	"github.com/sap-gg/gok/internal/lockfile"
+)
+
+// Map assigns the targets of a multi-target artifact to destination directories.
+type Map struct {
+	Version int `yaml:"version" validate:"required"`
+
+	// Destinations maps target IDs to their destination
+	Destinations map[string]*Destination `yaml:"destinations" validate:"required,min=1,dive,required"`
+}
+
+// Destination is the destination of a single target.
+type Destination struct {
+	// Path is the destination directory. Relative paths are relative to the deployment map.
+	Path string `yaml:"path" validate:"required"`
+
+	// Policy is the conflict policy for files of the target which don't declare a policy themselves (optional)
+	Policy lockfile.ConflictPolicy `yaml:"policy" validate:"omitempty,oneof=fail keep-local take-desired merge backup-and-replace"`
+
+	// Hooks are run in addition to (after) the hooks the target declares in the manifest (optional)
+	Hooks *lockfile.Hooks `yaml:"hooks"`
+}
+
+// ReadMap reads the deployment map at path.
+func ReadMap(ctx context.Context, path string) (*Map, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening deployment map %q: %w", path, err)
+	}
+	defer f.Close()
+
+	var m Map
+	if err := internal.NewYAMLDecoder(f).DecodeContext(ctx, &m); err != nil {
+		if internal.IsDecodeErrorAndPrint(err) {
+			return nil, fmt.Errorf("parsing deployment map")
+		}
+		return nil, fmt.Errorf("decoding deployment map %q: %w", path, err)
+	}
+
+	if m.Version != internal.DeployMapVersion {
+		return nil, fmt.Errorf("unsupported deployment map version %d (expected %d)",
+			m.Version, internal.DeployMapVersion)
+	}
+
+	baseDir := filepath.Dir(path)
+	seen := make(map[string]string, len(m.Destinations))
+	for _, id := range m.TargetIDs() {
+		dest := m.Destinations[id]
+		if !filepath.IsAbs(dest.Path) {
+			dest.Path = filepath.Join(baseDir, dest.Path)
+		}
+		dest.Path = filepath.Clean(dest.Path)
+		if err := dest.Hooks.Validate(); err != nil {
+			return nil, fmt.Errorf("target %q: %w", id, err)
+		}
+		if other, ok := seen[dest.Path]; ok {
+			return nil, fmt.Errorf("targets %q and %q share the destination %q", other, id, dest.Path)
+		}
+		seen[dest.Path] = id
+	}
+	// nested destinations would manage each other's files (and lock files)
+	for _, id := range m.TargetIDs() {
+		for otherPath, otherID := range seen {
+			if otherID != id && strings.HasPrefix(m.Destinations[id].Path, otherPath+string(filepath.Separator)) {
+				return nil, fmt.Errorf("the destination of target %q is nested inside the destination of target %q",
+					id, otherID)
+			}
+		}
+	}
+
+	return &m, nil
+}
+
+// TargetIDs returns the IDs of all targets in the map, sorted.
+func (m *Map) TargetIDs() []string {
+	ids := make([]string, 0, len(m.Destinations))
+	for id := range m.Destinations {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
@@ -0,0 +1,152 @@
+package deploy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sap-gg/gok/internal/lockfile"
+)
+
+func writeMap(t *testing.T, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "deploy.yaml")
+	require.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+	return p
+}
+
+func TestReadMap(t *testing.T) {
+	p := writeMap(t, `
+version: 1
+destinations:
+  survival:
+    path: /opt/minecraft/survival
+    policy: keep-local
+    hooks:
+      postApply:
+        - name: reload
+          command: [systemctl, reload, survival]
+          timeout: 30s
+          onFailure: rollback
+        - rcon:
+            commands: [reload confirm]
+          when: ["plugins/**"]
+  proxy:
+    path: ./proxy
+`)
+	m, err := ReadMap(context.Background(), p)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"proxy", "survival"}, m.TargetIDs())
+	assert.Equal(t, filepath.Clean("/opt/minecraft/survival"), m.Destinations["survival"].Path)
+	assert.Equal(t, lockfile.PolicyKeepLocal, m.Destinations["survival"].Policy)
+	assert.Equal(t, &lockfile.Hooks{PostApply: []*lockfile.Hook{{
+		Name:      "reload",
+		Command:   []string{"systemctl", "reload", "survival"},
+		Timeout:   30 * time.Second,
+		OnFailure: lockfile.HookRollback,
+	}, {
+		RCON: &lockfile.RCONAction{Commands: []string{"reload confirm"}},
+		When: []string{"plugins/**"},
+	}}}, m.Destinations["survival"].Hooks)
+	assert.Equal(t, filepath.Join(filepath.Dir(p), "proxy"), m.Destinations["proxy"].Path,
+		"relative paths must be relative to the map")
+}
+
+func TestReadMapInvalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown version": `
+version: 2
+destinations:
+  survival:
+    path: /srv/survival`,
+		"no destinations": `
+version: 1
+destinations: {}`,
+		"invalid policy": `
+version: 1
+destinations:
+  survival:
+    path: /srv/survival
+    policy: yolo`,
+		"hook without command": `
+version: 1
+destinations:
+  survival:
+    path: /srv/survival
+    hooks:
+      postApply:
+        - name: reload`,
+		"invalid hook failure policy": `
+version: 1
+destinations:
+  survival:
+    path: /srv/survival
+    hooks:
+      preApply:
+        - command: ["true"]
+          onFailure: retry`,
+		"negative hook timeout": `
+version: 1
+destinations:
+  survival:
+    path: /srv/survival
+    hooks:
+      preApply:
+        - command: ["true"]
+          timeout: -1s`,
+		"hook with command and rcon": `
+version: 1
+destinations:
+  survival:
+    path: /srv/survival
+    hooks:
+      postApply:
+        - command: ["true"]
+          rcon:
+            commands: [reload confirm]`,
+		"rcon hook without commands": `
+version: 1
+destinations:
+  survival:
+    path: /srv/survival
+    hooks:
+      postApply:
+        - rcon:
+            port: 25575`,
+		"invalid hook glob": `
+version: 1
+destinations:
+  survival:
+    path: /srv/survival
+    hooks:
+      postApply:
+        - command: ["true"]
+          when: ["plugins/[*.yml"]`,
+		"shared destination": `
+version: 1
+destinations:
+  survival:
+    path: /srv/survival
+  lobby:
+    path: /srv/survival/`,
+		"nested destination": `
+version: 1
+destinations:
+  survival:
+    path: /srv/survival
+  lobby:
+    path: /srv/survival/lobby`,
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ReadMap(context.Background(), writeMap(t, content))
+			assert.Error(t, err)
+		})
+	}
+}
@@ -4,10 +4,20 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"maps"
+	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strings"
 
+	"github.com/rs/zerolog/log"
+
+	"github.com/sap-gg/gok/internal/glob"
 	"github.com/sap-gg/gok/internal/lockfile"
+	"github.com/sap-gg/gok/internal/merge"
+	"github.com/sap-gg/gok/internal/state"
 )
 
 // Type represents the kind of Change detected for a file.
@@ -19,19 +29,111 @@ const (
 	Modified
 	Removed
 	Conflict
+	// Renamed is a removed and a created path with identical content, see Change.OldPath.
+	Renamed
 )
 
+// String returns a human-friendly name of the change type.
+func (t Type) String() string {
+	switch t {
+	case Created:
+		return "created"
+	case Modified:
+		return "modified"
+	case Removed:
+		return "removed"
+	case Conflict:
+		return "conflict"
+	case Renamed:
+		return "renamed"
+	default:
+		return "unchanged"
+	}
+}
+
+// Resolution describes how a Conflict is going to be resolved.
+type Resolution int
+
+const (
+	// Unresolved conflicts require manual intervention (or --force).
+	Unresolved Resolution = iota
+	// Merged conflicts were resolved by a three-way merge, see Change.Merged.
+	Merged
+	// KeepLocal conflicts keep the manually changed file.
+	KeepLocal
+	// TakeDesired conflicts are overwritten (or removed) according to the desired state.
+	TakeDesired
+	// BackupAndReplace conflicts are saved before being overwritten (or removed) according to the desired state.
+	BackupAndReplace
+)
+
+// String returns a human-friendly name of the resolution.
+func (r Resolution) String() string {
+	switch r {
+	case Merged:
+		return "merged"
+	case KeepLocal:
+		return "keep-local"
+	case TakeDesired:
+		return "take-desired"
+	case BackupAndReplace:
+		return "backup-and-replace"
+	default:
+		return "unresolved"
+	}
+}
+
 // Change represents the state change for a single file.
 type Change struct {
-	Type    Type
-	Path    string
+	Type Type
+	Path string
+	// OldPath is the previous path of a renamed file, which is removed by the rename.
+	OldPath string
 	OldHash string
 	NewHash string
+	// OldMode and NewMode are the permission bits (in octal notation) recorded in the lock files, if any.
+	OldMode string
+	NewMode string
+	// OldOwner and NewOwner are the managed ownership (in "uid:gid" notation) recorded in the lock files, if any.
+	OldOwner string
+	NewOwner string
+
+	// Seed is true if the file is only created because it's absent and never managed afterward.
+	Seed bool
+
+	// OldSource and NewSource describe how the file was rendered according to the lock files, if recorded.
+	OldSource *lockfile.FileSource
+	NewSource *lockfile.FileSource
+
+	// Policy is the conflict policy declared for the file (if any).
+	Policy lockfile.ConflictPolicy
+	// Resolution is only set for conflicts.
+	Resolution Resolution
+	// Merged is the content of the three-way merge, only set if Resolution is Merged.
+	Merged []byte
+	// ConflictKeys are the keys (in dot-notation) which were changed both locally and in the desired state.
+	// Only set if a three-way merge was attempted but failed.
+	ConflictKeys []string
+}
+
+// UntrackedFile is a file in the current state which is not managed by gok.
+type UntrackedFile struct {
+	Path string
+	// Exclusive is true if the file is inside a directory exclusively managed by gok (i.e. it may be pruned).
+	Exclusive bool
 }
 
 // Report contains the results of a diff operation.
 type Report struct {
-	Changes      map[string]*Change
+	Changes map[string]*Change
+	// Untracked files under managed directories, only set if enabled using WithUntracked.
+	Untracked []*UntrackedFile
+	// Reformatted contains the sorted paths of structured files reported as Unchanged because only their
+	// formatting differs, either in the desired or in the current state. Only set if enabled using WithSemanticEquality.
+	Reformatted []string
+	// Merged contains the sorted paths of files reported as Unchanged because their current content is the
+	// result of a three-way merge with the desired content, i.e. it differs from the desired content.
+	Merged       []string
 	hasChanges   bool
 	hasConflicts bool
 }
@@ -41,11 +143,22 @@ func (r *Report) HasChanges() bool {
 	return r.hasChanges
 }
 
-// HasConflicts returns true if there are any conflicts detected.
+// HasConflicts returns true if there are any unresolved conflicts detected.
 func (r *Report) HasConflicts() bool {
 	return r.hasConflicts
 }
 
+// Prunable returns all untracked files inside exclusively managed directories.
+func (r *Report) Prunable() []*UntrackedFile {
+	var prunable []*UntrackedFile
+	for _, u := range r.Untracked {
+		if u.Exclusive {
+			prunable = append(prunable, u)
+		}
+	}
+	return prunable
+}
+
 // SortedPaths returns a sorted list of the file paths in the report.
 func (r *Report) SortedPaths() []string {
 	paths := make([]string, 0, len(r.Changes))
@@ -60,14 +173,81 @@ func (r *Report) SortedPaths() []string {
 type Comparer struct {
 	currentDir string // actual directory on disk
 	desiredDir string // temporary directory with newly rendered files
+
+	threeWayMerge bool
+	semantic      bool
+
+	untracked       bool
+	untrackedIgnore []string
+
+	// desiredLock overrides the lock file of desiredDir (if set)
+	desiredLock *lockfile.LockFile
+	// defaultPolicy is used for conflicts of files without a conflict policy
+	defaultPolicy lockfile.ConflictPolicy
+	// filter selects the compared paths, all other paths are left out of the report
+	filter *glob.Filter
+}
+
+// Option configures optional behavior of a Comparer.
+type Option func(c *Comparer)
+
+// WithThreeWayMerge enables key-level three-way merges for conflicting structured files
+// (YAML, JSON, TOML and .properties) using the last-applied content kept in the destination.
+func WithThreeWayMerge() Option {
+	return func(c *Comparer) {
+		c.threeWayMerge = true
+	}
+}
+
+// WithSemanticEquality treats structured files (YAML, JSON, TOML and .properties) as unchanged if their data is equal,
+// i.e. if they only differ in formatting, key order or comments. See Report.Reformatted.
+func WithSemanticEquality() Option {
+	return func(c *Comparer) {
+		c.semantic = true
+	}
+}
+
+// WithUntracked enables reporting of untracked files in directories containing managed files
+// or in exclusively managed directories. Paths matching any of the ignore globs are skipped.
+func WithUntracked(ignore ...string) Option {
+	return func(c *Comparer) {
+		c.untracked = true
+		c.untrackedIgnore = ignore
+	}
+}
+
+// WithDesiredLock uses the given lock file as the desired state instead of reading it from the desired directory,
+// e.g. the part of the lock file belonging to a single target.
+func WithDesiredLock(lock *lockfile.LockFile) Option {
+	return func(c *Comparer) {
+		c.desiredLock = lock
+	}
+}
+
+// WithDefaultPolicy sets the conflict policy used for files which don't declare a policy themselves.
+func WithDefaultPolicy(policy lockfile.ConflictPolicy) Option {
+	return func(c *Comparer) {
+		c.defaultPolicy = policy
+	}
+}
+
+// WithPathFilter limits the comparison to paths selected by the filter.
+func WithPathFilter(filter *glob.Filter) Option {
+	return func(c *Comparer) {
+		c.filter = filter
+	}
 }
 
 // NewComparer creates a new Comparer instance.
-func NewComparer(currentDir, desiredDir string) *Comparer {
-	return &Comparer{
+func NewComparer(currentDir, desiredDir string, opts ...Option) *Comparer {
+	c := &Comparer{
 		currentDir: currentDir,
 		desiredDir: desiredDir,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 // Compare performs the diff operation and returns a Report.
@@ -78,9 +258,11 @@ func (c *Comparer) Compare() (*Report, error) {
 		return nil, err
 	}
 
-	newLock, err := lockfile.Read(c.desiredDir)
-	if err != nil {
-		return nil, fmt.Errorf("reading desired state lock file: %w", err)
+	newLock := c.desiredLock
+	if newLock == nil {
+		if newLock, err = lockfile.Read(c.desiredDir); err != nil {
+			return nil, fmt.Errorf("reading desired state lock file: %w", err)
+		}
 	}
 
 	report := &Report{
@@ -89,49 +271,335 @@ func (c *Comparer) Compare() (*Report, error) {
 
 	allPaths := getUnionKeys(oldLock.Files, newLock.Files)
 	for _, path := range allPaths {
+		if !c.filter.Match(path) {
+			continue
+		}
+		if _, ok := newLock.Seeds[path]; ok {
+			// the file is no longer managed, it belongs to the destination now
+			continue
+		}
+
 		oldEntry := oldLock.Files[path]
 		newEntry := newLock.Files[path]
 
 		currentPathOnDisk := filepath.Join(c.currentDir, path)
-		actualHash, err := lockfile.FileSHA256(currentPathOnDisk)
+		actualHash, err := lockfile.PathHash(currentPathOnDisk)
 		if err != nil && !errors.Is(err, fs.ErrNotExist) {
 			return nil, fmt.Errorf("computing hash for %q: %w", currentPathOnDisk, err)
 		}
 
 		if oldEntry != nil && newEntry != nil {
-			if oldEntry.Hash != actualHash {
-				report.add(Conflict, path, oldEntry.Hash, newEntry.Hash)
-			} else if oldEntry.Hash != newEntry.Hash {
-				report.add(Modified, path, oldEntry.Hash, newEntry.Hash)
+			locallyChanged := oldEntry.Hash != actualHash
+			desiredChanged := oldEntry.DesiredHash() != newEntry.Hash
+			if oldEntry.BaseHash != "" && desiredChanged {
+				// the merged content still contains the local changes, it has to be merged again
+				locallyChanged = true
+			}
+			reformatted := false
+			if c.semantic && locallyChanged && actualHash != "" {
+				actualDataHash, err := lockfile.DataHash(currentPathOnDisk)
+				if err != nil {
+					return nil, fmt.Errorf("computing data hash for %q: %w", currentPathOnDisk, err)
+				}
+				if sameData(oldEntry.DataHash, actualDataHash) {
+					locallyChanged, reformatted = false, true
+				}
+			}
+			if c.semantic && desiredChanged && oldEntry.BaseHash == "" && sameData(oldEntry.DataHash, newEntry.DataHash) {
+				desiredChanged, reformatted = false, true
+			}
+
+			if locallyChanged {
+				change := &Change{Type: Conflict, Path: path, OldHash: oldEntry.Hash, NewHash: newEntry.Hash}
+				c.resolve(change, newEntry.Policy, oldEntry.DesiredHash(), actualHash != "" && newEntry.Type == lockfile.TypeFile)
+				if change.Resolution == Merged && lockfile.SHA256(change.Merged) == actualHash &&
+					!modeChanged(oldEntry, newEntry) && !ownershipChanged(oldEntry, newEntry) {
+					// the merge keeps the current content, e.g. the desired content didn't change
+					report.Merged = append(report.Merged, path)
+					report.add(Unchanged, path, oldEntry.Hash, newEntry.Hash)
+					continue
+				}
+				report.addChange(change)
+			} else if desiredChanged || modeChanged(oldEntry, newEntry) || ownershipChanged(oldEntry, newEntry) {
+				change := &Change{
+					Type:    Modified,
+					Path:    path,
+					OldHash: oldEntry.Hash,
+					NewHash: newEntry.Hash,
+					OldMode: oldEntry.Mode,
+					NewMode: newEntry.Mode,
+				}
+				if ownershipChanged(oldEntry, newEntry) {
+					change.OldOwner, change.NewOwner = oldEntry.FormatOwnership(), newEntry.FormatOwnership()
+				}
+				report.addChange(change)
 			} else {
+				if oldEntry.BaseHash != "" {
+					report.Merged = append(report.Merged, path)
+				} else if reformatted {
+					report.Reformatted = append(report.Reformatted, path)
+				}
 				report.add(Unchanged, path, oldEntry.Hash, newEntry.Hash)
 			}
 		} else if oldEntry == nil && newEntry != nil {
 			report.add(Created, path, "", newEntry.Hash)
 		} else if oldEntry != nil {
 			if actualHash != "" && oldEntry.Hash != actualHash {
-				report.add(Conflict, path, oldEntry.Hash, "")
+				change := &Change{Type: Conflict, Path: path, OldHash: oldEntry.Hash}
+				c.resolve(change, oldEntry.Policy, "", false)
+				report.addChange(change)
 			} else {
 				report.add(Removed, path, oldEntry.Hash, "")
 			}
 		}
 	}
 
+	slices.Sort(report.Reformatted)
+	slices.Sort(report.Merged)
+
+	if err := c.pairRenames(report, oldLock, newLock); err != nil {
+		return nil, err
+	}
+
+	// seeds are only created if they don't exist yet
+	for path, entry := range newLock.Seeds {
+		if !c.filter.Match(path) {
+			continue
+		}
+		currentPathOnDisk := filepath.Join(c.currentDir, path)
+		if _, err := os.Lstat(currentPathOnDisk); err == nil {
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("checking seed %q: %w", currentPathOnDisk, err)
+		}
+		report.addChange(&Change{Type: Created, Path: path, NewHash: entry.Hash, Seed: true})
+	}
+
+	for _, change := range report.Changes {
+		oldPath := change.Path
+		if change.OldPath != "" {
+			oldPath = change.OldPath
+		}
+		if entry := oldLock.Files[oldPath]; entry != nil {
+			change.OldSource = entry.Source
+		}
+		if entry := newLock.Files[change.Path]; entry != nil {
+			change.NewSource = entry.Source
+		} else if entry := newLock.Seeds[change.Path]; entry != nil {
+			change.NewSource = entry.Source
+		}
+	}
+
+	if c.untracked {
+		if report.Untracked, err = c.findUntracked(oldLock, newLock); err != nil {
+			return nil, fmt.Errorf("finding untracked files: %w", err)
+		}
+		report.Untracked = slices.DeleteFunc(report.Untracked, func(u *UntrackedFile) bool {
+			return !c.filter.Match(u.Path)
+		})
+	}
+
 	return report, nil
 }
 
-func (r *Report) add(t Type, path, oldHash, newHash string) {
-	if t == Unchanged {
-		return
+// pairRenames replaces removed and created paths with identical content and metadata by renames.
+// If several removed paths have the same content, they are paired in sorted order.
+func (c *Comparer) pairRenames(report *Report, oldLock, newLock *lockfile.LockFile) error {
+	removed := make(map[string][]string)
+	for _, path := range report.SortedPaths() {
+		change := report.Changes[path]
+		if change.Type != Removed || oldLock.Files[path].Type == lockfile.TypeDir {
+			continue
+		}
+		// a file removed in the destination can't be renamed
+		if _, err := os.Lstat(filepath.Join(c.currentDir, path)); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return fmt.Errorf("checking %q: %w", path, err)
+		}
+		removed[change.OldHash] = append(removed[change.OldHash], path)
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+
+	for _, path := range report.SortedPaths() {
+		change := report.Changes[path]
+		// removed paths are deleted from the report once they are paired
+		if change == nil || change.Type != Created || change.Seed {
+			continue
+		}
+		newEntry := newLock.Files[path]
+		candidates := removed[change.NewHash]
+		i := slices.IndexFunc(candidates, func(from string) bool {
+			oldEntry := oldLock.Files[from]
+			return oldEntry.Type == newEntry.Type && !modeChanged(oldEntry, newEntry) && !ownershipChanged(oldEntry, newEntry)
+		})
+		if i < 0 {
+			continue
+		}
+		from := candidates[i]
+		removed[change.NewHash] = slices.Delete(candidates, i, i+1)
+
+		delete(report.Changes, from)
+		change.Type = Renamed
+		change.OldPath = from
+		change.OldHash = change.NewHash
+	}
+	return nil
+}
+
+// findUntracked returns all untracked files of the current state which are either located directly
+// in a directory containing managed files or anywhere inside an exclusively managed directory.
+func (c *Comparer) findUntracked(oldLock, newLock *lockfile.LockFile) ([]*UntrackedFile, error) {
+	known := &lockfile.LockFile{
+		Files: make(lockfile.LockFiles),
+		Seeds: make(lockfile.LockFiles),
 	}
-	r.Changes[path] = &Change{
+	for _, lock := range []*lockfile.LockFile{oldLock, newLock} {
+		maps.Copy(known.Files, lock.Files)
+		maps.Copy(known.Seeds, lock.Seeds)
+	}
+
+	paths, err := FindUntracked(c.currentDir, known, c.untrackedIgnore)
+	if err != nil {
+		return nil, err
+	}
+	return scopeUntracked(paths, newLock.ExclusiveDirs, oldLock, newLock), nil
+}
+
+// scopeUntracked keeps the untracked paths which are located directly in a directory containing managed files
+// of any of the locks or anywhere inside one of the exclusively managed directories.
+// Other untracked files (e.g. worlds or logs) are not gok's business.
+func scopeUntracked(paths, exclusiveDirs []string, locks ...*lockfile.LockFile) []*UntrackedFile {
+	managedDirs := make(map[string]struct{})
+	for _, lock := range locks {
+		for p := range lock.Files {
+			managedDirs[path.Dir(p)] = struct{}{}
+		}
+	}
+
+	var untracked []*UntrackedFile
+	for _, p := range paths {
+		exclusive := slices.ContainsFunc(exclusiveDirs, func(dir string) bool {
+			return strings.HasPrefix(p, dir+"/")
+		})
+		if _, managed := managedDirs[path.Dir(p)]; !managed && !exclusive {
+			continue
+		}
+		untracked = append(untracked, &UntrackedFile{Path: p, Exclusive: exclusive})
+	}
+	return untracked
+}
+
+// sameData returns true if both data hashes are known and equal.
+func sameData(a, b string) bool {
+	return a != "" && a == b
+}
+
+// modeChanged returns true if both entries record permission bits and they differ.
+func modeChanged(oldEntry, newEntry *lockfile.LockEntry) bool {
+	return oldEntry.Mode != "" && newEntry.Mode != "" && oldEntry.Mode != newEntry.Mode
+}
+
+// ownershipChanged returns true if the new entry manages the ownership and it differs from the old entry.
+func ownershipChanged(oldEntry, newEntry *lockfile.LockEntry) bool {
+	return newEntry.HasOwnership() && oldEntry.FormatOwnership() != newEntry.FormatOwnership()
+}
+
+// IsMetadataOnly returns true if only the permission bits or the ownership of the file changed.
+func (c *Change) IsMetadataOnly() bool {
+	return c.Type == Modified && c.OldHash == c.NewHash
+}
+
+// resolve determines how a conflict is resolved based on the conflict policy of the file.
+// baseHash is the hash of the desired content which was last applied, the content recorded for the merge has to match it.
+// mergeable indicates whether a three-way merge is possible at all (i.e. the file exists on both sides).
+func (c *Comparer) resolve(change *Change, policy lockfile.ConflictPolicy, baseHash string, mergeable bool) {
+	if policy == "" {
+		policy = c.defaultPolicy
+	}
+	change.Policy = policy
+
+	switch policy {
+	case lockfile.PolicyKeepLocal:
+		change.Resolution = KeepLocal
+	case lockfile.PolicyTakeDesired:
+		change.Resolution = TakeDesired
+	case lockfile.PolicyBackupAndReplace:
+		change.Resolution = BackupAndReplace
+	case lockfile.PolicyMerge, "":
+		// files without a policy are only merged if enabled
+		if !mergeable || (policy == "" && !c.threeWayMerge) {
+			return
+		}
+		if err := c.tryMerge(change, baseHash); err != nil {
+			log.Warn().Err(err).Msgf("three-way merge of %q not possible", change.Path)
+		}
+	default:
+		// PolicyFail: leave unresolved
+	}
+}
+
+// tryMerge attempts a three-way merge between the last-applied, the current and the desired content of a file.
+// If the merge succeeds without key conflicts, the change is marked as Merged.
+func (c *Comparer) tryMerge(change *Change, baseHash string) error {
+	format, ok := merge.FormatFor(change.Path)
+	if !ok {
+		return nil // not a structured file
+	}
+
+	store := state.New(c.currentDir)
+	baseContent, err := store.ReadBase(change.Path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("no last-applied content recorded")
+		}
+		return fmt.Errorf("reading last-applied content: %w", err)
+	}
+	if lockfile.SHA256(baseContent) != baseHash {
+		return fmt.Errorf("last-applied content does not match the lock file")
+	}
+
+	localContent, err := os.ReadFile(filepath.Join(c.currentDir, change.Path))
+	if err != nil {
+		return fmt.Errorf("reading current content: %w", err)
+	}
+	desiredContent, err := os.ReadFile(filepath.Join(c.desiredDir, change.Path))
+	if err != nil {
+		return fmt.Errorf("reading desired content: %w", err)
+	}
+
+	mergedContent, conflictKeys, err := merge.MergeContent(format, baseContent, localContent, desiredContent)
+	if err != nil {
+		return err
+	}
+	if len(conflictKeys) > 0 {
+		change.ConflictKeys = conflictKeys
+		return nil
+	}
+	change.Resolution = Merged
+	change.Merged = mergedContent
+	return nil
+}
+
+func (r *Report) add(t Type, path, oldHash, newHash string) {
+	r.addChange(&Change{
 		Type:    t,
 		Path:    path,
 		OldHash: oldHash,
 		NewHash: newHash,
+	})
+}
+
+func (r *Report) addChange(change *Change) {
+	if change.Type == Unchanged {
+		return
 	}
+	r.Changes[change.Path] = change
 	r.hasChanges = true
-	if t == Conflict {
+	if change.Type == Conflict && change.Resolution == Unresolved {
 		r.hasConflicts = true
 	}
 }
@@ -10,7 +10,9 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/sap-gg/gok/internal"
+	"github.com/sap-gg/gok/internal/glob"
 	"github.com/sap-gg/gok/internal/lockfile"
+	"github.com/sap-gg/gok/internal/state"
 )
 
 func setupDiffDirs(t *testing.T, oldState, newState, actualState map[string]string) (currentDir, desiredDir string) {
@@ -45,6 +47,17 @@ func setupDiffDirs(t *testing.T, oldState, newState, actualState map[string]stri
 	return currentDir, desiredDir
 }
 
+// rewriteLock reads the lock file in dir, lets fn modify it and writes it back.
+func rewriteLock(t *testing.T, dir string, fn func(lock *lockfile.LockFile)) {
+	lock, err := lockfile.Read(dir)
+	require.NoError(t, err)
+	fn(lock)
+	f, err := os.Create(filepath.Join(dir, internal.LockFileName))
+	require.NoError(t, err)
+	require.NoError(t, internal.NewYAMLEncoder(f).Encode(lock))
+	require.NoError(t, f.Close())
+}
+
 func TestComparer_Compare(t *testing.T) {
 	testCases := []struct {
 		name         string
@@ -125,3 +138,501 @@ func TestComparer_Compare(t *testing.T) {
 		})
 	}
 }
+
+func TestComparer_CompareThreeWayMerge(t *testing.T) {
+	oldContent := "server:\n  port: 8080\nmotd: hello\n"
+
+	testCases := []struct {
+		name               string
+		actualContent      string
+		newContent         string
+		expectedResolution Resolution
+		expectedKeys       []string
+	}{
+		{
+			name:               "should merge non-overlapping changes",
+			actualContent:      "server:\n  port: 8080\nmotd: edited by hand\n",
+			newContent:         "server:\n  port: 9090\nmotd: hello\n",
+			expectedResolution: Merged,
+		},
+		{
+			name:               "should report overlapping keys as conflicts",
+			actualContent:      "server:\n  port: 25565\nmotd: hello\n",
+			newContent:         "server:\n  port: 9090\nmotd: hello\n",
+			expectedResolution: Unresolved,
+			expectedKeys:       []string{"server.port"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			currentDir, desiredDir := setupDiffDirs(t,
+				map[string]string{"config.yml": oldContent},
+				map[string]string{"config.yml": tc.newContent},
+				map[string]string{"config.yml": tc.actualContent})
+
+			basePath := state.New(currentDir).BasePath("config.yml")
+			require.NoError(t, os.MkdirAll(filepath.Dir(basePath), 0755))
+			require.NoError(t, os.WriteFile(basePath, []byte(oldContent), 0644))
+
+			report, err := NewComparer(currentDir, desiredDir, WithThreeWayMerge()).Compare()
+			require.NoError(t, err)
+
+			require.Contains(t, report.Changes, "config.yml")
+			change := report.Changes["config.yml"]
+			assert.Equal(t, Conflict, change.Type)
+			assert.Equal(t, tc.expectedResolution, change.Resolution)
+			assert.Equal(t, tc.expectedKeys, change.ConflictKeys)
+			assert.Equal(t, tc.expectedResolution == Unresolved, report.HasConflicts())
+
+			if tc.expectedResolution == Merged {
+				assert.Contains(t, string(change.Merged), "port: 9090")
+				assert.Contains(t, string(change.Merged), "motd: edited by hand")
+			}
+		})
+	}
+}
+
+func TestComparer_CompareRejectsNonLocalPaths(t *testing.T) {
+	for _, path := range []string{"../../etc/cron.d/x", "/etc/cron.d/x"} {
+		t.Run(path, func(t *testing.T) {
+			currentDir, desiredDir := setupDiffDirs(t, nil, map[string]string{"a.txt": "a"}, nil)
+			rewriteLock(t, desiredDir, func(lock *lockfile.LockFile) {
+				lock.Files[path] = lock.Files["a.txt"]
+			})
+
+			_, err := NewComparer(currentDir, desiredDir).Compare()
+			assert.ErrorContains(t, err, "not a local path")
+		})
+	}
+}
+
+func TestComparer_CompareMergedContent(t *testing.T) {
+	baseContent := "server:\n  port: 8080\nmotd: hello\n"
+	mergedContent := "server:\n  port: 8080\nmotd: edited by hand\n"
+
+	// setup prepares a destination whose merged content was kept by a previous apply
+	setup := func(t *testing.T, newContent string) (currentDir, desiredDir string) {
+		currentDir, desiredDir = setupDiffDirs(t,
+			map[string]string{"config.yml": mergedContent},
+			map[string]string{"config.yml": newContent},
+			nil)
+		rewriteLock(t, currentDir, func(lock *lockfile.LockFile) {
+			lock.Files["config.yml"].BaseHash = lockfile.SHA256([]byte(baseContent))
+		})
+		basePath := state.New(currentDir).BasePath("config.yml")
+		require.NoError(t, os.MkdirAll(filepath.Dir(basePath), 0755))
+		require.NoError(t, os.WriteFile(basePath, []byte(baseContent), 0644))
+		return currentDir, desiredDir
+	}
+
+	t.Run("should keep merged content without changes", func(t *testing.T) {
+		currentDir, desiredDir := setup(t, baseContent)
+
+		// without three-way merges as well
+		report, err := NewComparer(currentDir, desiredDir).Compare()
+		require.NoError(t, err)
+		assert.False(t, report.HasChanges())
+		assert.Equal(t, []string{"config.yml"}, report.Merged)
+	})
+
+	t.Run("should merge again if the desired content changed", func(t *testing.T) {
+		currentDir, desiredDir := setup(t, "server:\n  port: 9090\nmotd: hello\n")
+
+		report, err := NewComparer(currentDir, desiredDir, WithThreeWayMerge()).Compare()
+		require.NoError(t, err)
+		require.Contains(t, report.Changes, "config.yml")
+		change := report.Changes["config.yml"]
+		assert.Equal(t, Conflict, change.Type)
+		assert.Equal(t, Merged, change.Resolution)
+		assert.Contains(t, string(change.Merged), "port: 9090")
+		assert.Contains(t, string(change.Merged), "motd: edited by hand")
+	})
+
+	t.Run("should report a merge keeping the current content as unchanged", func(t *testing.T) {
+		currentDir, desiredDir := setupDiffDirs(t,
+			map[string]string{"config.yml": baseContent},
+			map[string]string{"config.yml": baseContent},
+			map[string]string{"config.yml": mergedContent})
+		basePath := state.New(currentDir).BasePath("config.yml")
+		require.NoError(t, os.MkdirAll(filepath.Dir(basePath), 0755))
+		require.NoError(t, os.WriteFile(basePath, []byte(baseContent), 0644))
+
+		report, err := NewComparer(currentDir, desiredDir, WithThreeWayMerge()).Compare()
+		require.NoError(t, err)
+		assert.False(t, report.HasChanges())
+		assert.Equal(t, []string{"config.yml"}, report.Merged)
+	})
+}
+
+func TestComparer_CompareConflictPolicies(t *testing.T) {
+	testCases := []struct {
+		name               string
+		policy             lockfile.ConflictPolicy
+		newState           map[string]string
+		expectedResolution Resolution
+	}{
+		{
+			name:               "fail should leave the conflict unresolved",
+			policy:             lockfile.PolicyFail,
+			newState:           map[string]string{"ops.json": `{"new": true}`},
+			expectedResolution: Unresolved,
+		},
+		{
+			name:               "keep-local should keep the manual change",
+			policy:             lockfile.PolicyKeepLocal,
+			newState:           map[string]string{"ops.json": `{"new": true}`},
+			expectedResolution: KeepLocal,
+		},
+		{
+			name:               "take-desired should overwrite the manual change",
+			policy:             lockfile.PolicyTakeDesired,
+			newState:           map[string]string{"ops.json": `{"new": true}`},
+			expectedResolution: TakeDesired,
+		},
+		{
+			name:               "backup-and-replace should apply to removed files",
+			policy:             lockfile.PolicyBackupAndReplace,
+			newState:           map[string]string{},
+			expectedResolution: BackupAndReplace,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			currentDir, desiredDir := setupDiffDirs(t,
+				map[string]string{"ops.json": `{"old": true}`},
+				tc.newState,
+				map[string]string{"ops.json": `{"edited": true}`})
+
+			// the policy is read from the desired lock, or from the current lock for removed files
+			lockDir := desiredDir
+			if len(tc.newState) == 0 {
+				lockDir = currentDir
+			}
+			rewriteLock(t, lockDir, func(lock *lockfile.LockFile) {
+				lock.Files["ops.json"].Policy = tc.policy
+			})
+
+			report, err := NewComparer(currentDir, desiredDir, WithThreeWayMerge()).Compare()
+			require.NoError(t, err)
+
+			require.Contains(t, report.Changes, "ops.json")
+			change := report.Changes["ops.json"]
+			assert.Equal(t, Conflict, change.Type)
+			assert.Equal(t, tc.policy, change.Policy)
+			assert.Equal(t, tc.expectedResolution, change.Resolution)
+			assert.Equal(t, tc.expectedResolution == Unresolved, report.HasConflicts())
+		})
+	}
+
+	t.Run("default policy should only apply to files without a policy", func(t *testing.T) {
+		currentDir, desiredDir := setupDiffDirs(t,
+			map[string]string{"ops.json": `{"old": true}`, "whitelist.json": `{"old": true}`},
+			map[string]string{"ops.json": `{"new": true}`, "whitelist.json": `{"new": true}`},
+			map[string]string{"ops.json": `{"edited": true}`, "whitelist.json": `{"edited": true}`})
+		rewriteLock(t, desiredDir, func(lock *lockfile.LockFile) {
+			lock.Files["ops.json"].Policy = lockfile.PolicyTakeDesired
+		})
+
+		report, err := NewComparer(currentDir, desiredDir, WithDefaultPolicy(lockfile.PolicyKeepLocal)).Compare()
+		require.NoError(t, err)
+
+		assert.Equal(t, TakeDesired, report.Changes["ops.json"].Resolution)
+		assert.Equal(t, KeepLocal, report.Changes["whitelist.json"].Resolution)
+		assert.False(t, report.HasConflicts())
+	})
+}
+
+func TestComparer_CompareSeeds(t *testing.T) {
+	testCases := []struct {
+		name        string
+		oldState    map[string]string
+		actualState map[string]string
+		expectSeed  bool
+	}{
+		{
+			name:       "should create absent seed",
+			expectSeed: true,
+		},
+		{
+			name:        "should not report existing seed as modified",
+			actualState: map[string]string{"permissions.yml": "edited by the server"},
+		},
+		{
+			name:        "should not report previously managed file as removed",
+			oldState:    map[string]string{"permissions.yml": "old"},
+			actualState: map[string]string{"permissions.yml": "edited by the server"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			currentDir, desiredDir := setupDiffDirs(t, tc.oldState,
+				map[string]string{"permissions.yml": "seed"}, tc.actualState)
+			rewriteLock(t, desiredDir, func(lock *lockfile.LockFile) {
+				lock.Seeds = lockfile.LockFiles{"permissions.yml": lock.Files["permissions.yml"]}
+				delete(lock.Files, "permissions.yml")
+			})
+
+			report, err := NewComparer(currentDir, desiredDir).Compare()
+			require.NoError(t, err)
+
+			if tc.expectSeed {
+				require.Contains(t, report.Changes, "permissions.yml")
+				assert.Equal(t, Created, report.Changes["permissions.yml"].Type)
+				assert.True(t, report.Changes["permissions.yml"].Seed)
+			} else {
+				assert.Empty(t, report.Changes)
+				assert.False(t, report.HasChanges())
+			}
+		})
+	}
+}
+
+func TestComparer_CompareUntracked(t *testing.T) {
+	managed := map[string]string{
+		"server.properties":      "motd=hello",
+		"plugins/Foo.jar":        "foo",
+		"plugins/Foo/config.yml": "a: 1",
+	}
+	actual := map[string]string{
+		"plugins/OldPlugin.jar":   "old",
+		"plugins/Old/data.yml":    "old",
+		"logs/latest.log":         "log",
+		"world/region/r.0.0.mca":  "world",
+		"plugins/Foo/cache.json":  "cache",
+		"plugins/Foo/ignored.tmp": "tmp",
+	}
+	currentDir, desiredDir := setupDiffDirs(t, managed, managed, actual)
+	rewriteLock(t, desiredDir, func(lock *lockfile.LockFile) {
+		lock.ExclusiveDirs = []string{"plugins"}
+	})
+
+	report, err := NewComparer(currentDir, desiredDir, WithUntracked("**/*.tmp")).Compare()
+	require.NoError(t, err)
+	assert.False(t, report.HasChanges())
+
+	assert.Equal(t, []*UntrackedFile{
+		{Path: "plugins/Foo/cache.json", Exclusive: true},
+		{Path: "plugins/Old/data.yml", Exclusive: true},
+		{Path: "plugins/OldPlugin.jar", Exclusive: true},
+	}, report.Untracked)
+	assert.Len(t, report.Prunable(), 3)
+
+	t.Run("should only report files next to managed files without exclusive dirs", func(t *testing.T) {
+		rewriteLock(t, desiredDir, func(lock *lockfile.LockFile) {
+			lock.ExclusiveDirs = nil
+		})
+		report, err := NewComparer(currentDir, desiredDir, WithUntracked("**/*.tmp")).Compare()
+		require.NoError(t, err)
+		assert.Equal(t, []*UntrackedFile{
+			{Path: "plugins/Foo/cache.json"},
+			{Path: "plugins/OldPlugin.jar"},
+		}, report.Untracked)
+		assert.Empty(t, report.Prunable())
+	})
+}
+
+func TestComparer_CompareModeOnlyChange(t *testing.T) {
+	files := map[string]string{"start.sh": "#!/bin/sh"}
+	currentDir, desiredDir := setupDiffDirs(t, files, files, files)
+	rewriteLock(t, currentDir, func(lock *lockfile.LockFile) {
+		lock.Files["start.sh"].Mode = "0644"
+	})
+	rewriteLock(t, desiredDir, func(lock *lockfile.LockFile) {
+		lock.Files["start.sh"].Mode = "0755"
+	})
+
+	report, err := NewComparer(currentDir, desiredDir).Compare()
+	require.NoError(t, err)
+
+	require.Contains(t, report.Changes, "start.sh")
+	change := report.Changes["start.sh"]
+	assert.Equal(t, Modified, change.Type)
+	assert.True(t, change.IsMetadataOnly())
+	assert.Equal(t, "0644", change.OldMode)
+	assert.Equal(t, "0755", change.NewMode)
+}
+
+func TestComparer_CompareOwnershipChange(t *testing.T) {
+	files := map[string]string{"secrets.yml": "token: abc"}
+	currentDir, desiredDir := setupDiffDirs(t, files, files, files)
+	uid, gid := 1000, 1001
+	rewriteLock(t, desiredDir, func(lock *lockfile.LockFile) {
+		lock.Files["secrets.yml"].UID = &uid
+		lock.Files["secrets.yml"].GID = &gid
+	})
+
+	report, err := NewComparer(currentDir, desiredDir).Compare()
+	require.NoError(t, err)
+
+	require.Contains(t, report.Changes, "secrets.yml")
+	change := report.Changes["secrets.yml"]
+	assert.Equal(t, Modified, change.Type)
+	assert.True(t, change.IsMetadataOnly())
+	assert.Equal(t, "-:-", change.OldOwner)
+	assert.Equal(t, "1000:1001", change.NewOwner)
+
+	t.Run("should not report unmanaged ownership", func(t *testing.T) {
+		rewriteLock(t, currentDir, func(lock *lockfile.LockFile) {
+			lock.Files["secrets.yml"].UID = &uid
+			lock.Files["secrets.yml"].GID = &gid
+		})
+		rewriteLock(t, desiredDir, func(lock *lockfile.LockFile) {
+			lock.Files["secrets.yml"].UID = nil
+			lock.Files["secrets.yml"].GID = nil
+		})
+		report, err := NewComparer(currentDir, desiredDir).Compare()
+		require.NoError(t, err)
+		assert.NotContains(t, report.Changes, "secrets.yml")
+	})
+}
+
+func TestComparer_ComparePathFilter(t *testing.T) {
+	oldState := map[string]string{
+		"server.properties":      "motd=old",
+		"plugins/Foo/config.yml": "a: 1",
+		"plugins/Foo.jar":        "v1",
+	}
+	newState := map[string]string{
+		"server.properties":      "motd=new",
+		"plugins/Foo/config.yml": "a: 2",
+		"plugins/Foo.jar":        "v2",
+		"plugins/Bar/config.yml": "b: 1",
+	}
+	currentDir, desiredDir := setupDiffDirs(t, oldState, newState, map[string]string{"plugins/Foo/cache.json": "{}"})
+
+	filter := &glob.Filter{Include: []string{"plugins/"}, Exclude: []string{"**/*.jar", "plugins/Bar/"}}
+	report, err := NewComparer(currentDir, desiredDir, WithPathFilter(filter), WithUntracked()).Compare()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"plugins/Foo/config.yml"}, report.SortedPaths())
+	assert.Equal(t, Modified, report.Changes["plugins/Foo/config.yml"].Type)
+	assert.Equal(t, []*UntrackedFile{{Path: "plugins/Foo/cache.json"}}, report.Untracked)
+
+	report, err = NewComparer(currentDir, desiredDir, WithPathFilter(&glob.Filter{Include: []string{"ops.json"}})).Compare()
+	require.NoError(t, err)
+	assert.False(t, report.HasChanges())
+	assert.Empty(t, report.Changes)
+}
+
+func TestComparer_CompareRenames(t *testing.T) {
+	oldState := map[string]string{
+		"plugins/Foo/config.yml": "a: 1",
+		"plugins/Foo/lang.yml":   "en: hi",
+		"a.txt":                  "same",
+		"b.txt":                  "same",
+		"gone.txt":               "gone",
+	}
+	newState := map[string]string{
+		"plugins/foo/config.yml": "a: 1",
+		"plugins/foo/lang.yml":   "en: hello",
+		"c.txt":                  "same",
+		"moved.txt":              "gone",
+	}
+	currentDir, desiredDir := setupDiffDirs(t, oldState, newState, nil)
+	// a file removed in the destination can't be renamed
+	require.NoError(t, os.Remove(filepath.Join(currentDir, "gone.txt")))
+
+	report, err := NewComparer(currentDir, desiredDir).Compare()
+	require.NoError(t, err)
+
+	renamed := report.Changes["plugins/foo/config.yml"]
+	require.NotNil(t, renamed)
+	assert.Equal(t, Renamed, renamed.Type)
+	assert.Equal(t, "plugins/Foo/config.yml", renamed.OldPath)
+	assert.NotContains(t, report.Changes, "plugins/Foo/config.yml")
+
+	assert.Equal(t, Removed, report.Changes["plugins/Foo/lang.yml"].Type, "changed files are not renamed")
+	assert.Equal(t, Created, report.Changes["plugins/foo/lang.yml"].Type)
+
+	assert.Equal(t, Renamed, report.Changes["c.txt"].Type)
+	assert.Equal(t, "a.txt", report.Changes["c.txt"].OldPath, "identical files are paired in sorted order")
+	assert.Equal(t, Removed, report.Changes["b.txt"].Type)
+
+	assert.Equal(t, Created, report.Changes["moved.txt"].Type)
+	assert.Equal(t, Removed, report.Changes["gone.txt"].Type)
+}
+
+func TestComparer_CompareSemanticEquality(t *testing.T) {
+	oldState := map[string]string{
+		"config.yml":        "a: 1\nb: [x, y]\n",
+		"ops.json":          `[{"name":"Steve"}]`,
+		"server.properties": "motd=hi",
+		"bukkit.yml":        "spawn: 1",
+	}
+	newState := map[string]string{
+		"config.yml":        "b:\n  - x\n  - y\na: 1\n",
+		"ops.json":          `[{"name":"Alex"}]`,
+		"server.properties": "motd=hi",
+		"bukkit.yml":        "spawn: 1",
+	}
+	actualState := map[string]string{
+		// rewritten by the server without changing its data
+		"server.properties": "#Minecraft server properties\nmotd = hi\n",
+		"bukkit.yml":        "spawn: 2",
+	}
+	currentDir, desiredDir := setupDiffDirs(t, oldState, newState, actualState)
+	rewriteLock(t, currentDir, func(lock *lockfile.LockFile) {
+		for path, content := range oldState {
+			p := filepath.Join(t.TempDir(), path)
+			require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+			dataHash, err := lockfile.DataHash(p)
+			require.NoError(t, err)
+			lock.Files[path].DataHash = dataHash
+		}
+	})
+
+	report, err := NewComparer(currentDir, desiredDir).Compare()
+	require.NoError(t, err)
+	assert.Equal(t, Modified, report.Changes["config.yml"].Type)
+	assert.Equal(t, Conflict, report.Changes["server.properties"].Type)
+	assert.Empty(t, report.Reformatted)
+
+	report, err = NewComparer(currentDir, desiredDir, WithSemanticEquality()).Compare()
+	require.NoError(t, err)
+	assert.NotContains(t, report.Changes, "config.yml")
+	assert.NotContains(t, report.Changes, "server.properties")
+	assert.Equal(t, []string{"config.yml", "server.properties"}, report.Reformatted)
+	assert.Equal(t, Modified, report.Changes["ops.json"].Type)
+	assert.Equal(t, Conflict, report.Changes["bukkit.yml"].Type)
+
+	t.Run("should not treat files without data hash as equal", func(t *testing.T) {
+		rewriteLock(t, currentDir, func(lock *lockfile.LockFile) {
+			lock.Files["config.yml"].DataHash = ""
+		})
+		report, err := NewComparer(currentDir, desiredDir, WithSemanticEquality()).Compare()
+		require.NoError(t, err)
+		assert.Equal(t, Modified, report.Changes["config.yml"].Type)
+	})
+}
+
+func TestComparer_CompareSources(t *testing.T) {
+	currentDir, desiredDir := setupDiffDirs(t,
+		map[string]string{"config.yml": "a: 1", "old.yml": "o: 1"},
+		map[string]string{"config.yml": "a: 2", "new.yml": "o: 1"},
+		nil)
+	base := &lockfile.FileSource{Target: "lobby", Layers: []*lockfile.SourceLayer{{Template: "base", Strategy: "copy-only"}}}
+	patched := &lockfile.FileSource{Target: "lobby", Layers: []*lockfile.SourceLayer{
+		{Template: "base", Strategy: "copy-only"},
+		{Template: "lobby", Strategy: "yaml-patch"},
+	}}
+	rewriteLock(t, currentDir, func(lock *lockfile.LockFile) {
+		lock.Files["config.yml"].Source = base
+		lock.Files["old.yml"].Source = base
+	})
+	rewriteLock(t, desiredDir, func(lock *lockfile.LockFile) {
+		lock.Files["config.yml"].Source = patched
+		lock.Files["new.yml"].Source = patched
+	})
+
+	report, err := NewComparer(currentDir, desiredDir).Compare()
+	require.NoError(t, err)
+	assert.Equal(t, base, report.Changes["config.yml"].OldSource)
+	assert.Equal(t, patched, report.Changes["config.yml"].NewSource)
+
+	renamed := report.Changes["new.yml"]
+	require.Equal(t, Renamed, renamed.Type)
+	assert.Equal(t, base, renamed.OldSource, "the source of renamed files is taken from their old path")
+	assert.Equal(t, patched, renamed.NewSource)
+}
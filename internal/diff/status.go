@@ -0,0 +1,182 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/sap-gg/gok/internal"
+	"github.com/sap-gg/gok/internal/glob"
+	"github.com/sap-gg/gok/internal/lockfile"
+)
+
+// StatusType represents the kind of drift detected for a file.
+type StatusType string
+
+const (
+	// Drifted files have content which differs from the lock file.
+	Drifted StatusType = "drifted"
+	// Missing files are recorded in the lock file but don't exist.
+	Missing StatusType = "missing"
+	// Untracked files exist but are not recorded in the lock file.
+	Untracked StatusType = "untracked"
+	// Local files were changed or removed, but their conflict policy keeps the local changes (keep-local),
+	// so they are not considered drift.
+	Local StatusType = "local"
+)
+
+// FileStatus is the drift status of a single file.
+type FileStatus struct {
+	Path         string     `json:"path"`
+	Status       StatusType `json:"status"`
+	ExpectedHash string     `json:"expectedHash,omitempty"`
+	ActualHash   string     `json:"actualHash,omitempty"`
+	// Source describes how a managed file was rendered, if recorded in the lock file.
+	Source *lockfile.FileSource `json:"source,omitempty"`
+}
+
+// StatusReport contains the results of a status check.
+type StatusReport struct {
+	Files []*FileStatus `json:"files"`
+}
+
+// HasDrift returns true if any managed file was changed or removed.
+// Untracked and local files are not considered drift.
+func (r *StatusReport) HasDrift() bool {
+	for _, f := range r.Files {
+		if f.Status == Drifted || f.Status == Missing {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusOptions configures which files are checked by Status.
+type StatusOptions struct {
+	// Include limits the check to files matching at least one of these globs (if any)
+	Include []string
+	// Ignore excludes untracked files matching any of these globs
+	Ignore []string
+	// Untracked enables reporting of untracked files located directly in a directory containing managed files
+	// or inside an exclusively managed directory, the same as the untracked files of a diff
+	Untracked bool
+	// AllUntracked reports untracked files anywhere in the destination, e.g. also worlds and logs.
+	// It's only used if Untracked is set.
+	AllUntracked bool
+}
+
+func (o *StatusOptions) included(path string) bool {
+	return len(o.Include) == 0 || glob.MatchAny(o.Include, path)
+}
+
+// Status compares the files in dir with the lock file in dir only.
+func Status(dir string, opts StatusOptions) (*StatusReport, error) {
+	if _, err := os.Stat(filepath.Join(dir, internal.LockFileName)); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("no %s found in %q", internal.LockFileName, dir)
+		}
+		return nil, fmt.Errorf("checking lock file: %w", err)
+	}
+	lock, err := lockfile.Read(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading lock file: %w", err)
+	}
+
+	report := &StatusReport{Files: []*FileStatus{}}
+	for path, entry := range lock.Files {
+		if !opts.included(path) {
+			continue
+		}
+		actualHash, err := lockfile.PathHash(filepath.Join(dir, path))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("computing hash for %q: %w", path, err)
+		}
+		if actualHash == entry.Hash {
+			continue
+		}
+
+		status := &FileStatus{
+			Path:         path,
+			Status:       Drifted,
+			ExpectedHash: entry.Hash,
+			ActualHash:   actualHash,
+			Source:       entry.Source,
+		}
+		switch {
+		case entry.Policy == lockfile.PolicyKeepLocal:
+			// an apply keeps the local changes as well
+			status.Status = Local
+		case actualHash == "":
+			status.Status = Missing
+		}
+		report.Files = append(report.Files, status)
+	}
+
+	if opts.Untracked {
+		paths, err := FindUntracked(dir, lock, opts.Ignore)
+		if err != nil {
+			return nil, err
+		}
+		if !opts.AllUntracked {
+			scoped := scopeUntracked(paths, lock.ExclusiveDirs, lock)
+			paths = make([]string, len(scoped))
+			for i, f := range scoped {
+				paths[i] = f.Path
+			}
+		}
+		for _, path := range paths {
+			if opts.included(path) {
+				report.Files = append(report.Files, &FileStatus{Path: path, Status: Untracked})
+			}
+		}
+	}
+
+	sort.Slice(report.Files, func(i, j int) bool {
+		return report.Files[i].Path < report.Files[j].Path
+	})
+	return report, nil
+}
+
+// FindUntracked walks dir and returns all files which are neither managed files nor seeds of the lock.
+// The lock file and the state directory are never reported. Paths matching any of the ignore globs are skipped.
+func FindUntracked(dir string, lock *lockfile.LockFile, ignore []string) ([]string, error) {
+	var untracked []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return fmt.Errorf("determining relative path: %w", err)
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if rel == internal.StateDirName || glob.MatchAny(ignore, rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if rel == internal.LockFileName || glob.MatchAny(ignore, rel) {
+			return nil
+		}
+		if _, ok := lock.Files[rel]; ok {
+			return nil
+		}
+		if _, ok := lock.Seeds[rel]; ok {
+			return nil
+		}
+		untracked = append(untracked, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walking %q: %w", dir, err)
+	}
+	return untracked, nil
+}
@@ -0,0 +1,134 @@
+package diff
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sap-gg/gok/internal"
+	"github.com/sap-gg/gok/internal/lockfile"
+)
+
+func TestStatus(t *testing.T) {
+	currentDir, _ := setupDiffDirs(t,
+		map[string]string{
+			"server.properties":       "motd=hello",
+			"plugins/Foo/config.yml":  "a: 1",
+			"plugins/Bar/config.yml":  "b: 1",
+			"plugins/Baz/removed.yml": "c: 1",
+		},
+		nil,
+		map[string]string{
+			"server.properties":      "motd=edited",
+			"plugins/OldPlugin.jar":  "jar",
+			"world/level.dat":        "world",
+			"plugins/Foo/config.yml": "a: 1",
+			"stray.txt":              "stray",
+		})
+	require.NoError(t, os.Remove(filepath.Join(currentDir, "plugins/Baz/removed.yml")))
+	require.NoError(t, os.MkdirAll(filepath.Join(currentDir, internal.StateDirName), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(currentDir, internal.StateDirName, "x"), []byte("x"), 0644))
+
+	statusOf := func(report *StatusReport) map[string]StatusType {
+		m := make(map[string]StatusType)
+		for _, f := range report.Files {
+			m[f.Path] = f.Status
+		}
+		return m
+	}
+
+	t.Run("should report drifted, missing and untracked files", func(t *testing.T) {
+		report, err := Status(currentDir, StatusOptions{Untracked: true})
+		require.NoError(t, err)
+		assert.True(t, report.HasDrift())
+		assert.Equal(t, map[string]StatusType{
+			"server.properties":       Drifted,
+			"plugins/Baz/removed.yml": Missing,
+			"stray.txt":               Untracked,
+		}, statusOf(report), "only untracked files next to managed files must be reported, like in a diff")
+	})
+
+	t.Run("should report all untracked files", func(t *testing.T) {
+		report, err := Status(currentDir, StatusOptions{Untracked: true, AllUntracked: true, Ignore: []string{"world/"}})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]StatusType{
+			"server.properties":       Drifted,
+			"plugins/Baz/removed.yml": Missing,
+			"plugins/OldPlugin.jar":   Untracked,
+			"stray.txt":               Untracked,
+		}, statusOf(report))
+	})
+
+	t.Run("should report untracked files of exclusively managed directories", func(t *testing.T) {
+		rewriteLock(t, currentDir, func(lock *lockfile.LockFile) {
+			lock.ExclusiveDirs = []string{"plugins"}
+		})
+		defer rewriteLock(t, currentDir, func(lock *lockfile.LockFile) {
+			lock.ExclusiveDirs = nil
+		})
+		report, err := Status(currentDir, StatusOptions{Untracked: true, Include: []string{"plugins/*.jar"}})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]StatusType{
+			"plugins/OldPlugin.jar": Untracked,
+		}, statusOf(report))
+	})
+
+	t.Run("should limit the check to the given globs", func(t *testing.T) {
+		report, err := Status(currentDir, StatusOptions{Untracked: true, AllUntracked: true, Include: []string{"plugins/*.jar"}})
+		require.NoError(t, err)
+		assert.False(t, report.HasDrift())
+		assert.Equal(t, map[string]StatusType{
+			"plugins/OldPlugin.jar": Untracked,
+		}, statusOf(report))
+	})
+
+	t.Run("should include the source of managed files", func(t *testing.T) {
+		source := &lockfile.FileSource{Target: "survival", Layers: []*lockfile.SourceLayer{{Template: "base", Strategy: "copy-only"}}}
+		rewriteLock(t, currentDir, func(lock *lockfile.LockFile) {
+			lock.Files["server.properties"].Source = source
+		})
+		report, err := Status(currentDir, StatusOptions{Include: []string{"server.properties"}})
+		require.NoError(t, err)
+		require.Len(t, report.Files, 1)
+		assert.Equal(t, source, report.Files[0].Source)
+	})
+
+	t.Run("should fail without a lock file", func(t *testing.T) {
+		_, err := Status(t.TempDir(), StatusOptions{})
+		assert.Error(t, err)
+	})
+}
+
+func TestStatusPolicies(t *testing.T) {
+	currentDir, _ := setupDiffDirs(t,
+		map[string]string{
+			"server.properties":      "motd=hello",
+			"whitelist.json":         "[]",
+			"plugins/Foo/config.yml": "a: 1",
+		},
+		nil,
+		map[string]string{
+			"server.properties":      "motd=edited",
+			"plugins/Foo/config.yml": "a: 2",
+		})
+	require.NoError(t, os.Remove(filepath.Join(currentDir, "whitelist.json")))
+	rewriteLock(t, currentDir, func(lock *lockfile.LockFile) {
+		lock.Files["server.properties"].Policy = lockfile.PolicyKeepLocal
+		lock.Files["whitelist.json"].Policy = lockfile.PolicyKeepLocal
+		// the merged content kept by an apply
+		entry := lock.Files["plugins/Foo/config.yml"]
+		entry.BaseHash, entry.Hash = entry.Hash, lockfile.SHA256([]byte("a: 2"))
+	})
+
+	report, err := Status(currentDir, StatusOptions{})
+	require.NoError(t, err)
+	assert.False(t, report.HasDrift(), "local changes kept by policy and merged content are no drift")
+	require.Len(t, report.Files, 2)
+	assert.Equal(t, "server.properties", report.Files[0].Path)
+	assert.Equal(t, Local, report.Files[0].Status)
+	assert.Equal(t, "whitelist.json", report.Files[1].Path)
+	assert.Equal(t, Local, report.Files[1].Status)
+}
@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// CopyPath copies the regular file, symlink or directory at srcPath to dstPath, preserving its mode.
+// Parent directories are created as needed and an existing symlink at dstPath is replaced, never written through.
+func CopyPath(srcPath, dstPath string) error {
+	info, err := os.Lstat(srcPath)
+	if err != nil {
+		return fmt.Errorf("stat source file %q: %w", srcPath, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return fmt.Errorf("create parent directories for %q: %w", dstPath, err)
+	}
+
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		link, err := os.Readlink(srcPath)
+		if err != nil {
+			return fmt.Errorf("read symlink %q: %w", srcPath, err)
+		}
+		if err := os.Remove(dstPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove existing %q: %w", dstPath, err)
+		}
+		return os.Symlink(link, dstPath)
+	case info.IsDir():
+		if err := os.MkdirAll(dstPath, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("create directory %q: %w", dstPath, err)
+		}
+		return os.Chmod(dstPath, info.Mode().Perm())
+	}
+
+	// never write "through" an existing symlink
+	if dstInfo, err := os.Lstat(dstPath); err == nil && dstInfo.Mode()&fs.ModeSymlink != 0 {
+		if err := os.Remove(dstPath); err != nil {
+			return fmt.Errorf("remove existing symlink %q: %w", dstPath, err)
+		}
+	}
+
+	srcFile, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("open source file %q: %w", srcPath, err)
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("create destination file %q: %w", dstPath, err)
+	}
+	defer dstFile.Close()
+
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		return err
+	}
+	// the mode is only used by OpenFile if the file didn't exist yet
+	return os.Chmod(dstPath, info.Mode().Perm())
+}
@@ -0,0 +1,95 @@
+package glob
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Match reports whether the slash-separated path matches the pattern.
+// In addition to the syntax of path.Match, a "**" segment matches zero or more path segments,
+// and a pattern ending in "/" matches everything below that directory.
+func Match(pattern, name string) bool {
+	pattern = strings.TrimPrefix(pattern, "./")
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// MatchAny reports whether the path matches at least one of the patterns.
+func MatchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if Match(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter selects paths using include and exclude patterns.
+type Filter struct {
+	// Include selects only paths matching at least one of these patterns (all paths if empty)
+	Include []string
+	// Exclude deselects paths matching any of these patterns, even if they are included
+	Exclude []string
+}
+
+// IsEmpty returns true if the filter selects all paths.
+func (f *Filter) IsEmpty() bool {
+	return f == nil || (len(f.Include) == 0 && len(f.Exclude) == 0)
+}
+
+// Match reports whether the filter selects the slash-separated path. A nil filter selects all paths.
+func (f *Filter) Match(name string) bool {
+	if f.IsEmpty() {
+		return true
+	}
+	return (len(f.Include) == 0 || MatchAny(f.Include, name)) && !MatchAny(f.Exclude, name)
+}
+
+// Validate checks if all patterns of the filter are syntactically valid.
+func (f *Filter) Validate() error {
+	for _, pattern := range append(slices.Clone(f.Include), f.Exclude...) {
+		if err := Validate(pattern); err != nil {
+			return fmt.Errorf("invalid glob %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks if the pattern is syntactically valid.
+func Validate(pattern string) error {
+	for _, segment := range strings.Split(pattern, "/") {
+		if segment == "**" {
+			continue
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// try to match the rest of the pattern at every possible position
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
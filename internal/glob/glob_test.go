@@ -0,0 +1,55 @@
+package glob
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	testCases := []struct {
+		pattern  string
+		name     string
+		expected bool
+	}{
+		{pattern: "ops.json", name: "ops.json", expected: true},
+		{pattern: "ops.json", name: "plugins/ops.json", expected: false},
+		{pattern: "*.json", name: "whitelist.json", expected: true},
+		{pattern: "*.json", name: "plugins/whitelist.json", expected: false},
+		{pattern: "**/*.json", name: "whitelist.json", expected: true},
+		{pattern: "**/*.json", name: "plugins/Foo/config.json", expected: true},
+		{pattern: "plugins/**", name: "plugins/Foo/config.yml", expected: true},
+		{pattern: "plugins/", name: "plugins/Foo/config.yml", expected: true},
+		{pattern: "plugins/", name: "world/level.dat", expected: false},
+		{pattern: "plugins/*/config.yml", name: "plugins/Foo/config.yml", expected: true},
+		{pattern: "plugins/*/config.yml", name: "plugins/Foo/Bar/config.yml", expected: false},
+		{pattern: "./logs/**", name: "logs/latest.log", expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.pattern+" "+tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Match(tc.pattern, tc.name))
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate("plugins/**/*.yml"))
+	assert.Error(t, Validate("plugins/[a-"))
+}
+
+func TestFilter(t *testing.T) {
+	var none *Filter
+	assert.True(t, none.Match("ops.json"))
+
+	f := &Filter{Include: []string{"plugins/"}, Exclude: []string{"**/*.jar"}}
+	assert.True(t, f.Match("plugins/Foo/config.yml"))
+	assert.False(t, f.Match("plugins/Foo.jar"), "excludes take precedence")
+	assert.False(t, f.Match("server.properties"))
+
+	f = &Filter{Exclude: []string{"world/"}}
+	assert.True(t, f.Match("server.properties"))
+	assert.False(t, f.Match("world/level.dat"))
+
+	assert.Error(t, (&Filter{Exclude: []string{"[a-"}}).Validate())
+}
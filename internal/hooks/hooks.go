@@ -0,0 +1,226 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sap-gg/gok/internal/diff"
+	"github.com/sap-gg/gok/internal/lockfile"
+)
+
+// waitDelay is the time a hook may keep its output open after it was killed (e.g. by orphaned child processes).
+const waitDelay = 5 * time.Second
+
+// Phase is the point of an apply at which hooks are run.
+type Phase string
+
+const (
+	// PreApply hooks are run before any file is changed.
+	PreApply Phase = "preApply"
+	// PostApply hooks are run after all files were changed.
+	PostApply Phase = "postApply"
+)
+
+// Select returns the hooks declared for the phase.
+func (p Phase) Select(h *lockfile.Hooks) []*lockfile.Hook {
+	if h == nil {
+		return nil
+	}
+	if p == PreApply {
+		return h.PreApply
+	}
+	return h.PostApply
+}
+
+// Event describes the changes of an apply. It's passed to hooks as JSON on stdin.
+type Event struct {
+	Phase Phase `json:"phase"`
+	// Target is the ID of the target whose output is applied (if known).
+	Target string `json:"target,omitempty"`
+	// Directory is the directory the changes are applied to, it's also the working directory of hooks.
+	Directory string `json:"directory"`
+	// Changes are all paths (relative to Directory) which are changed by the apply.
+	Changes []*ChangedPath `json:"changes"`
+	// Conflicts are all paths (relative to Directory) with conflicts, including the ones which are kept as-is.
+	Conflicts []*ChangedPath `json:"conflicts"`
+}
+
+// ChangedPath is a single path of an Event.
+type ChangedPath struct {
+	Path string `json:"path"`
+	// Type is the change type, e.g. created or conflict, or pruned for removed untracked files.
+	Type string `json:"type"`
+	// Resolution is only set for conflicts.
+	Resolution string `json:"resolution,omitempty"`
+	// From is the previous path of a renamed file.
+	From string `json:"from,omitempty"`
+}
+
+// NewEvent collects the changes of the report and the pruned files for hooks running in dir.
+// The relative function maps paths of the report to paths relative to dir, paths for which it returns false are
+// not included.
+func NewEvent(
+	phase Phase,
+	target, dir string,
+	report *diff.Report,
+	pruned []*diff.UntrackedFile,
+	relative func(path string) (string, bool),
+) *Event {
+	event := &Event{
+		Phase:     phase,
+		Target:    target,
+		Directory: dir,
+		Changes:   []*ChangedPath{},
+		Conflicts: []*ChangedPath{},
+	}
+	for _, path := range report.SortedPaths() {
+		change := report.Changes[path]
+		rel, ok := relative(path)
+		if !ok || change.Type == diff.Unchanged {
+			continue
+		}
+		changed := &ChangedPath{Path: rel, Type: change.Type.String()}
+		if change.Type == diff.Renamed {
+			if from, ok := relative(change.OldPath); ok {
+				changed.From = from
+			}
+		}
+		if change.Type == diff.Conflict {
+			changed.Resolution = change.Resolution.String()
+			event.Conflicts = append(event.Conflicts, changed)
+			if change.Resolution == diff.KeepLocal {
+				// the file is not touched
+				continue
+			}
+		}
+		event.Changes = append(event.Changes, changed)
+	}
+	for _, u := range pruned {
+		if rel, ok := relative(u.Path); ok {
+			event.Changes = append(event.Changes, &ChangedPath{Path: rel, Type: "pruned"})
+		}
+	}
+	return event
+}
+
+// HasChanges returns true if any path is changed.
+func (e *Event) HasChanges() bool {
+	return len(e.Changes) > 0
+}
+
+// Environ returns the environment variables describing the event:
+// GOK_HOOK_PHASE, GOK_TARGET, GOK_DIRECTORY and the newline-separated GOK_CHANGED_PATHS and GOK_CONFLICTS.
+func (e *Event) Environ() []string {
+	return []string{
+		"GOK_HOOK_PHASE=" + string(e.Phase),
+		"GOK_TARGET=" + e.Target,
+		"GOK_DIRECTORY=" + e.Directory,
+		"GOK_CHANGED_PATHS=" + strings.Join(e.changedPaths(), "\n"),
+		"GOK_CONFLICTS=" + strings.Join(paths(e.Conflicts), "\n"),
+	}
+}
+
+// changedPaths returns all changed paths, including the previous paths of renamed files.
+func (e *Event) changedPaths() []string {
+	changed := paths(e.Changes)
+	for _, c := range e.Changes {
+		if c.From != "" {
+			changed = append(changed, c.From)
+		}
+	}
+	return changed
+}
+
+func paths(changed []*ChangedPath) []string {
+	s := make([]string, len(changed))
+	for i, p := range changed {
+		s[i] = p.Path
+	}
+	return s
+}
+
+// Error is returned by Run if a hook failed.
+type Error struct {
+	Hook *lockfile.Hook
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("hook %q failed: %v", e.Hook.DisplayName(), e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Policy returns how the failure should be handled.
+func (e *Error) Policy() lockfile.HookFailurePolicy {
+	return e.Hook.FailurePolicy()
+}
+
+// Run runs the hooks one after another in the directory of the event, writing their output to out.
+// The event is passed as JSON on stdin and as environment variables (see Event.Environ).
+// Hooks whose When globs don't match any changed path are skipped.
+// Failures of hooks with the HookIgnore policy are logged, the first other failure stops the run
+// and is returned as *Error.
+func Run(ctx context.Context, hooks []*lockfile.Hook, event *Event, out io.Writer) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("encoding hook event: %w", err)
+	}
+
+	changed := event.changedPaths()
+	for _, hook := range hooks {
+		if !hook.Matches(changed) {
+			log.Debug().Str("hook", hook.DisplayName()).Msg("no matching path changed, skipping hook")
+			continue
+		}
+		log.Info().Str("hook", hook.DisplayName()).Str("dir", event.Directory).Msgf("running %s hook", event.Phase)
+		if err := run(ctx, hook, event, payload, out); err != nil {
+			if hook.FailurePolicy() == lockfile.HookIgnore {
+				log.Warn().Err(err).Str("hook", hook.DisplayName()).Msg("hook failed, ignoring")
+				continue
+			}
+			return &Error{Hook: hook, Err: err}
+		}
+	}
+	return nil
+}
+
+func run(ctx context.Context, hook *lockfile.Hook, event *Event, payload []byte, out io.Writer) error {
+	timeout := hook.EffectiveTimeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if hook.RCON != nil {
+		err := runRCON(ctx, hook.RCON, event.Directory, out)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %s", timeout)
+		}
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Dir = event.Directory
+	cmd.Env = append(os.Environ(), event.Environ()...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.WaitDelay = waitDelay
+
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	return err
+}
@@ -0,0 +1,147 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sap-gg/gok/internal/diff"
+	"github.com/sap-gg/gok/internal/lockfile"
+)
+
+func testReport() *diff.Report {
+	return &diff.Report{Changes: map[string]*diff.Change{
+		"survival/server.properties": {Type: diff.Modified, Path: "survival/server.properties"},
+		"survival/ops.json":          {Type: diff.Unchanged, Path: "survival/ops.json"},
+		"survival/bukkit.yml":        {Type: diff.Conflict, Path: "survival/bukkit.yml", Resolution: diff.Merged},
+		"survival/whitelist.json":    {Type: diff.Conflict, Path: "survival/whitelist.json", Resolution: diff.KeepLocal},
+		"proxy/velocity.toml":        {Type: diff.Created, Path: "proxy/velocity.toml"},
+	}}
+}
+
+func survivalOnly(path string) (string, bool) {
+	return strings.CutPrefix(path, "survival/")
+}
+
+func TestNewEvent(t *testing.T) {
+	pruned := []*diff.UntrackedFile{
+		{Path: "survival/plugins/Old.jar", Exclusive: true},
+		{Path: "proxy/plugins/Old.jar", Exclusive: true},
+	}
+	event := NewEvent(PostApply, "survival", "/srv/survival", testReport(), pruned, survivalOnly)
+
+	assert.Equal(t, []*ChangedPath{
+		{Path: "bukkit.yml", Type: "conflict", Resolution: "merged"},
+		{Path: "server.properties", Type: "modified"},
+		{Path: "plugins/Old.jar", Type: "pruned"},
+	}, event.Changes, "unchanged files, kept conflicts and other targets must not be included")
+	assert.Equal(t, []*ChangedPath{
+		{Path: "bukkit.yml", Type: "conflict", Resolution: "merged"},
+		{Path: "whitelist.json", Type: "conflict", Resolution: "keep-local"},
+	}, event.Conflicts)
+	assert.True(t, event.HasChanges())
+
+	assert.Equal(t, []string{
+		"GOK_HOOK_PHASE=postApply",
+		"GOK_TARGET=survival",
+		"GOK_DIRECTORY=/srv/survival",
+		"GOK_CHANGED_PATHS=bukkit.yml\nserver.properties\nplugins/Old.jar",
+		"GOK_CONFLICTS=bukkit.yml\nwhitelist.json",
+	}, event.Environ())
+
+	empty := NewEvent(PreApply, "lobby", "/srv/lobby", testReport(), nil, func(string) (string, bool) {
+		return "", false
+	})
+	assert.False(t, empty.HasChanges())
+}
+
+func TestNewEventRename(t *testing.T) {
+	report := &diff.Report{Changes: map[string]*diff.Change{
+		"survival/plugins/foo/config.yml": {
+			Type:    diff.Renamed,
+			Path:    "survival/plugins/foo/config.yml",
+			OldPath: "survival/plugins/Foo/config.yml",
+		},
+	}}
+	event := NewEvent(PostApply, "survival", "/srv/survival", report, nil, survivalOnly)
+
+	assert.Equal(t, []*ChangedPath{
+		{Path: "plugins/foo/config.yml", Type: "renamed", From: "plugins/Foo/config.yml"},
+	}, event.Changes)
+	assert.Contains(t, event.Environ(), "GOK_CHANGED_PATHS=plugins/foo/config.yml\nplugins/Foo/config.yml",
+		"the previous path is changed as well")
+}
+
+func TestRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are tested using sh")
+	}
+
+	dir := t.TempDir()
+	event := NewEvent(PostApply, "survival", dir, testReport(), nil, survivalOnly)
+
+	t.Run("passes the event on stdin and in the environment", func(t *testing.T) {
+		var out bytes.Buffer
+		err := Run(context.Background(), []*lockfile.Hook{
+			{Command: []string{"sh", "-c", `cat > event.json; printf '%s' "$GOK_CHANGED_PATHS" > changed.txt`}},
+			{Command: []string{"sh", "-c", `echo "reloading $GOK_TARGET in $(pwd)"`}},
+		}, event, &out)
+		require.NoError(t, err)
+
+		payload, err := os.ReadFile(filepath.Join(dir, "event.json"))
+		require.NoError(t, err)
+		var decoded Event
+		require.NoError(t, json.Unmarshal(payload, &decoded))
+		assert.Equal(t, event, &decoded)
+
+		changed, err := os.ReadFile(filepath.Join(dir, "changed.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "bukkit.yml\nserver.properties", string(changed))
+
+		assert.Contains(t, out.String(), "reloading survival in ")
+	})
+
+	t.Run("stops at the first failure", func(t *testing.T) {
+		failing := &lockfile.Hook{Name: "restart", Command: []string{"sh", "-c", "exit 3"}, OnFailure: lockfile.HookRollback}
+		err := Run(context.Background(), []*lockfile.Hook{
+			failing,
+			{Command: []string{"sh", "-c", "touch not-reached"}},
+		}, event, &bytes.Buffer{})
+
+		var hookErr *Error
+		require.True(t, errors.As(err, &hookErr))
+		assert.Same(t, failing, hookErr.Hook)
+		assert.Equal(t, lockfile.HookRollback, hookErr.Policy())
+		assert.ErrorContains(t, err, `hook "restart" failed`)
+		assert.NoFileExists(t, filepath.Join(dir, "not-reached"))
+	})
+
+	t.Run("ignores failures if configured", func(t *testing.T) {
+		err := Run(context.Background(), []*lockfile.Hook{
+			{Command: []string{"does-not-exist-gok-hook"}, OnFailure: lockfile.HookIgnore},
+			{Command: []string{"sh", "-c", "touch reached"}},
+		}, event, &bytes.Buffer{})
+		require.NoError(t, err)
+		assert.FileExists(t, filepath.Join(dir, "reached"))
+	})
+
+	t.Run("kills hooks exceeding the timeout", func(t *testing.T) {
+		start := time.Now()
+		err := Run(context.Background(), []*lockfile.Hook{
+			{Command: []string{"sleep", "10"}, Timeout: 100 * time.Millisecond},
+		}, event, &bytes.Buffer{})
+		assert.ErrorContains(t, err, "timed out after 100ms")
+		assert.Less(t, time.Since(start), 5*time.Second)
+		assert.Equal(t, lockfile.HookFail, err.(*Error).Policy(), "fail must be the default policy")
+	})
+}
@@ -0,0 +1,107 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/magiconair/properties"
+
+	"github.com/sap-gg/gok/internal/lockfile"
+	"github.com/sap-gg/gok/internal/rcon"
+)
+
+// defaultRCONPort is the default rcon.port of Minecraft servers.
+const defaultRCONPort = 25575
+
+// rconSettings are the connection settings of an RCON action.
+type rconSettings struct {
+	address  string
+	password string
+}
+
+// resolveRCON determines the connection settings of the action. Values which are not set explicitly
+// are read from the server properties in dir.
+func resolveRCON(action *lockfile.RCONAction, dir string) (*rconSettings, error) {
+	propertiesPath := action.Properties
+	if propertiesPath == "" {
+		propertiesPath = lockfile.DefaultServerProperties
+	}
+	if !filepath.IsAbs(propertiesPath) {
+		propertiesPath = filepath.Join(dir, filepath.FromSlash(propertiesPath))
+	}
+
+	props := properties.NewProperties()
+	loader := &properties.Loader{Encoding: properties.UTF8, DisableExpansion: true}
+	loaded, err := loader.LoadFile(propertiesPath)
+	switch {
+	case err == nil:
+		props = loaded
+		if !props.GetBool("enable-rcon", false) {
+			return nil, fmt.Errorf("rcon is not enabled in %s (enable-rcon=true)", propertiesPath)
+		}
+	case errors.Is(err, fs.ErrNotExist):
+		if action.Port == 0 || action.PasswordEnv == "" {
+			return nil, fmt.Errorf("%s not found, set the port and passwordEnv of the rcon action", propertiesPath)
+		}
+	default:
+		return nil, fmt.Errorf("reading %s: %w", propertiesPath, err)
+	}
+
+	host := action.Host
+	if host == "" {
+		host = lockfile.DefaultRCONHost
+	}
+	port := action.Port
+	if port == 0 {
+		port = props.GetInt("rcon.port", defaultRCONPort)
+	}
+
+	password := props.GetString("rcon.password", "")
+	if action.PasswordEnv != "" {
+		var ok bool
+		if password, ok = os.LookupEnv(action.PasswordEnv); !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", action.PasswordEnv)
+		}
+	}
+	if password == "" {
+		return nil, fmt.Errorf("no rcon password set (rcon.password in %s or passwordEnv)", propertiesPath)
+	}
+
+	return &rconSettings{
+		address:  net.JoinHostPort(host, strconv.Itoa(port)),
+		password: password,
+	}, nil
+}
+
+// runRCON sends the commands of the action to the server, writing the responses to out.
+func runRCON(ctx context.Context, action *lockfile.RCONAction, dir string, out io.Writer) error {
+	settings, err := resolveRCON(action, dir)
+	if err != nil {
+		return err
+	}
+
+	client, err := rcon.Dial(ctx, settings.address, settings.password)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	for _, command := range action.Commands {
+		response, err := client.Command(command)
+		if err != nil {
+			return fmt.Errorf("rcon command %q: %w", command, err)
+		}
+		_, _ = fmt.Fprintf(out, "rcon> %s\n", command)
+		if response != "" {
+			_, _ = fmt.Fprintln(out, response)
+		}
+	}
+	return nil
+}
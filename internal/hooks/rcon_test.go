@@ -0,0 +1,125 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sap-gg/gok/internal/lockfile"
+	"github.com/sap-gg/gok/internal/rcon/rcontest"
+)
+
+func writeServerProperties(t *testing.T, dir, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "server.properties"), []byte(content), 0o600))
+}
+
+func TestRunRCON(t *testing.T) {
+	server := rcontest.NewServer(t, "s3cr3t")
+	dir := t.TempDir()
+	writeServerProperties(t, dir, fmt.Sprintf("enable-rcon=true\nrcon.port=%d\nrcon.password=s3cr3t\n", server.Port()))
+
+	event := NewEvent(PostApply, "survival", dir, testReport(), nil, survivalOnly)
+	var out bytes.Buffer
+	err := Run(context.Background(), []*lockfile.Hook{
+		{
+			Name: "announce",
+			RCON: &lockfile.RCONAction{Commands: []string{"say Restarting in 60s"}},
+		},
+		{
+			Name: "reload plugins",
+			RCON: &lockfile.RCONAction{Commands: []string{"reload confirm"}},
+			When: []string{"plugins/**"},
+		},
+		{
+			Name: "reload server properties",
+			RCON: &lockfile.RCONAction{Commands: []string{"save-all", "stop"}},
+			When: []string{"*.properties"},
+		},
+	}, event, &out)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"say Restarting in 60s", "save-all", "stop"}, server.Commands(),
+		"hooks without matching changed paths must be skipped")
+	assert.Contains(t, out.String(), "rcon> stop\n")
+}
+
+func TestResolveRCON(t *testing.T) {
+	t.Setenv("GOK_TEST_RCON_PASSWORD", "from-env")
+
+	testCases := []struct {
+		name       string
+		properties string
+		action     *lockfile.RCONAction
+		expected   *rconSettings
+		errorMsg   string
+	}{
+		{
+			name:       "from server properties",
+			properties: "enable-rcon=true\nrcon.port=25580\nrcon.password=pw\n",
+			action:     &lockfile.RCONAction{},
+			expected:   &rconSettings{address: "127.0.0.1:25580", password: "pw"},
+		},
+		{
+			name:       "default port",
+			properties: "enable-rcon=true\nrcon.password=pw\n",
+			action:     &lockfile.RCONAction{Host: "mc.internal"},
+			expected:   &rconSettings{address: "mc.internal:25575", password: "pw"},
+		},
+		{
+			name:       "explicit values override properties",
+			properties: "enable-rcon=true\nrcon.port=25580\nrcon.password=pw\n",
+			action:     &lockfile.RCONAction{Port: 25590, PasswordEnv: "GOK_TEST_RCON_PASSWORD"},
+			expected:   &rconSettings{address: "127.0.0.1:25590", password: "from-env"},
+		},
+		{
+			name:     "explicit values without properties",
+			action:   &lockfile.RCONAction{Port: 25590, PasswordEnv: "GOK_TEST_RCON_PASSWORD"},
+			expected: &rconSettings{address: "127.0.0.1:25590", password: "from-env"},
+		},
+		{
+			name:     "no properties",
+			action:   &lockfile.RCONAction{},
+			errorMsg: "not found",
+		},
+		{
+			name:       "rcon disabled",
+			properties: "enable-rcon=false\nrcon.password=pw\n",
+			action:     &lockfile.RCONAction{},
+			errorMsg:   "rcon is not enabled",
+		},
+		{
+			name:       "no password",
+			properties: "enable-rcon=true\n",
+			action:     &lockfile.RCONAction{},
+			errorMsg:   "no rcon password set",
+		},
+		{
+			name:       "unset environment variable",
+			properties: "enable-rcon=true\nrcon.password=pw\n",
+			action:     &lockfile.RCONAction{PasswordEnv: "GOK_TEST_RCON_UNSET"},
+			errorMsg:   "GOK_TEST_RCON_UNSET is not set",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tc.properties != "" {
+				writeServerProperties(t, dir, tc.properties)
+			}
+			settings, err := resolveRCON(tc.action, dir)
+			if tc.errorMsg != "" {
+				assert.ErrorContains(t, err, tc.errorMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, settings)
+		})
+	}
+}
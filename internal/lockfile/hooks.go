@@ -0,0 +1,141 @@
+package lockfile
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sap-gg/gok/internal/glob"
+)
+
+// DefaultHookTimeout is used for hooks which don't declare a timeout.
+const DefaultHookTimeout = time.Minute
+
+// Hooks are commands which are run before and after changes are applied to a destination.
+type Hooks struct {
+	// PreApply hooks are run before any file of the destination is changed.
+	PreApply []*Hook `yaml:"preApply,omitempty" validate:"dive,required"`
+	// PostApply hooks are run after all changes were applied, e.g. to reload or restart the server.
+	PostApply []*Hook `yaml:"postApply,omitempty" validate:"dive,required"`
+}
+
+// IsEmpty returns true if no hooks are declared.
+func (h *Hooks) IsEmpty() bool {
+	return h == nil || len(h.PreApply) == 0 && len(h.PostApply) == 0
+}
+
+// Hook is a single command run by gok.
+type Hook struct {
+	// Name is a human-friendly name used in logs (optional, defaults to the command).
+	Name string `yaml:"name,omitempty"`
+
+	// Command is the executable and its arguments. It is not run through a shell,
+	// use e.g. ["sh", "-c", "..."] if shell features are needed.
+	Command []string `yaml:"command,omitempty" validate:"required_without=RCON,excluded_with=RCON"`
+
+	// RCON sends commands to the RCON interface of a Minecraft server instead of running a command.
+	RCON *RCONAction `yaml:"rcon,omitempty"`
+
+	// When limits the hook to applies changing at least one path matching any of these globs
+	// (relative to the working directory of the hook). The hook runs for any change if empty.
+	When []string `yaml:"when,omitempty"`
+
+	// Timeout is the maximum duration of the command (DefaultHookTimeout if not set).
+	Timeout time.Duration `yaml:"timeout,omitempty" validate:"min=0s"`
+
+	// OnFailure defines what happens if the command fails or times out (HookFail if not set).
+	OnFailure HookFailurePolicy `yaml:"onFailure,omitempty" validate:"omitempty,oneof=fail rollback ignore"`
+}
+
+// RCONAction is a sequence of commands sent to the RCON interface of a Minecraft server.
+// Unless set explicitly, the port and password are read from the server.properties file of the server.
+type RCONAction struct {
+	// Host is the address of the server (default: DefaultRCONHost).
+	Host string `yaml:"host,omitempty"`
+	// Port overrides the rcon.port of the server properties.
+	Port int `yaml:"port,omitempty" validate:"omitempty,min=1,max=65535"`
+	// PasswordEnv is the name of an environment variable containing the password,
+	// it overrides the rcon.password of the server properties.
+	PasswordEnv string `yaml:"passwordEnv,omitempty"`
+	// Properties is the path of the server properties, relative to the working directory of the hook
+	// (default: DefaultServerProperties).
+	Properties string `yaml:"properties,omitempty"`
+
+	// Commands are sent in order, without leading slash (e.g. "say Restarting in 60s").
+	Commands []string `yaml:"commands" validate:"required,min=1,dive,required"`
+}
+
+const (
+	// DefaultRCONHost is used for RCON actions which don't declare a host.
+	DefaultRCONHost = "127.0.0.1"
+	// DefaultServerProperties is the server properties file read by RCON actions.
+	DefaultServerProperties = "server.properties"
+)
+
+// Validate checks the globs of all hooks.
+func (h *Hooks) Validate() error {
+	if h == nil {
+		return nil
+	}
+	for _, hook := range slices.Concat(h.PreApply, h.PostApply) {
+		for _, pattern := range hook.When {
+			if err := glob.Validate(pattern); err != nil {
+				return fmt.Errorf("hook %q: invalid when glob %q: %w", hook.DisplayName(), pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// DisplayName returns the name of the hook, or its command if no name is set.
+func (h *Hook) DisplayName() string {
+	if h.Name != "" {
+		return h.Name
+	}
+	if h.RCON != nil {
+		return "rcon: " + strings.Join(h.RCON.Commands, "; ")
+	}
+	return strings.Join(h.Command, " ")
+}
+
+// Matches returns true if the hook should run for the changed paths (relative to its working directory).
+func (h *Hook) Matches(changed []string) bool {
+	if len(h.When) == 0 {
+		return true
+	}
+	for _, path := range changed {
+		if glob.MatchAny(h.When, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// EffectiveTimeout returns the timeout of the hook, or DefaultHookTimeout if none is set.
+func (h *Hook) EffectiveTimeout() time.Duration {
+	if h.Timeout > 0 {
+		return h.Timeout
+	}
+	return DefaultHookTimeout
+}
+
+// FailurePolicy returns the failure policy of the hook, or HookFail if none is set.
+func (h *Hook) FailurePolicy() HookFailurePolicy {
+	if h.OnFailure == "" {
+		return HookFail
+	}
+	return h.OnFailure
+}
+
+// HookFailurePolicy defines how a failing hook is handled.
+type HookFailurePolicy string
+
+const (
+	// HookFail aborts the apply. Changes which were already applied are kept.
+	HookFail HookFailurePolicy = "fail"
+	// HookRollback aborts the apply and rolls back all changes which were already applied.
+	HookRollback HookFailurePolicy = "rollback"
+	// HookIgnore only logs a warning and continues.
+	HookIgnore HookFailurePolicy = "ignore"
+)
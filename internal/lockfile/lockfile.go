@@ -1,15 +1,19 @@
 package lockfile
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"io/fs"
+	"maps"
 	"os"
+	"path"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,6 +21,7 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/sap-gg/gok/internal"
+	"github.com/sap-gg/gok/internal/merge"
 )
 
 type LockFiles map[string]*LockEntry
@@ -40,6 +45,33 @@ type LockFile struct {
 	Version     int       `yaml:"version"`
 	GeneratedAt time.Time `yaml:"generatedAt"`
 	Files       LockFiles `yaml:"files"`
+
+	// Seeds are files which are only created if absent and never managed afterward.
+	Seeds LockFiles `yaml:"seeds,omitempty"`
+
+	// ExclusiveDirs are directories which are exclusively managed by gok,
+	// i.e. untracked files inside them may be pruned.
+	ExclusiveDirs []string `yaml:"exclusiveDirs,omitempty"`
+
+	// Dirs are all directories containing managed files (and declared empty directories), sorted.
+	// They are removed from a destination once they are no longer managed and empty.
+	Dirs []string `yaml:"dirs,omitempty"`
+
+	// Targets maps the IDs of all rendered targets to their metadata.
+	Targets map[string]*TargetEntry `yaml:"targets,omitempty"`
+
+	// Signature is the HMAC of the preceding content of a signed lock file, see Sign.
+	// It's always encoded last and never by Marshal.
+	Signature string `yaml:"signature,omitempty"`
+}
+
+// TargetEntry contains metadata about a single rendered target.
+type TargetEntry struct {
+	// Output is the slash-separated output directory of the target, relative to the lock file.
+	Output string `yaml:"output"`
+
+	// Hooks are run when the output of the target is applied to a destination.
+	Hooks *Hooks `yaml:"hooks,omitempty"`
 }
 
 // LockEntry contains metadata about a single file.
@@ -47,9 +79,151 @@ type LockEntry struct {
 	Hash  string    `yaml:"hash"`
 	MTime time.Time `yaml:"mtime"`
 	Size  int64     `yaml:"size"`
+	// DataHash is the hash of the normalized data of structured files (YAML, JSON, TOML, properties).
+	// It's equal for files which only differ in formatting, empty for other files.
+	DataHash string `yaml:"dataHash,omitempty"`
+
+	// Type is the type of the entry, empty for regular files.
+	Type EntryType `yaml:"type,omitempty"`
+	// Mode contains the permission bits in octal notation, e.g. "0755".
+	Mode string `yaml:"mode,omitempty"`
+	// Link is the target of a symlink (only set for TypeSymlink).
+	Link string `yaml:"link,omitempty"`
+	// UID is the numeric owner applied on apply, nil if the owner is not managed.
+	UID *int `yaml:"uid,omitempty"`
+	// GID is the numeric group applied on apply, nil if the group is not managed.
+	GID *int `yaml:"gid,omitempty"`
+
+	// Policy defines how conflicts (manual changes) of this file are handled.
+	Policy ConflictPolicy `yaml:"policy,omitempty"`
+	// BaseHash is the hash of the desired content a file was three-way merged with, only set in destinations
+	// for files whose merged content was kept. Hash is the hash of the merged content then.
+	BaseHash string `yaml:"baseHash,omitempty"`
+
+	// Source records how the file was rendered, nil if it's unknown (e.g. lock files of older versions).
+	Source *FileSource `yaml:"source,omitempty"`
+}
+
+// HasOwnership returns true if the owner or group of the entry is managed.
+func (e *LockEntry) HasOwnership() bool {
+	return e.UID != nil || e.GID != nil
 }
 
-func Create(ctx context.Context, rootDir string) error {
+// Ownership returns the managed uid and gid of the entry, -1 for values which are not managed.
+func (e *LockEntry) Ownership() (uid, gid int) {
+	uid, gid = -1, -1
+	if e.UID != nil {
+		uid = *e.UID
+	}
+	if e.GID != nil {
+		gid = *e.GID
+	}
+	return uid, gid
+}
+
+// FormatOwnership returns the ownership in "uid:gid" notation, unmanaged values are shown as "-".
+func (e *LockEntry) FormatOwnership() string {
+	uid, gid := "-", "-"
+	if e.UID != nil {
+		uid = strconv.Itoa(*e.UID)
+	}
+	if e.GID != nil {
+		gid = strconv.Itoa(*e.GID)
+	}
+	return uid + ":" + gid
+}
+
+// EntryType is the type of lock entry.
+type EntryType string
+
+const (
+	// TypeFile is a regular file.
+	TypeFile EntryType = ""
+	// TypeSymlink is a symbolic link, its hash is computed from the link target.
+	TypeSymlink EntryType = "symlink"
+	// TypeDir is an (empty) directory, all directories share the same hash.
+	TypeDir EntryType = "dir"
+)
+
+// DesiredHash returns the hash of the desired content the entry was applied from.
+// It differs from Hash for files whose merged content was kept, see BaseHash.
+func (e *LockEntry) DesiredHash() string {
+	if e.BaseHash != "" {
+		return e.BaseHash
+	}
+	return e.Hash
+}
+
+// FileMode returns the permission bits of the entry, or false if no mode was recorded.
+func (e *LockEntry) FileMode() (fs.FileMode, bool) {
+	if e.Mode == "" {
+		return 0, false
+	}
+	mode, err := strconv.ParseUint(e.Mode, 8, 32)
+	if err != nil {
+		return 0, false
+	}
+	return fs.FileMode(mode).Perm(), true
+}
+
+// FormatMode formats permission bits in octal notation as used in lock entries.
+func FormatMode(mode fs.FileMode) string {
+	return fmt.Sprintf("%04o", mode.Perm())
+}
+
+// ConflictPolicy defines how a conflicting file is handled during apply.
+type ConflictPolicy string
+
+const (
+	// PolicyFail reports the conflict and aborts the apply (unless --force is used).
+	PolicyFail ConflictPolicy = "fail"
+	// PolicyKeepLocal keeps the manually changed file as-is.
+	PolicyKeepLocal ConflictPolicy = "keep-local"
+	// PolicyTakeDesired overwrites the manually changed file with the desired file.
+	PolicyTakeDesired ConflictPolicy = "take-desired"
+	// PolicyMerge attempts a key-level three-way merge of the file.
+	PolicyMerge ConflictPolicy = "merge"
+	// PolicyBackupAndReplace saves the manually changed file and replaces it with the desired file.
+	PolicyBackupAndReplace ConflictPolicy = "backup-and-replace"
+)
+
+// ManagedDirs returns the managed directories of the lock file. For lock files written without them,
+// they are derived from the files.
+func (l *LockFile) ManagedDirs() []string {
+	if l.Dirs != nil {
+		return l.Dirs
+	}
+	return managedDirs(l.Files)
+}
+
+// managedDirs returns the sorted directories containing the files, including empty directory entries.
+func managedDirs(files LockFiles) []string {
+	dirs := make(map[string]struct{})
+	for p, entry := range files {
+		if entry.Type == TypeDir {
+			dirs[p] = struct{}{}
+		}
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			if _, ok := dirs[dir]; ok {
+				break
+			}
+			dirs[dir] = struct{}{}
+		}
+	}
+	if len(dirs) == 0 {
+		return nil
+	}
+	return slices.Sorted(maps.Keys(dirs))
+}
+
+// Annotator adds metadata to a lock file before it is written, e.g. information only known during rendering.
+type Annotator interface {
+	Annotate(lock *LockFile) error
+}
+
+// Create computes the lock file for all files in rootDir and writes it to rootDir.
+// The given annotators are called in order after all files have been recorded.
+func Create(ctx context.Context, rootDir string, annotators ...Annotator) error {
 	log.Info().
 		Str("root", rootDir).
 		Msg("creating lock file")
@@ -65,31 +239,32 @@ func Create(ctx context.Context, rootDir string) error {
 			return err
 		}
 
-		// skip directories and the lock file itself
-		if dir.IsDir() || dir.Name() == internal.LockFileName {
+		// skip the root and the lock file itself
+		if path == rootDir || dir.Name() == internal.LockFileName {
 			return nil
 		}
 
-		relPath, err := filepath.Rel(rootDir, path)
-		if err != nil {
-			return fmt.Errorf("determining relative path: %w", err)
+		// directories are only recorded if they are empty, otherwise they are implied by their files
+		if dir.IsDir() {
+			entries, err := os.ReadDir(path)
+			if err != nil {
+				return fmt.Errorf("reading directory %q: %w", path, err)
+			}
+			if len(entries) > 0 {
+				return nil
+			}
 		}
 
-		info, err := dir.Info()
+		relPath, err := filepath.Rel(rootDir, path)
 		if err != nil {
-			return fmt.Errorf("getting file info f or %q: %w", path, err)
+			return fmt.Errorf("determining relative path: %w", err)
 		}
 
-		hash, err := FileSHA256(path)
+		entry, err := EntryFor(path)
 		if err != nil {
-			return fmt.Errorf("computing hash for %q: %w", path, err)
-		}
-
-		lock.Files[filepath.ToSlash(relPath)] = &LockEntry{
-			Hash:  hash,
-			MTime: info.ModTime().UTC(),
-			Size:  info.Size(),
+			return err
 		}
+		lock.Files[filepath.ToSlash(relPath)] = entry
 
 		return nil
 	})
@@ -97,25 +272,48 @@ func Create(ctx context.Context, rootDir string) error {
 	if err != nil {
 		return fmt.Errorf("walking root directory: %w", err)
 	}
+	lock.Dirs = managedDirs(lock.Files)
 
-	lockPath := filepath.Join(rootDir, internal.LockFileName)
-	f, err := os.Create(lockPath)
-	if err != nil {
-		return fmt.Errorf("creating lock file: %w", err)
+	for _, annotator := range annotators {
+		if err := annotator.Annotate(&lock); err != nil {
+			return fmt.Errorf("annotating lock file: %w", err)
+		}
 	}
-	defer f.Close()
 
-	if err := internal.NewYAMLEncoder(f).EncodeContext(ctx, &lock); err != nil {
-		return fmt.Errorf("encoding lock file: %w", err)
+	if err := Write(ctx, rootDir, &lock); err != nil {
+		return err
 	}
 
 	log.Info().
-		Str("path", lockPath).
+		Str("path", filepath.Join(rootDir, internal.LockFileName)).
 		Int("files", len(lock.Files)).
 		Msg("lock file created successfully")
 	return nil
 }
 
+// Write writes the lock file to the specified root directory.
+func Write(ctx context.Context, rootDir string, lock *LockFile) error {
+	content, err := Marshal(ctx, lock)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(rootDir, internal.LockFileName), content, 0o644); err != nil {
+		return fmt.Errorf("writing lock file: %w", err)
+	}
+	return nil
+}
+
+// Marshal returns the encoded lock file, without signature.
+func Marshal(ctx context.Context, lock *LockFile) ([]byte, error) {
+	unsigned := *lock
+	unsigned.Signature = ""
+	var buf bytes.Buffer
+	if err := internal.NewYAMLEncoder(&buf).EncodeContext(ctx, &unsigned); err != nil {
+		return nil, fmt.Errorf("encoding lock file: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
 // Read reads and parses the lock file from the specified root directory.
 func Read(rootDir string) (*LockFile, error) {
 	lockPath := filepath.Join(rootDir, internal.LockFileName)
@@ -140,10 +338,150 @@ func Read(rootDir string) (*LockFile, error) {
 	if lock.Version != internal.LockFileVersion {
 		return nil, fmt.Errorf("unsupported lock file version: %d", lock.Version)
 	}
+	// the paths are joined onto the destination, a crafted lock file must not reach outside of it
+	if err := lock.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid lock file: %w", err)
+	}
 
 	return &lock, nil
 }
 
+// Validate checks that all paths of the lock file are local, i.e. they can't refer to anything outside the
+// directory of the lock file, and that no path is nested inside a managed file or symlink.
+func (l *LockFile) Validate() error {
+	for _, files := range []LockFiles{l.Files, l.Seeds} {
+		for p := range files {
+			if err := validatePath(p); err != nil {
+				return err
+			}
+			for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+				for _, parents := range []LockFiles{l.Files, l.Seeds} {
+					if parent, ok := parents[dir]; ok && parent.Type != TypeDir {
+						return fmt.Errorf("path %q is inside the managed file %q", p, dir)
+					}
+				}
+			}
+		}
+	}
+	for _, dir := range slices.Concat(l.Dirs, l.ExclusiveDirs) {
+		if err := validatePath(dir); err != nil {
+			return err
+		}
+	}
+	for id, target := range l.Targets {
+		if target.Output == "." {
+			continue
+		}
+		if err := validatePath(target.Output); err != nil {
+			return fmt.Errorf("output of target %q: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// validatePath returns an error if p is not a clean, slash-separated path inside the directory of the lock file.
+func validatePath(p string) error {
+	if p == "" || p == "." || path.Clean(p) != p || !filepath.IsLocal(filepath.FromSlash(p)) {
+		return fmt.Errorf("path %q is not a local path", p)
+	}
+	return nil
+}
+
+// EntryFor computes the lock entry for the regular file, symlink or directory at path.
+func EntryFor(path string) (*LockEntry, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, fmt.Errorf("getting file info for %q: %w", path, err)
+	}
+
+	entry := &LockEntry{
+		MTime: info.ModTime().UTC(),
+		Size:  info.Size(),
+		Mode:  FormatMode(info.Mode()),
+	}
+
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		entry.Type = TypeSymlink
+		if entry.Link, err = os.Readlink(path); err != nil {
+			return nil, fmt.Errorf("reading symlink %q: %w", path, err)
+		}
+		entry.Hash = symlinkHash(entry.Link)
+		entry.Mode = "" // symlink permissions are meaningless
+	case info.IsDir():
+		entry.Type = TypeDir
+		entry.Hash = dirHash
+		entry.Size = 0
+	case info.Mode().IsRegular():
+		if entry.Hash, err = FileSHA256(path); err != nil {
+			return nil, fmt.Errorf("computing hash for %q: %w", path, err)
+		}
+		if entry.DataHash, err = DataHash(path); err != nil {
+			return nil, fmt.Errorf("computing data hash for %q: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported file type %s of %q", info.Mode().Type(), path)
+	}
+
+	return entry, nil
+}
+
+// PathHash computes the hash of the regular file, symlink or directory at path the same way as EntryFor.
+// Errors wrap fs.ErrNotExist if nothing exists at path.
+func PathHash(path string) (string, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		link, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		return symlinkHash(link), nil
+	case info.IsDir():
+		return dirHash, nil
+	default:
+		return FileSHA256(path)
+	}
+}
+
+// DataHash computes the hash of the normalized data of the structured file at path, see merge.Normalize.
+// It returns an empty hash for files of other formats and for files which can't be parsed.
+func DataHash(path string) (string, error) {
+	if _, ok := merge.FormatFor(path); !ok {
+		return "", nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return ContentDataHash(path, content), nil
+}
+
+// ContentDataHash computes the hash of the normalized data of the structured content of the file at path
+// the same way as DataHash.
+func ContentDataHash(path string, content []byte) string {
+	format, ok := merge.FormatFor(path)
+	if !ok {
+		return ""
+	}
+	normalized, err := merge.Normalize(format, content)
+	if err != nil {
+		log.Debug().Err(err).Str("path", path).Msgf("not a valid %s file, skipping data hash", format.Name())
+		return ""
+	}
+	return SHA256(normalized)
+}
+
+// dirHash is the hash of all directory entries, directories only have to exist.
+var dirHash = SHA256([]byte("dir:"))
+
+func symlinkHash(link string) string {
+	return SHA256([]byte("symlink:" + link))
+}
+
 // FileSHA256 computes the SHA256 hash of the file at the specified path and returns it as a hex string.
 func FileSHA256(path string) (string, error) {
 	f, err := os.Open(path)
@@ -157,3 +495,9 @@ func FileSHA256(path string) (string, error) {
 	}
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
+
+// SHA256 computes the SHA256 hash of the given content and returns it as a hex string.
+func SHA256(content []byte) string {
+	h := sha256.Sum256(content)
+	return hex.EncodeToString(h[:])
+}
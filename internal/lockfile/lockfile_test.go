@@ -0,0 +1,110 @@
+package lockfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockFileManagedDirs(t *testing.T) {
+	lock := &LockFile{Files: LockFiles{
+		"server.properties":         {Hash: "a"},
+		"plugins/Foo/config.yml":    {Hash: "b"},
+		"plugins/Foo/lang/en.yml":   {Hash: "c"},
+		"plugins/Bar.jar":           {Hash: "d"},
+		"logs":                      {Type: TypeDir},
+		"world/datapacks/.keep/dir": {Type: TypeDir},
+	}}
+	expected := []string{
+		"logs",
+		"plugins",
+		"plugins/Foo",
+		"plugins/Foo/lang",
+		"world",
+		"world/datapacks",
+		"world/datapacks/.keep",
+		"world/datapacks/.keep/dir",
+	}
+	assert.Equal(t, expected, lock.ManagedDirs(), "lock files without dirs derive them from their files")
+
+	lock.Dirs = []string{"plugins"}
+	assert.Equal(t, []string{"plugins"}, lock.ManagedDirs())
+
+	assert.Nil(t, (&LockFile{Files: LockFiles{"ops.json": {Hash: "a"}}}).ManagedDirs())
+}
+
+func TestDataHash(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		return path
+	}
+
+	a, err := EntryFor(write("a.yml", "motd: hi\nport: 25565\n"))
+	require.NoError(t, err)
+	b, err := EntryFor(write("b.yml", "# reformatted\nport: 25565\nmotd: 'hi'\n"))
+	require.NoError(t, err)
+	assert.NotEqual(t, a.Hash, b.Hash)
+	assert.NotEmpty(t, a.DataHash)
+	assert.Equal(t, a.DataHash, b.DataHash, "formatting must not change the data hash")
+
+	c, err := EntryFor(write("c.yml", "motd: hello\nport: 25565\n"))
+	require.NoError(t, err)
+	assert.NotEqual(t, a.DataHash, c.DataHash)
+
+	for _, name := range []string{"plugin.jar", "broken.json"} {
+		entry, err := EntryFor(write(name, "{"))
+		require.NoError(t, err)
+		assert.Empty(t, entry.DataHash, "%s has no data hash", name)
+	}
+
+	_, err = DataHash(filepath.Join(dir, "missing.yml"))
+	assert.Error(t, err)
+}
+
+func TestLockFileValidate(t *testing.T) {
+	testCases := []struct {
+		name  string
+		lock  *LockFile
+		valid bool
+	}{
+		{name: "local paths", lock: &LockFile{
+			Files:         LockFiles{"plugins/Foo/config.yml": {}, "logs": {Type: TypeDir}, "logs/.keep": {}},
+			ExclusiveDirs: []string{"plugins"},
+			Targets:       map[string]*TargetEntry{"lobby": {Output: "."}, "survival": {Output: "survival"}},
+		}, valid: true},
+		{name: "parent file", lock: &LockFile{Files: LockFiles{"../../etc/cron.d/x": {}}}},
+		{name: "parent seed", lock: &LockFile{Seeds: LockFiles{"plugins/../../x": {}}}},
+		{name: "absolute file", lock: &LockFile{Files: LockFiles{"/etc/cron.d/x": {}}}},
+		{name: "empty file", lock: &LockFile{Files: LockFiles{"": {}}}},
+		{name: "root file", lock: &LockFile{Files: LockFiles{".": {}}}},
+		{name: "file inside symlink", lock: &LockFile{Files: LockFiles{
+			"plugins":     {Type: TypeSymlink, Link: "/etc"},
+			"plugins/x.d": {},
+		}}},
+		{name: "parent exclusive dir", lock: &LockFile{ExclusiveDirs: []string{".."}}},
+		{name: "absolute dir", lock: &LockFile{Dirs: []string{"/var"}}},
+		{name: "parent target output", lock: &LockFile{Targets: map[string]*TargetEntry{"lobby": {Output: "../lobby"}}}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.valid {
+				assert.NoError(t, tc.lock.Validate())
+			} else {
+				assert.Error(t, tc.lock.Validate())
+			}
+		})
+	}
+
+	t.Run("read", func(t *testing.T) {
+		dir := t.TempDir()
+		content := "version: 1\nfiles:\n  ../../etc/cron.d/x:\n    hash: a\n"
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "gok-lock.yaml"), []byte(content), 0o644))
+		_, err := Read(dir)
+		assert.ErrorContains(t, err, "not a local path")
+	})
+}
@@ -0,0 +1,53 @@
+package lockfile
+
+import (
+	"maps"
+
+	"github.com/sap-gg/gok/internal/glob"
+)
+
+// Overlay returns the lock file after applying only the paths of desired which are selected by the filter:
+// the entries of selected paths are taken from desired (or dropped if desired doesn't contain them),
+// all other entries and the metadata are kept from l. If l is empty (no lock file yet),
+// the metadata of desired is used instead.
+func (l *LockFile) Overlay(desired *LockFile, filter *glob.Filter) *LockFile {
+	base := l
+	if l.Version == 0 {
+		base = &LockFile{
+			Version:       desired.Version,
+			GeneratedAt:   desired.GeneratedAt,
+			ExclusiveDirs: desired.ExclusiveDirs,
+			Targets:       desired.Targets,
+		}
+	}
+
+	result := &LockFile{
+		Version:       base.Version,
+		GeneratedAt:   base.GeneratedAt,
+		Files:         overlayFiles(l.Files, desired.Files, filter),
+		Seeds:         overlayFiles(l.Seeds, desired.Seeds, filter),
+		ExclusiveDirs: base.ExclusiveDirs,
+		Targets:       base.Targets,
+	}
+	if len(result.Seeds) == 0 {
+		result.Seeds = nil
+	}
+	result.Dirs = managedDirs(result.Files)
+	return result
+}
+
+func overlayFiles(current, desired LockFiles, filter *glob.Filter) LockFiles {
+	result := make(LockFiles, len(current))
+	maps.Copy(result, current)
+	for p := range current {
+		if filter.Match(p) {
+			delete(result, p)
+		}
+	}
+	for p, entry := range desired {
+		if filter.Match(p) {
+			result[p] = entry
+		}
+	}
+	return result
+}
@@ -0,0 +1,50 @@
+package lockfile
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sap-gg/gok/internal/glob"
+)
+
+func TestLockFileOverlay(t *testing.T) {
+	applied := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	current := &LockFile{
+		Version:     1,
+		GeneratedAt: applied,
+		Files: LockFiles{
+			"server.properties":      {Hash: "old-props"},
+			"plugins/Foo/config.yml": {Hash: "old-foo"},
+			"plugins/Old.jar":        {Hash: "old-jar"},
+		},
+		ExclusiveDirs: []string{"plugins"},
+	}
+	desired := &LockFile{
+		Version:     1,
+		GeneratedAt: applied.Add(time.Hour),
+		Files: LockFiles{
+			"server.properties":      {Hash: "new-props"},
+			"plugins/Foo/config.yml": {Hash: "new-foo"},
+			"plugins/Bar/config.yml": {Hash: "new-bar"},
+		},
+		Seeds: LockFiles{"ops.json": {Hash: "seed"}},
+	}
+
+	overlay := current.Overlay(desired, &glob.Filter{Include: []string{"plugins/"}})
+	assert.Equal(t, LockFiles{
+		"server.properties":      {Hash: "old-props"},
+		"plugins/Foo/config.yml": {Hash: "new-foo"},
+		"plugins/Bar/config.yml": {Hash: "new-bar"},
+	}, overlay.Files, "removed paths must only be dropped if they are selected")
+	assert.Nil(t, overlay.Seeds, "seeds which are not selected must not be added")
+	assert.Equal(t, applied, overlay.GeneratedAt)
+	assert.Equal(t, []string{"plugins"}, overlay.ExclusiveDirs)
+	assert.Equal(t, "old-props", current.Files["server.properties"].Hash, "the current lock must not be modified")
+
+	overlay = (&LockFile{Files: LockFiles{}}).Overlay(desired, &glob.Filter{Exclude: []string{"plugins/"}})
+	assert.Equal(t, LockFiles{"server.properties": {Hash: "new-props"}}, overlay.Files)
+	assert.Equal(t, LockFiles{"ops.json": {Hash: "seed"}}, overlay.Seeds)
+	assert.Equal(t, desired.GeneratedAt, overlay.GeneratedAt, "without a lock file, the metadata is taken from desired")
+}
@@ -0,0 +1,91 @@
+package lockfile
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sap-gg/gok/internal"
+)
+
+const (
+	// signatureLine starts the last line of a signed lock file
+	signatureLine = "\nsignature: "
+	// signatureAlgorithm prefixes the signature, so other algorithms can be added later
+	signatureAlgorithm = "hmac-sha256:"
+)
+
+var (
+	// ErrUnsigned is returned by VerifySignature if the lock file has no signature.
+	ErrUnsigned = errors.New("lock file is not signed")
+	// ErrTampered is returned by VerifySignature if the signature doesn't match the content of the lock file.
+	ErrTampered = errors.New("lock file signature doesn't match, it was modified outside of gok")
+)
+
+// Sign appends the signature of the encoded (unsigned) lock file content, an HMAC-SHA256 using key.
+// The signature covers the exact bytes of the content, so any change to the lock file invalidates it.
+func Sign(content, key []byte) []byte {
+	if !bytes.HasSuffix(content, []byte("\n")) {
+		content = append(content, '\n')
+	}
+	signed := bytes.Clone(content)
+	signed = append(signed, signatureLine[1:]...)
+	signed = append(signed, signatureAlgorithm+signature(content, key)+"\n"...)
+	return signed
+}
+
+// VerifySignature checks the signature of the encoded lock file content using key.
+// It returns ErrUnsigned if the content has no signature and ErrTampered if it doesn't match.
+func VerifySignature(content, key []byte) error {
+	unsigned, sig, ok := splitSignature(content)
+	if !ok {
+		return ErrUnsigned
+	}
+	hexSig, ok := strings.CutPrefix(sig, signatureAlgorithm)
+	if !ok {
+		return fmt.Errorf("%w: unsupported signature %q", ErrTampered, sig)
+	}
+	if !hmac.Equal([]byte(hexSig), []byte(signature(unsigned, key))) {
+		return ErrTampered
+	}
+	return nil
+}
+
+// IsSigned returns true if the encoded lock file content has a signature, without verifying it.
+func IsSigned(content []byte) bool {
+	_, _, ok := splitSignature(content)
+	return ok
+}
+
+// ReadEncoded returns the encoded lock file of the root directory, nil if it doesn't exist.
+func ReadEncoded(rootDir string) ([]byte, error) {
+	content, err := os.ReadFile(filepath.Join(rootDir, internal.LockFileName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading lock file: %w", err)
+	}
+	return content, nil
+}
+
+// splitSignature splits the content into the signed content and the value of the signature line.
+func splitSignature(content []byte) ([]byte, string, bool) {
+	i := bytes.LastIndex(content, []byte(signatureLine))
+	if i < 0 {
+		return nil, "", false
+	}
+	return content[:i+1], strings.TrimSpace(string(content[i+len(signatureLine):])), true
+}
+
+func signature(content, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(content)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,41 @@
+package lockfile
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sap-gg/gok/internal"
+)
+
+func TestSignature(t *testing.T) {
+	key := []byte("secret")
+	lock := &LockFile{
+		Version: internal.LockFileVersion,
+		Files:   LockFiles{"server.properties": {Hash: "abc", Size: 3}},
+	}
+	content, err := Marshal(context.Background(), lock)
+	require.NoError(t, err)
+	assert.False(t, IsSigned(content))
+	assert.ErrorIs(t, VerifySignature(content, key), ErrUnsigned)
+
+	signed := Sign(content, key)
+	assert.True(t, IsSigned(signed))
+	require.NoError(t, VerifySignature(signed, key))
+
+	var decoded LockFile
+	require.NoError(t, internal.NewYAMLDecoder(bytes.NewReader(signed)).Decode(&decoded))
+	assert.NotEmpty(t, decoded.Signature)
+	remarshaled, err := Marshal(context.Background(), &decoded)
+	require.NoError(t, err)
+	assert.Equal(t, content, remarshaled, "the signature must not be encoded by Marshal")
+
+	assert.ErrorIs(t, VerifySignature(signed, []byte("other")), ErrTampered)
+	tampered := bytes.Replace(signed, []byte("hash: abc"), []byte("hash: abd"), 1)
+	assert.ErrorIs(t, VerifySignature(tampered, key), ErrTampered)
+	appended := append(bytes.Clone(signed), "seeds: {}\n"...)
+	assert.ErrorIs(t, VerifySignature(appended, key), ErrTampered)
+}
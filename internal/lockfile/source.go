@@ -0,0 +1,61 @@
+package lockfile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StrategyArtifact is the strategy of layers which declared an artifact spec instead of the file itself.
+const StrategyArtifact = "artifact"
+
+// FileSource attributes a rendered file to the target and template layers which produced it.
+type FileSource struct {
+	// Target is the ID of the target the file belongs to.
+	Target string `yaml:"target" json:"target"`
+	// Layers are the template layers which wrote or patched the file, in order of application.
+	// Layers before a deletion of the file are not included.
+	Layers []*SourceLayer `yaml:"layers,omitempty" json:"layers,omitempty"`
+	// Artifact is true if the content was downloaded from an artifact spec.
+	Artifact bool `yaml:"artifact,omitempty" json:"artifact,omitempty"`
+}
+
+// SourceLayer is a single template layer of a FileSource.
+type SourceLayer struct {
+	// Template is the path of the template as declared in the manifest.
+	Template string `yaml:"template" json:"template"`
+	// Strategy is the name of the file strategy used to apply the file, e.g. copy-only or yaml-patch,
+	// or StrategyArtifact if the template declared an artifact spec.
+	Strategy string `yaml:"strategy" json:"strategy"`
+	// Templated is true if the file was rendered as template (using the template infix).
+	Templated bool `yaml:"templated,omitempty" json:"templated,omitempty"`
+}
+
+// String returns a single-line summary, e.g. "target survival: templates/base (copy-only), templates/survival (yaml-patch, templated)".
+func (s *FileSource) String() string {
+	layers := make([]string, len(s.Layers))
+	for i, layer := range s.Layers {
+		layers[i] = layer.String()
+	}
+	summary := "target " + s.Target
+	if len(layers) > 0 {
+		summary += ": " + strings.Join(layers, ", ")
+	}
+	return summary
+}
+
+// String returns a summary of the layer, e.g. "templates/survival (yaml-patch, templated)".
+func (l *SourceLayer) String() string {
+	details := l.Strategy
+	if l.Templated {
+		details += ", templated"
+	}
+	return fmt.Sprintf("%s (%s)", l.Template, details)
+}
+
+// Equal returns true if both sources are the same, nil sources are only equal to nil.
+func (s *FileSource) Equal(other *FileSource) bool {
+	if s == nil || other == nil {
+		return s == other
+	}
+	return s.String() == other.String() && s.Artifact == other.Artifact
+}
@@ -0,0 +1,90 @@
+package lockfile
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// ForTarget returns the part of the lock file belonging to the target with the given ID, with all paths
+// relative to the output directory of the target. Files of other targets whose output is nested inside
+// the output of the target are not included. The output directory (slash-separated) is returned as well.
+func (l *LockFile) ForTarget(id string) (*LockFile, string, error) {
+	if len(l.Targets) == 0 {
+		return nil, "", fmt.Errorf("the lock file contains no targets, re-render the artifact with a newer gok version")
+	}
+	target, ok := l.Targets[id]
+	if !ok {
+		ids := make([]string, 0, len(l.Targets))
+		for targetID := range l.Targets {
+			ids = append(ids, targetID)
+		}
+		slices.Sort(ids)
+		return nil, "", fmt.Errorf("target %q not found in lock file (available: %s)", id, strings.Join(ids, ", "))
+	}
+
+	scoped := &LockFile{
+		Version:     l.Version,
+		GeneratedAt: l.GeneratedAt,
+		Files:       l.targetFiles(id, target.Output, l.Files),
+		Seeds:       l.targetFiles(id, target.Output, l.Seeds),
+		Targets:     map[string]*TargetEntry{id: {Output: ".", Hooks: target.Hooks}},
+	}
+	if len(scoped.Seeds) == 0 {
+		scoped.Seeds = nil
+	}
+	scoped.Dirs = managedDirs(scoped.Files)
+	for _, dir := range l.ExclusiveDirs {
+		if rel, ok := l.targetRelative(id, target.Output, dir); ok {
+			scoped.ExclusiveDirs = append(scoped.ExclusiveDirs, rel)
+		}
+	}
+	return scoped, target.Output, nil
+}
+
+func (l *LockFile) targetFiles(id, output string, files LockFiles) LockFiles {
+	scoped := make(LockFiles)
+	for p, entry := range files {
+		if rel, ok := l.targetRelative(id, output, p); ok {
+			scoped[rel] = entry
+		}
+	}
+	return scoped
+}
+
+// TargetPath returns the slash-separated path p (relative to the lock file) relative to the output of the target
+// with the given ID, or false if p is not part of the target (including paths of nested targets).
+func (l *LockFile) TargetPath(id, p string) (string, bool) {
+	target, ok := l.Targets[id]
+	if !ok {
+		return "", false
+	}
+	return l.targetRelative(id, target.Output, p)
+}
+
+// targetRelative returns p relative to the output of the target, or false if p belongs to another target.
+func (l *LockFile) targetRelative(id, output, p string) (string, bool) {
+	rel, ok := relativeTo(output, p)
+	if !ok {
+		return "", false
+	}
+	for otherID, other := range l.Targets {
+		if otherID == id || len(other.Output) <= len(output) {
+			continue
+		}
+		if _, nested := relativeTo(other.Output, p); nested {
+			return "", false
+		}
+	}
+	return rel, true
+}
+
+// relativeTo returns the slash-separated path p relative to dir, or false if p is not inside dir.
+func relativeTo(dir, p string) (string, bool) {
+	dir = path.Clean(dir)
+	if dir == "." {
+		return p, true
+	}
+	return strings.CutPrefix(p, dir+"/")
+}
@@ -0,0 +1,87 @@
+package lockfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockFileForTarget(t *testing.T) {
+	hooks := &Hooks{PostApply: []*Hook{{Command: []string{"systemctl", "reload", "survival"}}}}
+	lock := &LockFile{
+		Version: 1,
+		Files: LockFiles{
+			"survival/server.properties":         {Hash: "a"},
+			"survival/plugins/Foo.jar":           {Hash: "b"},
+			"survival/minigames/server.jar":      {Hash: "c"},
+			"proxy/velocity.toml":                {Hash: "d"},
+			"survival-staging/server.properties": {Hash: "e"},
+		},
+		Seeds: LockFiles{
+			"survival/permissions.yml": {Hash: "f"},
+		},
+		ExclusiveDirs: []string{"proxy/plugins", "survival/plugins"},
+		Targets: map[string]*TargetEntry{
+			"survival":         {Output: "survival", Hooks: hooks},
+			"minigames":        {Output: "survival/minigames"},
+			"proxy":            {Output: "proxy"},
+			"survival-staging": {Output: "survival-staging"},
+		},
+	}
+
+	scoped, output, err := lock.ForTarget("survival")
+	require.NoError(t, err)
+	assert.Equal(t, "survival", output)
+	assert.Equal(t, LockFiles{
+		"server.properties": {Hash: "a"},
+		"plugins/Foo.jar":   {Hash: "b"},
+	}, scoped.Files, "files of nested and sibling targets must not be included")
+	assert.Equal(t, LockFiles{"permissions.yml": {Hash: "f"}}, scoped.Seeds)
+	assert.Equal(t, []string{"plugins"}, scoped.ExclusiveDirs)
+	assert.Equal(t, []string{"plugins"}, scoped.Dirs, "the output directory itself is not a managed directory")
+	assert.Equal(t, map[string]*TargetEntry{"survival": {Output: ".", Hooks: hooks}}, scoped.Targets,
+		"hooks must be kept")
+
+	scoped, _, err = lock.ForTarget("minigames")
+	require.NoError(t, err)
+	assert.Equal(t, LockFiles{"server.jar": {Hash: "c"}}, scoped.Files)
+	assert.Nil(t, scoped.Seeds)
+
+	_, _, err = lock.ForTarget("lobby")
+	assert.ErrorContains(t, err, "available: minigames, proxy, survival, survival-staging")
+
+	_, _, err = (&LockFile{Files: LockFiles{}}).ForTarget("survival")
+	assert.Error(t, err)
+}
+
+func TestLockFileTargetPath(t *testing.T) {
+	lock := &LockFile{
+		Targets: map[string]*TargetEntry{
+			"survival":  {Output: "survival"},
+			"minigames": {Output: "survival/minigames"},
+			"root":      {Output: "."},
+		},
+	}
+
+	testCases := []struct {
+		target   string
+		path     string
+		expected string
+		ok       bool
+	}{
+		{target: "survival", path: "survival/server.properties", expected: "server.properties", ok: true},
+		{target: "survival", path: "survival/minigames/server.jar", ok: false},
+		{target: "minigames", path: "survival/minigames/server.jar", expected: "server.jar", ok: true},
+		{target: "survival", path: "proxy/velocity.toml", ok: false},
+		{target: "root", path: "proxy/velocity.toml", expected: "proxy/velocity.toml", ok: true},
+		{target: "lobby", path: "lobby/server.properties", ok: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.target+":"+tc.path, func(t *testing.T) {
+			rel, ok := lock.TargetPath(tc.target, tc.path)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.expected, rel)
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package merge
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/magiconair/properties"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Format decodes a structured file format into a generic key-value map and patches changed keys into its content.
+type Format interface {
+	// Name returns a human-friendly format name.
+	Name() string
+
+	// Decode parses the content into a map.
+	Decode(content []byte) (map[string]any, error)
+
+	// Patch writes the changes from the decoded data of the content (local) to merged into the content.
+	// Only changed keys are written, the formatting and comments of the rest of the content are kept.
+	// An error is returned if the content can't be patched without changing anything else.
+	Patch(content []byte, local, merged any) ([]byte, error)
+}
+
+var formatsByExtension = map[string]Format{
+	".yml":        yamlFormat{},
+	".yaml":       yamlFormat{},
+	".json":       jsonFormat{},
+	".toml":       tomlFormat{},
+	".properties": propertiesFormat{},
+}
+
+// FormatFor returns the structured format for the given filename based on its extension.
+func FormatFor(filename string) (Format, bool) {
+	f, ok := formatsByExtension[strings.ToLower(filepath.Ext(filename))]
+	return f, ok
+}
+
+// decodeDocument parses the content like Format.Decode, but JSON and YAML documents don't need to be mappings
+// (e.g. the arrays of ops.json).
+func decodeDocument(format Format, content []byte) (any, error) {
+	var data any
+	switch format.(type) {
+	case jsonFormat:
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		// keep the exact representation of numbers, e.g. of large IDs
+		decoder.UseNumber()
+		if err := decoder.Decode(&data); err != nil {
+			return nil, err
+		}
+	case yamlFormat:
+		if err := yaml.Unmarshal(content, &data); err != nil {
+			return nil, err
+		}
+	default:
+		decoded, err := format.Decode(content)
+		if err != nil {
+			return nil, err
+		}
+		data = decoded
+	}
+	return data, nil
+}
+
+type yamlFormat struct{}
+
+func (yamlFormat) Name() string { return "yaml" }
+
+func (yamlFormat) Decode(content []byte) (map[string]any, error) {
+	data := make(map[string]any)
+	if err := yaml.Unmarshal(content, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (yamlFormat) Patch(content []byte, local, merged any) ([]byte, error) {
+	return patchYAML(content, local, merged)
+}
+
+type jsonFormat struct{}
+
+func (jsonFormat) Name() string { return "json" }
+
+func (jsonFormat) Decode(content []byte) (map[string]any, error) {
+	data := make(map[string]any)
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	// keep the exact representation of numbers, e.g. 1000000 instead of 1e+06
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (jsonFormat) Patch(content []byte, local, merged any) ([]byte, error) {
+	return patchJSON(content, local, merged)
+}
+
+type tomlFormat struct{}
+
+func (tomlFormat) Name() string { return "toml" }
+
+func (tomlFormat) Decode(content []byte) (map[string]any, error) {
+	data := make(map[string]any)
+	if err := toml.Unmarshal(content, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Patch only supports content which is encoded exactly like the encoder of the format does,
+// there is no way to keep the formatting and comments of other content.
+func (tomlFormat) Patch(content []byte, local, merged any) ([]byte, error) {
+	encoded, err := toml.Marshal(local)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(encoded, content) {
+		return nil, errNotPatchable
+	}
+	return toml.Marshal(merged)
+}
+
+type propertiesFormat struct{}
+
+func (propertiesFormat) Name() string { return "properties" }
+
+func (propertiesFormat) Decode(content []byte) (map[string]any, error) {
+	loader := &properties.Loader{Encoding: properties.UTF8, DisableExpansion: true}
+	p, err := loader.LoadBytes(content)
+	if err != nil {
+		return nil, err
+	}
+	data := make(map[string]any, p.Len())
+	for k, v := range p.Map() {
+		data[k] = v
+	}
+	return data, nil
+}
+
+func (propertiesFormat) Patch(content []byte, local, merged any) ([]byte, error) {
+	return patchProperties(content, local, merged)
+}
@@ -0,0 +1,23 @@
+package merge
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Normalize returns a canonical encoding of the data of the content in the given format. It's equal for contents
+// which only differ in formatting, e.g. indentation, quoting, comments or the order of keys.
+// In contrast to Decode, JSON and YAML documents don't need to be mappings (e.g. the arrays of ops.json).
+func Normalize(format Format, content []byte) ([]byte, error) {
+	data, err := decodeDocument(format, content)
+	if err != nil {
+		return nil, err
+	}
+
+	// map keys are sorted by encoding/json
+	normalized, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("encoding normalized %s: %w", format.Name(), err)
+	}
+	return normalized, nil
+}
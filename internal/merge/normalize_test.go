@@ -0,0 +1,39 @@
+package merge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	testCases := []struct {
+		filename string
+		a, b     string
+		equal    bool
+	}{
+		{filename: "config.yml", a: "a: 1\nb:\n  c: x\n", b: "# comment\nb: {c: \"x\"}\na: 1", equal: true},
+		{filename: "config.yml", a: "a: 1", b: "a: 2", equal: false},
+		{filename: "ops.json", a: `[{"uuid":"x","level":4}]`, b: "[\n  {\"level\": 4, \"uuid\": \"x\"}\n]\n", equal: true},
+		{filename: "ids.json", a: `{"id":9007199254740993}`, b: `{"id":9007199254740992}`, equal: false},
+		{filename: "velocity.toml", a: "bind = \"0.0.0.0:25577\"\n[servers]\nlobby = \"x\"", b: "[servers]\nlobby = 'x'\n\n[other]\n", equal: false},
+		{filename: "velocity.toml", a: "[servers]\nlobby = \"x\"", b: "[servers]\n  lobby   = 'x'", equal: true},
+		{filename: "server.properties", a: "motd=hi\nport=25565", b: "#comment\nport = 25565\nmotd = hi\n", equal: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.filename, func(t *testing.T) {
+			format, ok := FormatFor(tc.filename)
+			require.True(t, ok)
+			a, err := Normalize(format, []byte(tc.a))
+			require.NoError(t, err)
+			b, err := Normalize(format, []byte(tc.b))
+			require.NoError(t, err)
+			assert.Equal(t, tc.equal, string(a) == string(b), "%s vs %s", a, b)
+		})
+	}
+
+	format, _ := FormatFor("broken.json")
+	_, err := Normalize(format, []byte("{"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,566 @@
+package merge
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/magiconair/properties"
+)
+
+// errNotPatchable is returned by Format.Patch if the content can't be patched without changing anything else.
+var errNotPatchable = errors.New("content can't be patched without losing its formatting")
+
+// MergeContent merges the changes made in local and desired relative to base (see ThreeWayMerge) of content
+// in the given format. The merged keys are patched into local, so its formatting and comments are kept, and
+// local is returned as-is if the merge doesn't change its data. Documents which aren't mappings (e.g. the arrays
+// of ops.json) are merged as a whole, their conflict is reported as the key ".".
+// If there are conflicts, no content is returned.
+func MergeContent(format Format, base, local, desired []byte) ([]byte, []string, error) {
+	baseData, err := decodeDocument(format, base)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding last-applied %s: %w", format.Name(), err)
+	}
+	localData, err := decodeDocument(format, local)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding current %s: %w", format.Name(), err)
+	}
+	desiredData, err := decodeDocument(format, desired)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decoding desired %s: %w", format.Name(), err)
+	}
+
+	merged, conflicts := mergeDocuments(baseData, localData, desiredData)
+	if len(conflicts) > 0 {
+		return nil, conflicts, nil
+	}
+	if reflect.DeepEqual(merged, localData) {
+		return local, nil, nil
+	}
+
+	patched, err := format.Patch(local, localData, merged)
+	if err != nil {
+		return nil, nil, fmt.Errorf("patching current %s: %w", format.Name(), err)
+	}
+	// the patched content must contain exactly the merged data
+	if data, err := decodeDocument(format, patched); err != nil || !reflect.DeepEqual(data, merged) {
+		return nil, nil, fmt.Errorf("patching current %s: %w", format.Name(), errNotPatchable)
+	}
+	return patched, nil, nil
+}
+
+// mergeDocuments merges mappings using ThreeWayMerge and other documents as a whole.
+func mergeDocuments(base, local, desired any) (any, []string) {
+	baseMap, baseIsMap := asMapping(base)
+	localMap, localIsMap := asMapping(local)
+	desiredMap, desiredIsMap := asMapping(desired)
+	if baseIsMap && localIsMap && desiredIsMap {
+		return ThreeWayMerge(baseMap, localMap, desiredMap)
+	}
+
+	switch {
+	case reflect.DeepEqual(local, desired), reflect.DeepEqual(base, desired):
+		return local, nil
+	case reflect.DeepEqual(base, local):
+		return desired, nil
+	}
+	return local, []string{"."}
+}
+
+// asMapping returns the document as map, empty documents are empty mappings.
+func asMapping(document any) (map[string]any, bool) {
+	if document == nil {
+		return nil, true
+	}
+	m, ok := document.(map[string]any)
+	return m, ok
+}
+
+// patchYAML patches the YAML syntax tree of the content, which keeps comments and the order of keys.
+func patchYAML(content []byte, local, merged any) ([]byte, error) {
+	file, err := parser.ParseBytes(content, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Docs) != 1 || file.String() != string(content) {
+		return nil, errNotPatchable
+	}
+
+	doc := file.Docs[0]
+	localMap, localIsMap := local.(map[string]any)
+	mergedMap, mergedIsMap := merged.(map[string]any)
+	if mapping, ok := doc.Body.(*ast.MappingNode); ok && localIsMap && mergedIsMap {
+		if err := patchYAMLMapping(mapping, localMap, mergedMap); err != nil {
+			return nil, err
+		}
+		return []byte(file.String()), nil
+	}
+
+	if doc.Body == nil {
+		// the content only contains comments, the merged data is appended
+		encoded, err := yaml.Marshal(merged)
+		if err != nil {
+			return nil, err
+		}
+		if len(content) > 0 && !bytes.HasSuffix(content, []byte("\n")) {
+			content = append(content, '\n')
+		}
+		return append(content, encoded...), nil
+	}
+	// the document is replaced as a whole
+	if doc.Body, err = yaml.ValueToNode(merged); err != nil {
+		return nil, err
+	}
+	return []byte(file.String()), nil
+}
+
+// patchYAMLMapping removes, replaces and adds the keys of the mapping which differ between local and merged.
+func patchYAMLMapping(mapping *ast.MappingNode, local, merged map[string]any) error {
+	values := make([]*ast.MappingValueNode, 0, len(mapping.Values))
+	seen := make(map[string]struct{})
+	for _, entry := range mapping.Values {
+		key, ok := yamlKey(entry.Key)
+		if !ok {
+			return errNotPatchable
+		}
+		localValue, inLocal := local[key]
+		if !inLocal {
+			// e.g. merge keys (<<)
+			return errNotPatchable
+		}
+		seen[key] = struct{}{}
+		mergedValue, inMerged := merged[key]
+		if !inMerged {
+			continue
+		}
+		if !reflect.DeepEqual(localValue, mergedValue) {
+			if err := patchYAMLValue(entry, localValue, mergedValue); err != nil {
+				return err
+			}
+		}
+		values = append(values, entry)
+	}
+	mapping.Values = values
+
+	added := make(map[string]any)
+	for key, value := range merged {
+		if _, ok := seen[key]; !ok {
+			added[key] = value
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	node, err := yaml.ValueToNode(added)
+	if err != nil {
+		return err
+	}
+	addition, ok := node.(*ast.MappingNode)
+	if !ok {
+		return errNotPatchable
+	}
+	mapping.Merge(addition)
+	return nil
+}
+
+// patchYAMLValue patches nested mappings or replaces the value of the entry.
+func patchYAMLValue(entry *ast.MappingValueNode, local, merged any) error {
+	localMap, localIsMap := local.(map[string]any)
+	mergedMap, mergedIsMap := merged.(map[string]any)
+	if mapping, ok := entry.Value.(*ast.MappingNode); ok && localIsMap && mergedIsMap {
+		return patchYAMLMapping(mapping, localMap, mergedMap)
+	}
+	switch entry.Value.(type) {
+	case *ast.AnchorNode, *ast.AliasNode:
+		// other values may refer to it
+		return errNotPatchable
+	}
+
+	// the value is encoded as part of an entry to be indented like the entry
+	node, err := yaml.ValueToNode(map[string]any{"value": merged})
+	if err != nil {
+		return err
+	}
+	mapping, ok := node.(*ast.MappingNode)
+	if !ok || len(mapping.Values) != 1 {
+		return errNotPatchable
+	}
+	mapping.AddColumn(entry.Key.GetToken().Position.Column - mapping.Values[0].Key.GetToken().Position.Column)
+	value := mapping.Values[0].Value
+	if _, scalar := value.(ast.ScalarNode); scalar && entry.Value.GetComment() != nil {
+		// keep comments after the value, e.g. "port: 25565 # default"
+		if err := value.SetComment(entry.Value.GetComment()); err != nil {
+			return err
+		}
+	}
+	entry.Value = value
+	return nil
+}
+
+// yamlKey returns the key of a mapping entry as decoded into a map.
+func yamlKey(key ast.MapKeyNode) (string, bool) {
+	switch k := key.(type) {
+	case *ast.StringNode:
+		return k.Value, true
+	case ast.ScalarNode:
+		return k.GetToken().Value, true
+	}
+	return "", false
+}
+
+// jsonObject is a JSON object which keeps the order of its keys.
+type jsonObject struct {
+	keys   []string
+	values map[string]any
+}
+
+func (o *jsonObject) set(key string, value any) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		encodedKey, err := encodeJSON(key)
+		if err != nil {
+			return nil, err
+		}
+		encodedValue, err := encodeJSON(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(encodedKey)
+		buf.WriteByte(':')
+		buf.Write(encodedValue)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// patchJSON decodes the content keeping the order of keys and the representation of numbers, patches it and
+// encodes it again with the indentation of the content. Content which isn't encoded this way is not patched.
+func patchJSON(content []byte, local, merged any) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	document, err := decodeOrderedJSON(decoder)
+	if err != nil {
+		return nil, err
+	}
+
+	indent := jsonIndent(content)
+	encoded, err := encodeIndentedJSON(document, indent)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(encoded, bytes.TrimSuffix(content, []byte("\n"))) {
+		return nil, errNotPatchable
+	}
+
+	patched, err := encodeIndentedJSON(patchOrderedJSON(document, local, merged), indent)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasSuffix(content, []byte("\n")) {
+		patched = append(patched, '\n')
+	}
+	return patched, nil
+}
+
+func decodeOrderedJSON(decoder *json.Decoder) (any, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		object := &jsonObject{values: make(map[string]any)}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrderedJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			object.set(key.(string), value)
+		}
+		_, err = decoder.Token() // }
+		return object, err
+	case json.Delim('['):
+		array := make([]any, 0)
+		for decoder.More() {
+			value, err := decodeOrderedJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err = decoder.Token() // ]
+		return array, err
+	}
+	return token, nil
+}
+
+// patchOrderedJSON returns the ordered document with the changes between local and merged.
+// Objects keep the order of their keys, added keys are appended.
+func patchOrderedJSON(document, local, merged any) any {
+	object, isObject := document.(*jsonObject)
+	localMap, localIsMap := local.(map[string]any)
+	mergedMap, mergedIsMap := merged.(map[string]any)
+	if !isObject || !localIsMap || !mergedIsMap {
+		if reflect.DeepEqual(local, merged) {
+			return document
+		}
+		return orderedJSON(merged)
+	}
+
+	result := &jsonObject{values: make(map[string]any)}
+	for _, key := range object.keys {
+		if mergedValue, ok := mergedMap[key]; ok {
+			result.set(key, patchOrderedJSON(object.values[key], localMap[key], mergedValue))
+		}
+	}
+	var added []string
+	for key := range mergedMap {
+		if _, ok := object.values[key]; !ok {
+			added = append(added, key)
+		}
+	}
+	slices.Sort(added)
+	for _, key := range added {
+		result.set(key, orderedJSON(mergedMap[key]))
+	}
+	return result
+}
+
+// orderedJSON converts the maps of the value into objects with sorted keys.
+func orderedJSON(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		object := &jsonObject{values: make(map[string]any, len(v))}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			object.set(key, orderedJSON(v[key]))
+		}
+		return object
+	case []any:
+		array := make([]any, len(v))
+		for i, item := range v {
+			array[i] = orderedJSON(item)
+		}
+		return array
+	}
+	return value
+}
+
+// jsonIndent returns the indentation of the first indented line, empty for compact content.
+func jsonIndent(content []byte) string {
+	lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+	for _, line := range lines[1:] {
+		trimmed := bytes.TrimLeft(line, " \t")
+		if len(trimmed) < len(line) {
+			return string(line[:len(line)-len(trimmed)])
+		}
+	}
+	return ""
+}
+
+func encodeIndentedJSON(document any, indent string) ([]byte, error) {
+	compact, err := encodeJSON(document)
+	if err != nil {
+		return nil, err
+	}
+	if indent == "" {
+		return compact, nil
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, compact, "", indent); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeJSON encodes the value without escaping HTML characters, like most other encoders.
+func encodeJSON(value any) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// patchProperties rewrites the lines of changed properties, removes the lines of removed properties and appends
+// added properties. All other lines (e.g. comments) are kept as-is.
+func patchProperties(content []byte, local, merged any) ([]byte, error) {
+	localMap, localIsMap := local.(map[string]any)
+	mergedMap, mergedIsMap := merged.(map[string]any)
+	if !localIsMap || !mergedIsMap {
+		return nil, errNotPatchable
+	}
+
+	var (
+		out       strings.Builder
+		separator string
+		seen      = make(map[string]struct{})
+	)
+	lines := strings.SplitAfter(string(content), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		key, ok, err := propertyKey(line)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			// blank lines and comments
+			out.WriteString(line)
+			continue
+		}
+		// the property continues on the next line if it ends with an odd number of backslashes
+		for continued(line) && i+1 < len(lines) {
+			i++
+			line += lines[i]
+		}
+
+		if _, inLocal := localMap[key]; !inLocal {
+			return nil, errNotPatchable
+		}
+		seen[key] = struct{}{}
+		keyEnd, valueStart := propertyBounds(line)
+		if separator == "" {
+			separator = line[keyEnd:valueStart]
+		}
+		value, inMerged := mergedMap[key]
+		switch {
+		case !inMerged:
+			continue
+		case reflect.DeepEqual(localMap[key], value):
+			out.WriteString(line)
+		default:
+			ending := line[len(strings.TrimRight(line, "\r\n")):]
+			out.WriteString(line[:valueStart] + escapeProperty(fmt.Sprint(value), false) + ending)
+		}
+	}
+
+	var added []string
+	for key := range mergedMap {
+		if _, ok := seen[key]; !ok {
+			added = append(added, key)
+		}
+	}
+	slices.Sort(added)
+	newline := "\n"
+	if strings.Contains(string(content), "\r\n") {
+		newline = "\r\n"
+	}
+	if len(added) > 0 && out.Len() > 0 && !strings.HasSuffix(out.String(), "\n") {
+		out.WriteString(newline)
+	}
+	if separator == "" {
+		separator = "="
+	}
+	for _, key := range added {
+		out.WriteString(escapeProperty(key, true) + separator + escapeProperty(fmt.Sprint(mergedMap[key]), false) + newline)
+	}
+	return []byte(out.String()), nil
+}
+
+// propertyKey returns the key of the property starting at the line, false for blank lines and comments.
+func propertyKey(line string) (string, bool, error) {
+	trimmed := strings.TrimLeft(line, " \t\f")
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "!") ||
+		strings.TrimRight(trimmed, "\r\n") == "" {
+		return "", false, nil
+	}
+	// only the key is needed, continuation lines don't matter
+	loader := &properties.Loader{Encoding: properties.UTF8, DisableExpansion: true}
+	p, err := loader.LoadBytes([]byte(strings.TrimRight(line, "\\\r\n")))
+	if err != nil {
+		return "", false, err
+	}
+	if keys := p.Keys(); len(keys) == 1 {
+		return keys[0], true, nil
+	}
+	return "", false, errNotPatchable
+}
+
+// propertyBounds returns the end of the key and the start of the value of the property line.
+func propertyBounds(line string) (keyEnd, valueStart int) {
+	i := len(line) - len(strings.TrimLeft(line, " \t\f"))
+	for i < len(line) {
+		c := line[i]
+		if c == '\\' {
+			i += 2
+			continue
+		}
+		if c == '=' || c == ':' || c == ' ' || c == '\t' || c == '\f' || c == '\r' || c == '\n' {
+			break
+		}
+		i++
+	}
+	keyEnd = min(i, len(line))
+	i = keyEnd
+	for i < len(line) && (line[i] == ' ' || line[i] == '\t' || line[i] == '\f') {
+		i++
+	}
+	if i < len(line) && (line[i] == '=' || line[i] == ':') {
+		i++
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t' || line[i] == '\f') {
+			i++
+		}
+	}
+	return keyEnd, i
+}
+
+func continued(line string) bool {
+	trimmed := strings.TrimRight(line, "\r\n")
+	backslashes := len(trimmed) - len(strings.TrimRight(trimmed, "\\"))
+	return backslashes%2 == 1
+}
+
+// escapeProperty escapes the key or value of a property.
+func escapeProperty(s string, key bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case r == ' ' && (key || i == 0):
+			b.WriteString(`\ `)
+		case key && strings.ContainsRune("=:#!", r):
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
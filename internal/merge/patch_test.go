@@ -0,0 +1,118 @@
+package merge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeContent(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		filename             string
+		base, local, desired string
+		expected             string
+		expectedConflicts    []string
+		expectedNotPatchable bool
+	}{
+		{
+			name:     "yaml comments and order are kept",
+			filename: "paper-global.yml",
+			base:     "# Paper config\nchunk-loading:\n  max-rate: 100 # per tick\n  autoconfig: true\nproxies:\n  velocity:\n    enabled: false\n    secret: abc\n",
+			local:    "# Paper config\nchunk-loading:\n  max-rate: 100 # per tick\n  autoconfig: true\nproxies:\n  velocity:\n    enabled: true # by hand\n    secret: abc\n",
+			desired:  "chunk-loading:\n  max-rate: 200\n  autoconfig: true\n  flush: [a, b]\nproxies:\n  velocity:\n    enabled: false\n",
+			expected: "# Paper config\nchunk-loading:\n  max-rate: 200 # per tick\n  autoconfig: true\n  flush:\n  - a\n  - b\nproxies:\n  velocity:\n    enabled: true # by hand\n",
+		},
+		{
+			name:     "yaml values can become mappings",
+			filename: "config.yml",
+			base:     "a: 1\nb:\n  c: x\n",
+			local:    "a: 1\nb:\n  c: y\n",
+			desired:  "a:\n  d: 2\nb:\n  c: x\n",
+			expected: "a:\n  d: 2\nb:\n  c: y\n",
+		},
+		{
+			name:                 "yaml anchors are not patched",
+			filename:             "config.yml",
+			base:                 "a: &x 1\nb: *x\nc: 1\n",
+			local:                "a: &x 1\nb: *x\nc: 2\n",
+			desired:              "a: 3\nb: 1\nc: 1\n",
+			expectedNotPatchable: true,
+		},
+		{
+			name:     "json integers and order are kept",
+			filename: "config.json",
+			base:     "{\n  \"max-players\": 1000000,\n  \"motd\": \"hello\",\n  \"ratio\": 0.5\n}\n",
+			local:    "{\n  \"max-players\": 1000000,\n  \"motd\": \"<b>hi</b>\",\n  \"ratio\": 0.5\n}\n",
+			desired:  "{\"ratio\": 0.75, \"motd\": \"hello\", \"max-players\": 1000000, \"seed\": 12345678901234567890}",
+			expected: "{\n  \"max-players\": 1000000,\n  \"motd\": \"<b>hi</b>\",\n  \"ratio\": 0.75,\n  \"seed\": 12345678901234567890\n}\n",
+		},
+		{
+			name:     "json arrays take the changed side",
+			filename: "ops.json",
+			base:     "[\n  {\n    \"uuid\": \"a\",\n    \"level\": 4\n  }\n]\n",
+			local:    "[\n  {\n    \"uuid\": \"a\",\n    \"level\": 4\n  }\n]\n",
+			desired:  "[{\"uuid\": \"a\", \"level\": 4}, {\"uuid\": \"b\", \"level\": 2}]",
+			expected: "[\n  {\n    \"level\": 4,\n    \"uuid\": \"a\"\n  },\n  {\n    \"level\": 2,\n    \"uuid\": \"b\"\n  }\n]\n",
+		},
+		{
+			name:     "json arrays changed only locally are kept",
+			filename: "ops.json",
+			base:     "[]",
+			local:    "[\n  {\n    \"uuid\": \"b\",\n    \"level\": 4\n  }\n]\n",
+			desired:  "[]\n",
+			expected: "[\n  {\n    \"uuid\": \"b\",\n    \"level\": 4\n  }\n]\n",
+		},
+		{
+			name:              "json arrays changed on both sides conflict",
+			filename:          "whitelist.json",
+			base:              "[]",
+			local:             "[{\"name\": \"a\"}]",
+			desired:           "[{\"name\": \"b\"}]",
+			expectedConflicts: []string{"."},
+		},
+		{
+			name:     "properties comments and separators are kept",
+			filename: "server.properties",
+			base:     "#Minecraft server properties\nmotd=hello\nmax-players=20\nold=x\n",
+			local:    "#Minecraft server properties\nmotd = hello \\\n  world\nmax-players=20\nold=x\n",
+			desired:  "motd=hello\nmax-players=100\nlevel-seed=a b\n",
+			expected: "#Minecraft server properties\nmotd = hello \\\n  world\nmax-players=100\nlevel-seed = a b\n",
+		},
+		{
+			name:                 "toml is only patched if it's encoded canonically",
+			filename:             "velocity.toml",
+			base:                 "# comment\nbind = \"0.0.0.0:25577\"\nmotd = \"a\"\n",
+			local:                "# comment\nbind = \"0.0.0.0:25565\"\nmotd = \"a\"\n",
+			desired:              "bind = \"0.0.0.0:25577\"\nmotd = \"b\"\n",
+			expectedNotPatchable: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			format, ok := FormatFor(tc.filename)
+			require.True(t, ok)
+			merged, conflicts, err := MergeContent(format, []byte(tc.base), []byte(tc.local), []byte(tc.desired))
+			if tc.expectedNotPatchable {
+				assert.ErrorIs(t, err, errNotPatchable)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedConflicts, conflicts)
+			if tc.expectedConflicts == nil {
+				assert.Equal(t, tc.expected, string(merged))
+			}
+		})
+	}
+}
+
+func TestMergeContentKeepsUnchangedContent(t *testing.T) {
+	format, _ := FormatFor("config.yml")
+	local := "# by hand\nb:   2\na: 1\n"
+	merged, conflicts, err := MergeContent(format, []byte("a: 1\nb: 2\n"), []byte(local), []byte("a: 1\nb: 2\n"))
+	require.NoError(t, err)
+	assert.Empty(t, conflicts)
+	assert.Equal(t, local, string(merged))
+}
@@ -0,0 +1,82 @@
+package merge
+
+import (
+	"reflect"
+	"sort"
+)
+
+// ThreeWayMerge merges the changes made in local and desired relative to base on a per-key basis.
+// Keys changed on only one side are taken from that side, nested maps are merged recursively.
+// If both sides changed the same key to different values, the key is reported as a conflict
+// (in dot-notation) and the local value is kept in the result.
+func ThreeWayMerge(base, local, desired map[string]any) (map[string]any, []string) {
+	merged, conflicts := threeWayMerge("", base, local, desired)
+	sort.Strings(conflicts)
+	return merged, conflicts
+}
+
+func threeWayMerge(prefix string, base, local, desired map[string]any) (map[string]any, []string) {
+	var conflicts []string
+	result := make(map[string]any)
+
+	for _, key := range unionKeys(base, local, desired) {
+		baseValue, inBase := base[key]
+		localValue, inLocal := local[key]
+		desiredValue, inDesired := desired[key]
+
+		keyPath := key
+		if prefix != "" {
+			keyPath = prefix + "." + key
+		}
+
+		switch {
+		case inLocal == inDesired && reflect.DeepEqual(localValue, desiredValue):
+			// both sides agree (or both removed the key)
+			if inLocal {
+				result[key] = localValue
+			}
+		case inBase == inLocal && reflect.DeepEqual(baseValue, localValue):
+			// only desired changed the key
+			if inDesired {
+				result[key] = desiredValue
+			}
+		case inBase == inDesired && reflect.DeepEqual(baseValue, desiredValue):
+			// only local changed the key
+			if inLocal {
+				result[key] = localValue
+			}
+		default:
+			localMap, localIsMap := localValue.(map[string]any)
+			desiredMap, desiredIsMap := desiredValue.(map[string]any)
+			if localIsMap && desiredIsMap {
+				baseMap, _ := baseValue.(map[string]any)
+				nested, nestedConflicts := threeWayMerge(keyPath, baseMap, localMap, desiredMap)
+				result[key] = nested
+				conflicts = append(conflicts, nestedConflicts...)
+				continue
+			}
+			// both sides changed the key in different ways, keep the local value
+			conflicts = append(conflicts, keyPath)
+			if inLocal {
+				result[key] = localValue
+			}
+		}
+	}
+
+	return result, conflicts
+}
+
+func unionKeys(maps ...map[string]any) []string {
+	keySet := make(map[string]struct{})
+	for _, m := range maps {
+		for k := range m {
+			keySet[k] = struct{}{}
+		}
+	}
+	keys := make([]string, 0, len(keySet))
+	for k := range keySet {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,54 @@
+package merge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThreeWayMerge(t *testing.T) {
+	t.Run("Non-overlapping changes should be combined", func(t *testing.T) {
+		base := values{"a": 1, "b": 2}
+		local := values{"a": 1, "b": 3}
+		desired := values{"a": 5, "b": 2, "c": 4}
+		merged, conflicts := ThreeWayMerge(base, local, desired)
+		assert.Empty(t, conflicts)
+		assert.Equal(t, values{"a": 5, "b": 3, "c": 4}, merged)
+	})
+
+	t.Run("Removals on one side should be kept", func(t *testing.T) {
+		base := values{"a": 1, "b": 2}
+		local := values{"a": 1}
+		desired := values{"a": 1, "b": 2}
+		merged, conflicts := ThreeWayMerge(base, local, desired)
+		assert.Empty(t, conflicts)
+		assert.Equal(t, values{"a": 1}, merged)
+	})
+
+	t.Run("Nested maps should be merged recursively", func(t *testing.T) {
+		base := values{"server": values{"host": "localhost", "port": 8080}}
+		local := values{"server": values{"host": "0.0.0.0", "port": 8080}}
+		desired := values{"server": values{"host": "localhost", "port": 9090}}
+		merged, conflicts := ThreeWayMerge(base, local, desired)
+		assert.Empty(t, conflicts)
+		assert.Equal(t, values{"server": values{"host": "0.0.0.0", "port": 9090}}, merged)
+	})
+
+	t.Run("Same key changed on both sides should conflict", func(t *testing.T) {
+		base := values{"server": values{"port": 8080}, "motd": "hello"}
+		local := values{"server": values{"port": 25565}, "motd": "hello"}
+		desired := values{"server": values{"port": 9090}, "motd": "hello"}
+		merged, conflicts := ThreeWayMerge(base, local, desired)
+		assert.Equal(t, []string{"server.port"}, conflicts)
+		assert.Equal(t, values{"server": values{"port": 25565}, "motd": "hello"}, merged)
+	})
+
+	t.Run("Identical changes on both sides should not conflict", func(t *testing.T) {
+		base := values{"a": 1}
+		local := values{"a": 2}
+		desired := values{"a": 2}
+		merged, conflicts := ThreeWayMerge(base, local, desired)
+		assert.Empty(t, conflicts)
+		assert.Equal(t, values{"a": 2}, merged)
+	})
+}
@@ -0,0 +1,266 @@
+package plan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sap-gg/gok/internal"
+	"github.com/sap-gg/gok/internal/diff"
+	"github.com/sap-gg/gok/internal/glob"
+	"github.com/sap-gg/gok/internal/lockfile"
+)
+
+// Plan is the reviewed result of a diff. Applying a plan only proceeds if neither the artifact
+// nor the destination changed since the plan was created.
+type Plan struct {
+	Version   int       `yaml:"version" validate:"required"`
+	CreatedAt time.Time `yaml:"createdAt"`
+
+	// ArtifactDigest identifies the desired state. It's the digest of the paths and hashes of all files
+	// of the artifact (including its lock file), see ArtifactDigest.
+	ArtifactDigest string `yaml:"artifactDigest" validate:"required"`
+	// Target is the selected target of a multi-target artifact (if any).
+	Target string `yaml:"target,omitempty"`
+
+	// Destination is the absolute path of the destination directory.
+	Destination string `yaml:"destination" validate:"required"`
+	// DestinationLockDigest is the digest of the lock file of the destination, empty if it had none.
+	DestinationLockDigest string `yaml:"destinationLockDigest,omitempty"`
+
+	// Merge is true if conflicting structured files were three-way merged.
+	Merge bool `yaml:"merge"`
+	// Semantic is true if structured files which only differ in formatting were treated as unchanged.
+	Semantic bool `yaml:"semantic,omitempty"`
+	// Include and Exclude are the globs limiting the compared paths (if any).
+	Include []string `yaml:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+
+	// Changes are all changes of the diff, sorted by path.
+	Changes []*Change `yaml:"changes" validate:"dive,required"`
+}
+
+// Change is a single planned change.
+type Change struct {
+	Path string `yaml:"path" validate:"required"`
+	// Type is the change type, e.g. created or conflict.
+	Type string `yaml:"type" validate:"required"`
+	// Resolution is only set for conflicts.
+	Resolution string `yaml:"resolution,omitempty"`
+	// From is the previous path of a renamed file.
+	From string `yaml:"from,omitempty"`
+	// ObservedHash is the hash of the path in the destination when the plan was created, empty if it didn't exist.
+	ObservedHash string `yaml:"observedHash,omitempty"`
+	// DesiredHash is the hash of the path in the desired state, empty if it's removed.
+	DesiredHash string `yaml:"desiredHash,omitempty"`
+}
+
+// New creates a plan for the report of the comparison of the desired state (identified by artifactDigest)
+// with the destination directory, limited to the paths selected by filter (if set).
+func New(artifactDigest, target, destinationDir string, merge bool, filter *glob.Filter, report *diff.Report) (*Plan, error) {
+	destination, err := filepath.Abs(destinationDir)
+	if err != nil {
+		return nil, fmt.Errorf("resolving destination: %w", err)
+	}
+	lockDigest, err := Digest(destination)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := observe(destination, report)
+	if err != nil {
+		return nil, err
+	}
+	p := &Plan{
+		Version:               internal.PlanVersion,
+		CreatedAt:             time.Now().UTC(),
+		ArtifactDigest:        artifactDigest,
+		Target:                target,
+		Destination:           destination,
+		DestinationLockDigest: lockDigest,
+		Merge:                 merge,
+		Changes:               changes,
+	}
+	if filter != nil {
+		p.Include, p.Exclude = filter.Include, filter.Exclude
+	}
+	return p, nil
+}
+
+// Filter returns the path filter the plan was created with, nil if all paths were compared.
+func (p *Plan) Filter() *glob.Filter {
+	filter := &glob.Filter{Include: p.Include, Exclude: p.Exclude}
+	if filter.IsEmpty() {
+		return nil
+	}
+	return filter
+}
+
+// observe returns the changes of the report with the current hashes of their paths in the destination.
+func observe(destinationDir string, report *diff.Report) ([]*Change, error) {
+	changes := []*Change{}
+	for _, path := range report.SortedPaths() {
+		change := report.Changes[path]
+		if change.Type == diff.Unchanged {
+			continue
+		}
+		observed, err := lockfile.PathHash(filepath.Join(destinationDir, filepath.FromSlash(path)))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("computing hash of %q: %w", path, err)
+		}
+		planned := &Change{
+			Path:         path,
+			Type:         change.Type.String(),
+			ObservedHash: observed,
+			DesiredHash:  change.NewHash,
+			From:         change.OldPath,
+		}
+		if change.Type == diff.Conflict {
+			planned.Resolution = change.Resolution.String()
+		}
+		changes = append(changes, planned)
+	}
+	return changes, nil
+}
+
+// ArtifactDigest returns the digest of the desired state in dir, i.e. of the paths and hashes of all its files,
+// directories and symlinks. The desired state must contain a lock file.
+func ArtifactDigest(dir string) (string, error) {
+	if _, err := os.Stat(filepath.Join(dir, internal.LockFileName)); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("the artifact contains no %s", internal.LockFileName)
+		}
+		return "", fmt.Errorf("reading lock file: %w", err)
+	}
+
+	var entries strings.Builder
+	// WalkDir visits the entries in lexical order, so the digest is stable
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hash, err := lockfile.PathHash(path)
+		if err != nil {
+			return fmt.Errorf("computing hash of %q: %w", rel, err)
+		}
+		entries.WriteString(filepath.ToSlash(rel) + "\x00" + hash + "\n")
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("reading artifact: %w", err)
+	}
+	return "sha256:" + lockfile.SHA256([]byte(entries.String())), nil
+}
+
+// Digest returns the digest of the lock file in dir, or an empty string if dir contains no lock file.
+func Digest(dir string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(dir, internal.LockFileName))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", nil
+		}
+		return "", fmt.Errorf("reading lock file: %w", err)
+	}
+	return "sha256:" + lockfile.SHA256(content), nil
+}
+
+// Verify checks that the plan still describes the changes of applying the desired state (identified by
+// artifactDigest) according to the report. All differences are returned combined.
+func (p *Plan) Verify(artifactDigest string, report *diff.Report) error {
+	if artifactDigest != p.ArtifactDigest {
+		return fmt.Errorf("the artifact differs from the planned one (digest %s, planned %s)",
+			artifactDigest, p.ArtifactDigest)
+	}
+	lockDigest, err := Digest(p.Destination)
+	if err != nil {
+		return err
+	}
+	if lockDigest != p.DestinationLockDigest {
+		return fmt.Errorf("the lock file of the destination changed since the plan was created, " +
+			"another apply probably happened")
+	}
+
+	current, err := observe(p.Destination, report)
+	if err != nil {
+		return err
+	}
+	planned := make(map[string]*Change, len(p.Changes))
+	for _, c := range p.Changes {
+		planned[c.Path] = c
+	}
+
+	var problems []string
+	for _, c := range current {
+		want, ok := planned[c.Path]
+		delete(planned, c.Path)
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s: unplanned change (%s)", c.Path, c.describe()))
+		case *want != *c:
+			if want.ObservedHash != c.ObservedHash {
+				problems = append(problems, fmt.Sprintf("%s: changed in the destination since the plan was created", c.Path))
+			} else {
+				problems = append(problems, fmt.Sprintf("%s: planned %s, now %s", c.Path, want.describe(), c.describe()))
+			}
+		}
+	}
+	for _, want := range p.Changes {
+		if _, ok := planned[want.Path]; ok {
+			problems = append(problems, fmt.Sprintf("%s: planned change (%s) no longer necessary", want.Path, want.describe()))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("the destination changed since the plan was created:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func (c *Change) describe() string {
+	if c.Resolution != "" {
+		return c.Type + ", " + c.Resolution
+	}
+	return c.Type
+}
+
+// Write writes the plan to path.
+func (p *Plan) Write(ctx context.Context, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating plan file: %w", err)
+	}
+	defer f.Close()
+
+	if err := internal.NewYAMLEncoder(f).EncodeContext(ctx, p); err != nil {
+		return fmt.Errorf("encoding plan: %w", err)
+	}
+	return f.Close()
+}
+
+// Read reads the plan at path.
+func Read(ctx context.Context, path string) (*Plan, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening plan %q: %w", path, err)
+	}
+	defer f.Close()
+
+	var p Plan
+	if err := internal.NewYAMLDecoder(f).DecodeContext(ctx, &p); err != nil {
+		if internal.IsDecodeErrorAndPrint(err) {
+			return nil, fmt.Errorf("parsing plan")
+		}
+		return nil, fmt.Errorf("decoding plan %q: %w", path, err)
+	}
+	if p.Version != internal.PlanVersion {
+		return nil, fmt.Errorf("unsupported plan version %d (expected %d)", p.Version, internal.PlanVersion)
+	}
+	return &p, nil
+}
@@ -0,0 +1,173 @@
+package plan
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sap-gg/gok/internal/diff"
+	"github.com/sap-gg/gok/internal/glob"
+	"github.com/sap-gg/gok/internal/lockfile"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(path))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+	}
+}
+
+// setup creates a destination (applied from the old files, with local edits) and a desired state.
+func setup(t *testing.T) (destination, desired string) {
+	destination, desired = t.TempDir(), t.TempDir()
+	writeFiles(t, destination, map[string]string{
+		"server.properties": "motd=old",
+		"ops.json":          "[]",
+		"bukkit.yml":        "a: 1",
+	})
+	require.NoError(t, lockfile.Create(context.Background(), destination))
+	writeFiles(t, destination, map[string]string{"ops.json": `["local"]`})
+
+	writeFiles(t, desired, map[string]string{
+		"server.properties": "motd=new",
+		"ops.json":          `["desired"]`,
+		"bukkit.yml":        "a: 1",
+		"start.sh":          "#!/bin/sh",
+	})
+	require.NoError(t, lockfile.Create(context.Background(), desired))
+	return destination, desired
+}
+
+func compare(t *testing.T, destination, desired string) *diff.Report {
+	t.Helper()
+	report, err := diff.NewComparer(destination, desired).Compare()
+	require.NoError(t, err)
+	return report
+}
+
+func TestPlan(t *testing.T) {
+	destination, desired := setup(t)
+	digest, err := ArtifactDigest(desired)
+	require.NoError(t, err)
+	require.NotEmpty(t, digest)
+
+	p, err := New(digest, "survival", destination, true, nil, compare(t, destination, desired))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ops.json", "server.properties", "start.sh"}, paths(p.Changes))
+	assert.Equal(t, "conflict", p.Changes[0].Type)
+	assert.Equal(t, "unresolved", p.Changes[0].Resolution)
+	assert.Equal(t, lockfile.SHA256([]byte(`["local"]`)), p.Changes[0].ObservedHash)
+	assert.Empty(t, p.Changes[2].ObservedHash, "created files don't exist yet")
+
+	path := filepath.Join(t.TempDir(), "plan.yaml")
+	require.NoError(t, p.Write(context.Background(), path))
+	read, err := Read(context.Background(), path)
+	require.NoError(t, err)
+	assert.Equal(t, p, read)
+
+	assert.NoError(t, read.Verify(digest, compare(t, destination, desired)))
+}
+
+func TestPlanVerifyOutdated(t *testing.T) {
+	testCases := []struct {
+		name     string
+		modify   func(t *testing.T, destination, desired string)
+		errorMsg string
+	}{
+		{
+			name: "artifact changed",
+			modify: func(t *testing.T, _, desired string) {
+				writeFiles(t, desired, map[string]string{"start.sh": "#!/bin/bash"})
+				require.NoError(t, lockfile.Create(context.Background(), desired))
+			},
+			errorMsg: "the artifact differs",
+		},
+		{
+			name: "artifact content changed with the same lock file",
+			modify: func(t *testing.T, _, desired string) {
+				writeFiles(t, desired, map[string]string{"start.sh": "#!/bin/bash"})
+			},
+			errorMsg: "the artifact differs",
+		},
+		{
+			name: "conflicting file edited again",
+			modify: func(t *testing.T, destination, _ string) {
+				writeFiles(t, destination, map[string]string{"ops.json": `["local", "again"]`})
+			},
+			errorMsg: "ops.json: changed in the destination",
+		},
+		{
+			name: "unchanged file edited",
+			modify: func(t *testing.T, destination, _ string) {
+				writeFiles(t, destination, map[string]string{"bukkit.yml": "a: 2"})
+			},
+			errorMsg: "bukkit.yml: unplanned change (conflict, unresolved)",
+		},
+		{
+			name: "planned change made manually",
+			modify: func(t *testing.T, destination, _ string) {
+				writeFiles(t, destination, map[string]string{"start.sh": "#!/bin/sh"})
+			},
+			errorMsg: "start.sh: changed in the destination",
+		},
+		{
+			name: "destination applied in the meantime",
+			modify: func(t *testing.T, destination, _ string) {
+				require.NoError(t, lockfile.Create(context.Background(), destination))
+			},
+			errorMsg: "the lock file of the destination changed",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			destination, desired := setup(t)
+			digest, err := ArtifactDigest(desired)
+			require.NoError(t, err)
+			p, err := New(digest, "", destination, true, nil, compare(t, destination, desired))
+			require.NoError(t, err)
+
+			tc.modify(t, destination, desired)
+
+			digest, err = ArtifactDigest(desired)
+			require.NoError(t, err)
+			assert.ErrorContains(t, p.Verify(digest, compare(t, destination, desired)), tc.errorMsg)
+		})
+	}
+}
+
+func TestArtifactDigestRequiresLockFile(t *testing.T) {
+	desired := t.TempDir()
+	writeFiles(t, desired, map[string]string{"start.sh": "#!/bin/sh"})
+	_, err := ArtifactDigest(desired)
+	assert.ErrorContains(t, err, "the artifact contains no")
+}
+
+func paths(changes []*Change) []string {
+	var result []string
+	for _, c := range changes {
+		result = append(result, c.Path)
+	}
+	return result
+}
+
+func TestPlanFilter(t *testing.T) {
+	destination, desired := setup(t)
+	filter := &glob.Filter{Include: []string{"*.properties"}}
+	report, err := diff.NewComparer(destination, desired, diff.WithPathFilter(filter)).Compare()
+	require.NoError(t, err)
+
+	p, err := New("sha256:abc", "", destination, true, filter, report)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"server.properties"}, paths(p.Changes))
+	assert.Equal(t, filter, p.Filter())
+
+	p, err = New("sha256:abc", "", destination, true, nil, compare(t, destination, desired))
+	require.NoError(t, err)
+	assert.Nil(t, p.Filter())
+}
@@ -0,0 +1,195 @@
+package rcon
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// Packet types of the (Source) RCON protocol as implemented by Minecraft servers.
+const (
+	TypeResponse     int32 = 0
+	TypeCommand      int32 = 2
+	TypeAuthResponse int32 = 2
+	TypeAuth         int32 = 3
+)
+
+const (
+	// MaxPayloadSize is the maximum size of a packet body accepted by Minecraft servers.
+	MaxPayloadSize = 1446
+	// maxPacketSize limits the size of packets read from the server (responses may be up to 4096 bytes).
+	maxPacketSize = 4096 + 10
+
+	// headerSize is the size of the request ID and type, the two null bytes are part of the packet as well.
+	headerSize = 8
+)
+
+// ErrAuthFailed is returned by Dial if the server rejected the password.
+var ErrAuthFailed = errors.New("rcon authentication failed")
+
+// Packet is a single RCON packet.
+type Packet struct {
+	ID   int32
+	Type int32
+	Body string
+}
+
+// WritePacket writes the packet to w.
+func WritePacket(w io.Writer, p *Packet) error {
+	var buf bytes.Buffer
+	size := int32(headerSize + len(p.Body) + 2)
+	_ = binary.Write(&buf, binary.LittleEndian, size)
+	_ = binary.Write(&buf, binary.LittleEndian, p.ID)
+	_ = binary.Write(&buf, binary.LittleEndian, p.Type)
+	buf.WriteString(p.Body)
+	buf.Write([]byte{0, 0})
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ReadPacket reads a single packet from r.
+func ReadPacket(r io.Reader) (*Packet, error) {
+	var size int32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	if size < headerSize+2 || size > maxPacketSize {
+		return nil, fmt.Errorf("invalid rcon packet size %d", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return &Packet{
+		ID:   int32(binary.LittleEndian.Uint32(data[0:4])),
+		Type: int32(binary.LittleEndian.Uint32(data[4:8])),
+		Body: string(bytes.TrimRight(data[headerSize:], "\x00")),
+	}, nil
+}
+
+// Client is a connection to the RCON interface of a server.
+type Client struct {
+	conn   net.Conn
+	nextID int32
+}
+
+// Dial connects to the RCON interface at address (host:port) and authenticates using the password.
+// The deadline of ctx (if any) applies to the connection as a whole.
+func Dial(ctx context.Context, address, password string) (*Client, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s: %w", address, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("set deadline: %w", err)
+		}
+	}
+
+	c := &Client{conn: conn, nextID: 1}
+	if err := c.authenticate(password); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) authenticate(password string) error {
+	id, err := c.send(TypeAuth, password)
+	if err != nil {
+		return fmt.Errorf("send authentication: %w", err)
+	}
+	for {
+		p, err := ReadPacket(c.conn)
+		if err != nil {
+			return fmt.Errorf("read authentication response: %w", err)
+		}
+		// some servers send an empty response value before the actual auth response
+		if p.Type != TypeAuthResponse {
+			continue
+		}
+		if p.ID == -1 {
+			return ErrAuthFailed
+		}
+		if p.ID != id {
+			return fmt.Errorf("unexpected authentication response id %d", p.ID)
+		}
+		return nil
+	}
+}
+
+// Command runs the command (without leading slash) and returns the response of the server.
+//
+// Responses may be split over multiple packets, so an empty response value packet is sent after the command.
+// Servers answer packets in order, so the response is complete as soon as the answer to this packet arrives.
+// If the command stops the server, the connection may be closed before a response is sent,
+// which is not considered an error.
+func (c *Client) Command(command string) (string, error) {
+	if len(command) > MaxPayloadSize {
+		return "", fmt.Errorf("command too long (%d bytes, max %d)", len(command), MaxPayloadSize)
+	}
+	stopping := isStop(command)
+	id, err := c.send(TypeCommand, command)
+	if err != nil {
+		return "", fmt.Errorf("send command: %w", err)
+	}
+	sentinel, err := c.send(TypeResponse, "")
+	if err != nil {
+		if stopping && isClosed(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("send command: %w", err)
+	}
+
+	var response strings.Builder
+	for {
+		p, err := ReadPacket(c.conn)
+		if err != nil {
+			if stopping && isClosed(err) {
+				return response.String(), nil
+			}
+			return "", fmt.Errorf("read response: %w", err)
+		}
+		switch {
+		case p.ID == id:
+			response.WriteString(p.Body)
+		case p.ID == sentinel:
+			return response.String(), nil
+		case p.ID > sentinel:
+			return "", fmt.Errorf("unexpected response id %d (expected %d)", p.ID, id)
+		}
+		// responses to the packets of earlier commands, e.g. the second answer of Source servers
+		// to an empty response value, are skipped
+	}
+}
+
+// isStop returns true if the command stops the server.
+func isStop(command string) bool {
+	fields := strings.Fields(strings.TrimPrefix(command, "/"))
+	return len(fields) > 0 && (fields[0] == "stop" || fields[0] == "minecraft:stop")
+}
+
+// isClosed returns true if err is caused by a connection closed by the server.
+func isClosed(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) send(packetType int32, body string) (int32, error) {
+	id := c.nextID
+	c.nextID++
+	return id, WritePacket(c.conn, &Packet{ID: id, Type: packetType, Body: body})
+}
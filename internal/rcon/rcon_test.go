@@ -0,0 +1,98 @@
+package rcon_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sap-gg/gok/internal/rcon"
+	"github.com/sap-gg/gok/internal/rcon/rcontest"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, rcon.WritePacket(&buf, &rcon.Packet{ID: 42, Type: rcon.TypeCommand, Body: "say hi"}))
+	// size (4) + id (4) + type (4) + body + two null bytes
+	assert.Equal(t, 4+4+4+len("say hi")+2, buf.Len())
+
+	p, err := rcon.ReadPacket(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, &rcon.Packet{ID: 42, Type: rcon.TypeCommand, Body: "say hi"}, p)
+}
+
+func TestReadPacketInvalidSize(t *testing.T) {
+	_, err := rcon.ReadPacket(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0x7f}))
+	assert.ErrorContains(t, err, "invalid rcon packet size")
+}
+
+func TestClient(t *testing.T) {
+	server := rcontest.NewServer(t, "secret")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err := rcon.Dial(ctx, server.Addr(), "secret")
+	require.NoError(t, err)
+	defer client.Close()
+
+	response, err := client.Command("say Restarting in 60s")
+	require.NoError(t, err)
+	assert.Equal(t, "ran: say Restarting in 60s", response)
+
+	response, err = client.Command("reload confirm")
+	require.NoError(t, err)
+	assert.Equal(t, "ran: reload confirm", response)
+	assert.Equal(t, []string{"say Restarting in 60s", "reload confirm"}, server.Commands())
+
+	_, err = client.Command(strings.Repeat("x", rcon.MaxPayloadSize+1))
+	assert.ErrorContains(t, err, "command too long")
+}
+
+func TestClientMultiPacketResponse(t *testing.T) {
+	server := rcontest.NewServer(t, "secret")
+	long := strings.Repeat("a", 4096) + strings.Repeat("b", 4096) + "c"
+	server.SetResponse("help", long)
+	server.SetResponse("list", "")
+
+	client, err := rcon.Dial(context.Background(), server.Addr(), "secret")
+	require.NoError(t, err)
+	defer client.Close()
+
+	response, err := client.Command("help")
+	require.NoError(t, err)
+	assert.Equal(t, long, response, "all packets of the response must be read")
+
+	response, err = client.Command("list")
+	require.NoError(t, err)
+	assert.Empty(t, response)
+
+	response, err = client.Command("say hi")
+	require.NoError(t, err)
+	assert.Equal(t, "ran: say hi", response, "no packet of an earlier response may be left")
+}
+
+func TestClientStop(t *testing.T) {
+	server := rcontest.NewServer(t, "secret")
+	client, err := rcon.Dial(context.Background(), server.Addr(), "secret")
+	require.NoError(t, err)
+	defer client.Close()
+
+	response, err := client.Command("stop")
+	require.NoError(t, err, "the server closing the connection after stop is expected")
+	assert.Empty(t, response)
+	assert.Equal(t, []string{"stop"}, server.Commands())
+
+	_, err = client.Command("say hi")
+	assert.Error(t, err, "other commands must fail on a closed connection")
+}
+
+func TestClientWrongPassword(t *testing.T) {
+	server := rcontest.NewServer(t, "secret")
+	_, err := rcon.Dial(context.Background(), server.Addr(), "wrong")
+	assert.ErrorIs(t, err, rcon.ErrAuthFailed)
+	assert.Empty(t, server.Commands())
+}
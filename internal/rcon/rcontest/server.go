@@ -0,0 +1,169 @@
+// Package rcontest provides a fake RCON server for tests.
+package rcontest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/sap-gg/gok/internal/rcon"
+)
+
+// maxResponseSize is the maximum size of the body of a response packet sent by Minecraft servers.
+const maxResponseSize = 4096
+
+// Server is a fake RCON server listening on a random local port.
+// It records all received commands and responds with "ran: <command>" (or the response set with SetResponse).
+// Like Minecraft servers, responses longer than 4096 bytes are split into multiple packets,
+// other packets are answered with an "Unknown request" response value and "stop" closes the connection.
+type Server struct {
+	Password string
+
+	listener  net.Listener
+	mu        sync.Mutex
+	commands  []string
+	responses map[string]string
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// NewServer starts a server accepting the password. It is closed when the test finishes.
+func NewServer(t testing.TB, password string) *Server {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("rcontest: listen: %v", err)
+	}
+	s := &Server{Password: password, listener: listener, conns: make(map[net.Conn]struct{})}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Host returns the host the server listens on.
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(s.listener.Addr().String())
+	return host
+}
+
+// Port returns the port the server listens on.
+func (s *Server) Port() int {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+// Addr returns the address (host:port) of the server.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Commands returns all commands received by authenticated clients, in order.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+// SetResponse sets the response to the command.
+func (s *Server) SetResponse(command, response string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.responses == nil {
+		s.responses = make(map[string]string)
+	}
+	s.responses[command] = response
+}
+
+// Close stops the server and closes all open connections.
+func (s *Server) Close() {
+	_ = s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			_ = s.handle(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			_ = conn.Close()
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) error {
+	authenticated := false
+	for {
+		p, err := rcon.ReadPacket(conn)
+		if err != nil {
+			return err
+		}
+		switch p.Type {
+		case rcon.TypeAuth:
+			id := p.ID
+			authenticated = p.Body == s.Password
+			if !authenticated {
+				id = -1
+			}
+			// like Minecraft servers, send an empty response value first
+			if err := rcon.WritePacket(conn, &rcon.Packet{ID: p.ID, Type: rcon.TypeResponse}); err != nil {
+				return err
+			}
+			if err := rcon.WritePacket(conn, &rcon.Packet{ID: id, Type: rcon.TypeAuthResponse}); err != nil {
+				return err
+			}
+		case rcon.TypeCommand:
+			if !authenticated {
+				return errors.New("not authenticated")
+			}
+			s.mu.Lock()
+			s.commands = append(s.commands, p.Body)
+			response, ok := s.responses[p.Body]
+			s.mu.Unlock()
+			if p.Body == "stop" {
+				return nil
+			}
+			if !ok {
+				response = "ran: " + p.Body
+			}
+			for {
+				chunk := response[:min(len(response), maxResponseSize)]
+				if err := rcon.WritePacket(conn, &rcon.Packet{ID: p.ID, Type: rcon.TypeResponse, Body: chunk}); err != nil {
+					return err
+				}
+				response = response[len(chunk):]
+				if response == "" {
+					break
+				}
+			}
+		default:
+			body := fmt.Sprintf("Unknown request %x", p.Type)
+			if err := rcon.WritePacket(conn, &rcon.Packet{ID: p.ID, Type: rcon.TypeResponse, Body: body}); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package render
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sap-gg/gok/internal/glob"
+	"github.com/sap-gg/gok/internal/lockfile"
+)
+
+var _ lockfile.Annotator = (*Engine)(nil)
+
+// renderedTarget contains metadata collected while rendering a single target.
+type renderedTarget struct {
+	id string
+
+	// output is the slash-separated output directory of the target, relative to the work dir
+	output string
+	// hooks are the apply hooks declared for the target in the manifest
+	hooks *lockfile.Hooks
+
+	// conflictRules are the conflict rules of all applied templates, in order
+	conflictRules []*ConflictRule
+	// seedRules are the seed globs of all applied templates
+	seedRules []string
+	// exclusiveDirs are the exclusively managed directories of all applied templates
+	exclusiveDirs []string
+	// permissionRules are the permission rules of all applied templates, in order
+	permissionRules []*PermissionRule
+}
+
+// strategySymlink is recorded as strategy of layers which created a symlink
+const strategySymlink = "symlink"
+
+// renderedFile contains metadata collected while rendering a single output file.
+type renderedFile struct {
+	// seed is true if the file was marked as seed using the internal.SeedInfix infix
+	seed bool
+	// layers are the template layers which wrote or patched the file, in order
+	layers []*lockfile.SourceLayer
+	// artifact is true if the file is downloaded from an artifact spec
+	artifact bool
+	// sensitive is true if a layer rendered the file from a template importing secrets,
+	// the secrets may still be part of the file after later layers patched it
+	sensitive bool
+}
+
+// file returns the metadata of the output file at the absolute path dst, creating it if necessary.
+func (e *Engine) file(dst string) (*renderedFile, error) {
+	rel, err := e.workDirResolver.Relative(dst)
+	if err != nil {
+		return nil, fmt.Errorf("relative output path %q: %w", dst, err)
+	}
+	rel = filepath.ToSlash(rel)
+	f, ok := e.files[rel]
+	if !ok {
+		f = &renderedFile{}
+		e.files[rel] = f
+	}
+	return f, nil
+}
+
+// forget drops the collected layers of the deleted output path at the absolute path dst
+// (and of all paths below it if recursive is set).
+func (e *Engine) forget(dst string, recursive bool) error {
+	rel, err := e.workDirResolver.Relative(dst)
+	if err != nil {
+		return fmt.Errorf("relative output path %q: %w", dst, err)
+	}
+	rel = filepath.ToSlash(rel)
+	for path, f := range e.files {
+		if path == rel || (recursive && strings.HasPrefix(path, rel+"/")) {
+			f.layers, f.artifact, f.sensitive = nil, false, false
+		}
+	}
+	if e.observer != nil {
+		e.observer.Deleted(dst, recursive)
+	}
+	return nil
+}
+
+// relative returns the path relative to the target output, or false if the path is not part of the target.
+func (t *renderedTarget) relative(path string) (string, bool) {
+	if t.output == "." {
+		return path, true
+	}
+	rel, ok := strings.CutPrefix(path, t.output+"/")
+	return rel, ok
+}
+
+// conflictPolicy returns the policy of the last rule matching the path (relative to the target output).
+func (t *renderedTarget) conflictPolicy(rel string) lockfile.ConflictPolicy {
+	var policy lockfile.ConflictPolicy
+	for _, rule := range t.conflictRules {
+		if glob.Match(rule.Path, rel) {
+			policy = rule.Policy
+		}
+	}
+	return policy
+}
+
+// targetFor returns the rendered target owning the path (relative to the work dir).
+// If target outputs are nested, the most specific output wins.
+func (e *Engine) targetFor(path string) (*renderedTarget, string, bool) {
+	var (
+		best    *renderedTarget
+		bestRel string
+	)
+	for _, t := range e.targets {
+		rel, ok := t.relative(path)
+		if !ok {
+			continue
+		}
+		if best == nil || len(t.output) > len(best.output) {
+			best, bestRel = t, rel
+		}
+	}
+	return best, bestRel, best != nil
+}
+
+// Annotate adds the metadata collected during rendering to the lock file entries.
+// Seed files are moved from the managed files to the seeds of the lock file.
+func (e *Engine) Annotate(lock *lockfile.LockFile) error {
+	for path, entry := range lock.Files {
+		target, rel, ok := e.targetFor(path)
+		if !ok {
+			continue
+		}
+
+		entry.UID, entry.GID = target.ownership(rel)
+
+		file := e.files[path]
+		entry.Source = &lockfile.FileSource{Target: target.id}
+		if file != nil {
+			entry.Source.Layers, entry.Source.Artifact = file.layers, file.artifact
+		}
+		if (file != nil && file.seed) || glob.MatchAny(target.seedRules, rel) {
+			if lock.Seeds == nil {
+				lock.Seeds = make(lockfile.LockFiles)
+			}
+			lock.Seeds[path] = entry
+			delete(lock.Files, path)
+			continue
+		}
+
+		entry.Policy = target.conflictPolicy(rel)
+	}
+
+	for _, target := range e.targets {
+		if lock.Targets == nil {
+			lock.Targets = make(map[string]*lockfile.TargetEntry)
+		}
+		lock.Targets[target.id] = &lockfile.TargetEntry{Output: target.output, Hooks: target.hooks}
+
+		for _, dir := range target.exclusiveDirs {
+			lock.ExclusiveDirs = append(lock.ExclusiveDirs, path.Join(target.output, filepath.ToSlash(dir)))
+		}
+	}
+	slices.Sort(lock.ExclusiveDirs)
+	lock.ExclusiveDirs = slices.Compact(lock.ExclusiveDirs)
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package render
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sap-gg/gok/internal/lockfile"
+)
+
+func TestEngineAnnotateConflictPolicies(t *testing.T) {
+	proxyHooks := &lockfile.Hooks{PostApply: []*lockfile.Hook{{Command: []string{"systemctl", "restart", "proxy"}}}}
+	engine := &Engine{
+		targets: map[string]*renderedTarget{
+			"survival": {
+				id:     "survival",
+				output: "survival",
+				conflictRules: []*ConflictRule{
+					{Path: "*.json", Policy: lockfile.PolicyKeepLocal},
+					{Path: "banned-players.json", Policy: lockfile.PolicyBackupAndReplace},
+				},
+			},
+			"proxy": {
+				id:     "proxy",
+				output: "proxy",
+				hooks:  proxyHooks,
+			},
+		},
+	}
+
+	lock := &lockfile.LockFile{Files: lockfile.LockFiles{
+		"survival/ops.json":             {},
+		"survival/banned-players.json":  {},
+		"survival/plugins/foo.json":     {},
+		"proxy/ops.json":                {},
+		"not-part-of-any-target/a.json": {},
+	}}
+	require.NoError(t, engine.Annotate(lock))
+
+	assert.Equal(t, lockfile.PolicyKeepLocal, lock.Files["survival/ops.json"].Policy)
+	assert.Equal(t, lockfile.PolicyBackupAndReplace, lock.Files["survival/banned-players.json"].Policy)
+	assert.Empty(t, lock.Files["survival/plugins/foo.json"].Policy)
+	assert.Empty(t, lock.Files["proxy/ops.json"].Policy)
+	assert.Empty(t, lock.Files["not-part-of-any-target/a.json"].Policy)
+
+	assert.Equal(t, map[string]*lockfile.TargetEntry{
+		"survival": {Output: "survival"},
+		"proxy":    {Output: "proxy", Hooks: proxyHooks},
+	}, lock.Targets)
+}
+
+func TestEngineAnnotateSeeds(t *testing.T) {
+	engine := &Engine{
+		targets: map[string]*renderedTarget{
+			"survival": {
+				id:        "survival",
+				output:    "survival",
+				seedRules: []string{"plugins/**/*.db"},
+			},
+		},
+		files: map[string]*renderedFile{
+			"survival/permissions.yml": {seed: true},
+		},
+	}
+
+	lock := &lockfile.LockFile{Files: lockfile.LockFiles{
+		"survival/permissions.yml":          {},
+		"survival/plugins/LuckPerms/lp.db":  {},
+		"survival/plugins/LuckPerms/lp.yml": {},
+	}}
+	require.NoError(t, engine.Annotate(lock))
+
+	assert.Contains(t, lock.Seeds, "survival/permissions.yml")
+	assert.Contains(t, lock.Seeds, "survival/plugins/LuckPerms/lp.db")
+	assert.NotContains(t, lock.Files, "survival/permissions.yml")
+	assert.NotContains(t, lock.Files, "survival/plugins/LuckPerms/lp.db")
+	assert.Contains(t, lock.Files, "survival/plugins/LuckPerms/lp.yml")
+}
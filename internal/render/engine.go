@@ -9,6 +9,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"text/template"
 
@@ -17,10 +18,24 @@ import (
 
 	"github.com/sap-gg/gok/internal"
 	"github.com/sap-gg/gok/internal/artifact"
+	"github.com/sap-gg/gok/internal/lockfile"
 	"github.com/sap-gg/gok/internal/strategy"
 	"github.com/sap-gg/gok/internal/templ"
 )
 
+// secretModeMask are the permission bits removed from files rendered by templates which import secrets
+const secretModeMask fs.FileMode = 0o077
+
+// templateLayer contains the data needed to apply the files of a single template.
+type templateLayer struct {
+	// template is the path of the template as declared in the manifest
+	template string
+	// data is passed to rendered files
+	data any
+	// sensitive is true if the template imports secrets
+	sensitive bool
+}
+
 // Engine performs the rendering for manifest targets
 type Engine struct {
 	registry        *strategy.Registry
@@ -40,6 +55,13 @@ type Engine struct {
 	// workDir is the directory where rendering output is placed
 	workDir         string
 	workDirResolver *GenericPathResolver
+
+	// targets keeps track of rendering metadata per rendered target, used to annotate the lock file
+	targets map[string]*renderedTarget
+	// files keeps track of rendering metadata per output file (slash-separated, relative to the work dir)
+	files map[string]*renderedFile
+	// observer is notified about changes to the output (if set)
+	observer Observer
 }
 
 // NewEngine creates a new rendering engine. All parameters are required.
@@ -94,6 +116,9 @@ func NewEngine(
 
 		workDir:         workDir,
 		workDirResolver: workDirResolver,
+
+		targets: make(map[string]*renderedTarget),
+		files:   make(map[string]*renderedFile),
 	}, nil
 }
 
@@ -133,11 +158,23 @@ func (e *Engine) RenderTarget(
 		return fmt.Errorf("output dir resolver: %w", err)
 	}
 
+	outputRel, err := e.workDirResolver.Relative(outputDir)
+	if err != nil {
+		return fmt.Errorf("relative output dir %q: %w", outputDir, err)
+	}
+	rendered := &renderedTarget{
+		id:     target.ID,
+		output: filepath.ToSlash(outputRel),
+		hooks:  target.Hooks,
+	}
+	e.targets[target.ID] = rendered
+
 	for _, templateSpec := range target.Templates {
 		if err := e.applyTemplate(ctx,
 			target,
 			templateSpec,
 			currentOutputResolver,
+			rendered,
 		); err != nil {
 			return fmt.Errorf("processing template spec %q: %w", templateSpec.Path, err)
 		}
@@ -151,6 +188,7 @@ func (e *Engine) applyTemplate(
 	target *ManifestTarget,
 	templateSpec *TemplateSpec,
 	currentOutputResolver *GenericPathResolver,
+	rendered *renderedTarget,
 ) error {
 	l := log.With().Str("template", templateSpec.Path).Logger()
 
@@ -184,6 +222,10 @@ func (e *Engine) applyTemplate(
 		if len(templateManifest.Maintainers) > 0 {
 			log.Info().Msgf(" ~ maintained by: %s", templateManifest.MaintainerString())
 		}
+		rendered.conflictRules = append(rendered.conflictRules, templateManifest.Conflicts...)
+		rendered.seedRules = append(rendered.seedRules, templateManifest.Seeds...)
+		rendered.exclusiveDirs = append(rendered.exclusiveDirs, templateManifest.ExclusiveDirs...)
+		rendered.permissionRules = append(rendered.permissionRules, templateManifest.Permissions...)
 	}
 
 	availableValues := ComputeTemplateValues(e.globalValues,
@@ -218,10 +260,21 @@ func (e *Engine) applyTemplate(
 		return fmt.Errorf("apply deletions for %q: %w", srcRoot, err)
 	}
 
-	if err := e.applyDir(ctx, srcRoot, currentOutputResolver, templateContext); err != nil {
+	layer := &templateLayer{
+		template:  templateSpec.Path,
+		data:      templateContext,
+		sensitive: templateManifest != nil && templateManifest.Imports != nil && len(templateManifest.Imports.Secrets) > 0,
+	}
+	if err := e.applyDir(ctx, srcRoot, currentOutputResolver, layer); err != nil {
 		return fmt.Errorf("apply dir %q: %w", srcRoot, err)
 	}
 
+	if templateManifest != nil {
+		if err := e.applyDirectories(templateManifest.Directories, currentOutputResolver); err != nil {
+			return fmt.Errorf("apply directories for %q: %w", srcRoot, err)
+		}
+	}
+
 	return nil
 }
 
@@ -401,6 +454,10 @@ func (e *Engine) applyDeletions(
 		} else {
 			log.Info().Msgf("deleted path %q", absPath)
 		}
+		// the layers which wrote the deleted files no longer contribute to the output
+		if err := e.forget(absPath, deletion.Recursive); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -410,7 +467,7 @@ func (e *Engine) applyDir(
 	ctx context.Context,
 	srcDir string,
 	dstDirResolver *GenericPathResolver,
-	data any,
+	layer *templateLayer,
 ) error {
 	return filepath.WalkDir(srcDir, func(path string, d os.DirEntry, walkErr error) error {
 		if walkErr != nil {
@@ -431,7 +488,8 @@ func (e *Engine) applyDir(
 		if err != nil {
 			return fmt.Errorf("get info for %q: %w", path, err)
 		}
-		if !info.Mode().IsRegular() {
+		isSymlink := info.Mode()&fs.ModeSymlink != 0
+		if !info.Mode().IsRegular() && !isSymlink {
 			log.Debug().Str("path", path).Msg("skipping non-regular file")
 			return nil // skip non-regular files
 		}
@@ -445,20 +503,50 @@ func (e *Engine) applyDir(
 			return fmt.Errorf("resolve dst %q: %w", rel, err)
 		}
 
-		return e.applyFile(ctx, path, dst, data)
+		if isSymlink {
+			if err := e.applySymlink(path, dst); err != nil {
+				return err
+			}
+			return e.applied(&AppliedFile{
+				Dst:   dst,
+				Src:   path,
+				Layer: &lockfile.SourceLayer{Template: layer.template, Strategy: strategySymlink},
+			})
+		}
+		return e.applyFile(ctx, path, dst, layer)
 	})
 }
 
-func (e *Engine) applyFile(ctx context.Context, src, dst string, data any) error {
+func (e *Engine) applyFile(ctx context.Context, src, dst string, layer *templateLayer) error {
 	var (
-		finalDst         = dst
 		srcContentReader io.Reader
+		rendered         bool
+		// applied is passed to the observer once the file was applied
+		applied = &AppliedFile{Src: src}
 	)
 
-	base := filepath.Base(src)
+	// the final name of the output file, without the seed infix, template infix and artifact suffix
+	name, seed := trimInfix(filepath.Base(dst), internal.SeedInfix)
+	isArtifact := strings.HasSuffix(name, internal.ArtifactSuffix)
+	isTemplate := !isArtifact && strings.Contains(name, internal.TemplateInfix)
+	switch {
+	case isArtifact:
+		name = strings.TrimSuffix(name, internal.ArtifactSuffix)
+	case isTemplate:
+		name = strings.Replace(name, internal.TemplateInfix, "", 1)
+	}
+	finalDst := filepath.Join(filepath.Dir(dst), name)
 
-	if strings.HasSuffix(base, internal.ArtifactSuffix) {
-		finalDst = strings.TrimSuffix(dst, internal.ArtifactSuffix)
+	if seed {
+		log.Debug().Msgf("detected seed file %q", finalDst)
+		file, err := e.file(finalDst)
+		if err != nil {
+			return err
+		}
+		file.seed = true
+	}
+
+	if isArtifact {
 		log.Debug().Msgf("detected artifact manifest for %q", finalDst)
 
 		content, err := os.ReadFile(src)
@@ -469,17 +557,23 @@ func (e *Engine) applyFile(ctx context.Context, src, dst string, data any) error
 		var renderedContent bytes.Buffer
 
 		// artifacts are always rendered using text/template
-		if err := e.renderer.Render(&renderedContent, string(content), data); err != nil {
+		if err := e.renderer.Render(&renderedContent, string(content), layer.data); err != nil {
 			return fmt.Errorf("render artifact manifest %q: %w", src, err)
 		}
 
 		// don't apply any file strategy, just register the artifact for later processing
-		return e.artifactTracker.Register(finalDst, &renderedContent)
+		if err := e.artifactTracker.Register(finalDst, &renderedContent); err != nil {
+			return err
+		}
+		return e.applied(&AppliedFile{
+			Dst:   finalDst,
+			Src:   src,
+			Layer: &lockfile.SourceLayer{Template: layer.template, Strategy: lockfile.StrategyArtifact},
+		})
 	}
 
-	if strings.Contains(base, internal.TemplateInfix) {
+	if isTemplate {
 		log.Debug().Msgf("rendering template file %q...", src)
-		finalDst = strings.Replace(dst, internal.TemplateInfix, "", 1)
 
 		content, err := os.ReadFile(src)
 		if err != nil {
@@ -487,7 +581,7 @@ func (e *Engine) applyFile(ctx context.Context, src, dst string, data any) error
 		}
 
 		var renderedContent bytes.Buffer
-		if err := e.renderer.Render(&renderedContent, string(content), data); err != nil {
+		if err := e.renderer.Render(&renderedContent, string(content), layer.data); err != nil {
 			var execError template.ExecError
 			if errors.As(err, &execError) {
 				// TODO(future): pretty print
@@ -496,7 +590,9 @@ func (e *Engine) applyFile(ctx context.Context, src, dst string, data any) error
 			return fmt.Errorf("render template %q: %w", src, err)
 		}
 
+		applied.Template, applied.Rendered, applied.Data = string(content), renderedContent.Bytes(), layer.data
 		srcContentReader = &renderedContent
+		rendered = true
 	} else {
 		sf, err := os.Open(src)
 		if err != nil {
@@ -506,6 +602,19 @@ func (e *Engine) applyFile(ctx context.Context, src, dst string, data any) error
 		srcContentReader = sf
 	}
 
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("stat src %q: %w", src, err)
+	}
+
+	// a file replaces a symlink of a previous layer, it's never applied "through" the link
+	if info, err := os.Lstat(finalDst); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		log.Debug().Msgf("replacing symlink %q with a file", finalDst)
+		if err := os.Remove(finalDst); err != nil {
+			return fmt.Errorf("remove symlink %q: %w", finalDst, err)
+		}
+	}
+
 	var strat strategy.FileStrategy
 	if _, err := os.Stat(finalDst); errors.Is(err, os.ErrNotExist) {
 		// first seen: copy the (possibly rendered) content
@@ -524,7 +633,82 @@ func (e *Engine) applyFile(ctx context.Context, src, dst string, data any) error
 		}
 	}
 
-	return strat.Apply(ctx, srcContentReader, finalDst)
+	if err := strat.Apply(ctx, srcContentReader, finalDst); err != nil {
+		return err
+	}
+	applied.Dst = finalDst
+	applied.Layer = &lockfile.SourceLayer{Template: layer.template, Strategy: strat.Name(), Templated: rendered}
+	if err := e.applied(applied); err != nil {
+		return err
+	}
+
+	file, err := e.file(finalDst)
+	if err != nil {
+		return err
+	}
+	if rendered && layer.sensitive {
+		file.sensitive = true
+	}
+
+	// the mode of the last layer wins, e.g. to keep the executable bit of scripts
+	mode := srcInfo.Mode().Perm()
+	if file.sensitive {
+		// rendered files of templates importing secrets may contain them, so they are private by default.
+		// this also applies to later layers patching the file, the secrets are kept by the patch
+		mode &^= secretModeMask
+	}
+	if err := os.Chmod(finalDst, mode); err != nil {
+		return fmt.Errorf("chmod %q: %w", finalDst, err)
+	}
+	return nil
+}
+
+// trimInfix removes the infix (e.g. ".seed") from the file name if it's a whole dot-separated part of it,
+// i.e. "permissions.seed.yml" contains ".seed" but "world.seedbank.yml" does not.
+func trimInfix(name, infix string) (string, bool) {
+	parts := strings.Split(name, ".")
+	// the first part is the name itself, e.g. "seed.yml" has no infix
+	for i := 1; i < len(parts); i++ {
+		if "."+parts[i] == infix {
+			return strings.Join(slices.Delete(parts, i, i+1), "."), true
+		}
+	}
+	return name, false
+}
+
+// applySymlink recreates the symlink src at dst. The link target is kept as-is.
+func (e *Engine) applySymlink(src, dst string) error {
+	linkTarget, err := os.Readlink(src)
+	if err != nil {
+		return fmt.Errorf("read symlink %q: %w", src, err)
+	}
+	log.Debug().Msgf("creating symlink %q -> %q", dst, linkTarget)
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return fmt.Errorf("mkdir %q: %w", filepath.Dir(dst), err)
+	}
+	if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove existing %q: %w", dst, err)
+	}
+	if err := os.Symlink(linkTarget, dst); err != nil {
+		return fmt.Errorf("create symlink %q: %w", dst, err)
+	}
+	return nil
+}
+
+// applyDirectories creates the (possibly empty) directories declared by a template.
+func (e *Engine) applyDirectories(dirs []string, dstDirResolver *GenericPathResolver) error {
+	for _, dir := range dirs {
+		absPath, err := dstDirResolver.Resolve(dir)
+		if err != nil {
+			return fmt.Errorf("resolve directory %q: %w", dir, err)
+		}
+		if err := os.MkdirAll(absPath, 0o755); err != nil {
+			return fmt.Errorf("create directory %q: %w", absPath, err)
+		}
+		log.Debug().Msgf("created directory %q", absPath)
+	}
+	return nil
 }
 
 // ResolveArtifacts triggers the processing of all collected artifacts.
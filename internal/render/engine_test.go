@@ -9,6 +9,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/sap-gg/gok/internal"
+	"github.com/sap-gg/gok/internal/lockfile"
 	"github.com/sap-gg/gok/internal/strategy"
 	"github.com/sap-gg/gok/internal/templ"
 )
@@ -116,3 +118,231 @@ values:
 
 	t.Logf("Final rendered output:\n%s", string(outputBytes))
 }
+
+func TestEnginePreservesModesSymlinksAndDirectories(t *testing.T) {
+	tempDir := t.TempDir()
+
+	manifestContent := `
+version: 1
+targets:
+  my-target:
+    output: "output"
+    templates:
+      - from: ./template
+`
+	manifestPath := filepath.Join(tempDir, "gok-manifest.yaml")
+	require.NoError(t, os.WriteFile(manifestPath, []byte(manifestContent), 0644))
+
+	templateDir := filepath.Join(tempDir, "template")
+	require.NoError(t, os.Mkdir(templateDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(templateDir, "start.sh"), []byte("#!/bin/sh"), 0755))
+	require.NoError(t, os.Symlink("../shared/config.yml", filepath.Join(templateDir, "config.yml")))
+	require.NoError(t, os.WriteFile(filepath.Join(templateDir, "gok-template.yaml"), []byte(`
+version: 1
+directories:
+  - logs
+`), 0644))
+
+	ctx := context.Background()
+	manifest, manifestDir, err := ReadManifest(ctx, manifestPath)
+	require.NoError(t, err)
+
+	workDir := t.TempDir()
+	registry, err := strategy.NewRegistry(&strategy.CopyOnlyStrategy{Overwrite: true}, nil)
+	require.NoError(t, err)
+	engine, err := NewEngine(manifestDir, workDir, templ.NewTemplateRenderer(), registry,
+		manifest.Values, nil, NewValuesOverwritesSpec(), NewValuesOverwritesSpec(), nil)
+	require.NoError(t, err)
+	require.NoError(t, engine.RenderTarget(ctx, manifest.Targets["my-target"]))
+
+	info, err := os.Stat(filepath.Join(workDir, "output", "start.sh"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	link, err := os.Readlink(filepath.Join(workDir, "output", "config.yml"))
+	require.NoError(t, err)
+	assert.Equal(t, "../shared/config.yml", link)
+
+	assert.DirExists(t, filepath.Join(workDir, "output", "logs"))
+}
+
+func TestEngineRecordsSourceLayers(t *testing.T) {
+	tempDir := t.TempDir()
+	write := func(rel, content string) {
+		p := filepath.Join(tempDir, rel)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	write("gok-manifest.yaml", `
+version: 1
+targets:
+  survival:
+    output: "survival"
+    templates:
+      - from: ./base
+      - from: ./survival
+`)
+	write("base/config.yml", "a: 1\n")
+	write("base/old.txt", "old")
+	write("base/start.sh", "#!/bin/sh")
+	write("survival/config.templ.yml", "b: {{ 2 }}\n")
+	write("survival/old.txt", "new")
+	write("survival/gok-deletions.yaml", `
+version: 1
+deletions:
+  - path: old.txt
+`)
+
+	ctx := context.Background()
+	manifest, manifestDir, err := ReadManifest(ctx, filepath.Join(tempDir, "gok-manifest.yaml"))
+	require.NoError(t, err)
+
+	workDir := t.TempDir()
+	registry, err := strategy.NewRegistry(&strategy.CopyOnlyStrategy{Overwrite: true},
+		map[string]strategy.FileStrategy{".yml": &strategy.YAMLPatchStrategy{}})
+	require.NoError(t, err)
+	engine, err := NewEngine(manifestDir, workDir, templ.NewTemplateRenderer(), registry,
+		manifest.Values, nil, NewValuesOverwritesSpec(), NewValuesOverwritesSpec(), nil)
+	require.NoError(t, err)
+	require.NoError(t, engine.RenderTarget(ctx, manifest.Targets["survival"]))
+
+	lock := &lockfile.LockFile{Files: lockfile.LockFiles{
+		"survival/config.yml": {},
+		"survival/old.txt":    {},
+		"survival/start.sh":   {},
+	}}
+	require.NoError(t, engine.Annotate(lock))
+
+	assert.Equal(t, &lockfile.FileSource{Target: "survival", Layers: []*lockfile.SourceLayer{
+		{Template: "./base", Strategy: "copy-only"},
+		{Template: "./survival", Strategy: "yaml-patch", Templated: true},
+	}}, lock.Files["survival/config.yml"].Source)
+	assert.Equal(t, &lockfile.FileSource{Target: "survival", Layers: []*lockfile.SourceLayer{
+		{Template: "./survival", Strategy: "copy-only"},
+	}}, lock.Files["survival/old.txt"].Source, "layers before a deletion must be dropped")
+	assert.Equal(t, "target survival: ./base (copy-only), ./survival (yaml-patch, templated)",
+		lock.Files["survival/config.yml"].Source.String())
+}
+
+func TestEngineKeepsSecretModeOfPatchedFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	write := func(rel, content string) {
+		p := filepath.Join(tempDir, rel)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	write("gok-manifest.yaml", `
+version: 1
+targets:
+  survival:
+    output: "survival"
+    templates:
+      - from: ./secret
+      - from: ./patch
+`)
+	write("secret/gok-template.yaml", `
+version: 1
+imports:
+  secrets:
+    "rcon.password":
+      description: "The RCON password."
+      required: true
+`)
+	write("secret/config.templ.yml", "rcon: {{ .secrets.rcon.password }}\n")
+	write("patch/config.yml", "motd: hello\n")
+
+	ctx := context.Background()
+	manifest, manifestDir, err := ReadManifest(ctx, filepath.Join(tempDir, "gok-manifest.yaml"))
+	require.NoError(t, err)
+
+	workDir := t.TempDir()
+	registry, err := strategy.NewRegistry(&strategy.CopyOnlyStrategy{Overwrite: true},
+		map[string]strategy.FileStrategy{".yml": &strategy.YAMLPatchStrategy{}})
+	require.NoError(t, err)
+	secrets := Values{"rcon": map[string]any{"password": "s3cr3t"}}
+	engine, err := NewEngine(manifestDir, workDir, templ.NewTemplateRenderer(), registry,
+		manifest.Values, secrets, NewValuesOverwritesSpec(), NewValuesOverwritesSpec(), nil)
+	require.NoError(t, err)
+	require.NoError(t, engine.RenderTarget(ctx, manifest.Targets["survival"]))
+
+	path := filepath.Join(workDir, "survival", "config.yml")
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "s3cr3t")
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "patching a file containing secrets must keep it private")
+}
+
+func TestEngineRecordsSeedInfix(t *testing.T) {
+	tempDir := t.TempDir()
+	write := func(rel, content string) {
+		p := filepath.Join(tempDir, rel)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	write("gok-manifest.yaml", `
+version: 1
+targets:
+  survival:
+    output: "survival"
+    templates:
+      - from: ./base
+`)
+	write("base/permissions.seed.templ.yml", "admin: {{ 1 }}\n")
+	write("base/world.seedbank.yml", "a: 1\n")
+	write("base/foo.seeds.json", "{}")
+
+	ctx := context.Background()
+	manifest, manifestDir, err := ReadManifest(ctx, filepath.Join(tempDir, "gok-manifest.yaml"))
+	require.NoError(t, err)
+
+	workDir := t.TempDir()
+	registry, err := strategy.NewRegistry(&strategy.CopyOnlyStrategy{Overwrite: true}, nil)
+	require.NoError(t, err)
+	engine, err := NewEngine(manifestDir, workDir, templ.NewTemplateRenderer(), registry,
+		manifest.Values, nil, NewValuesOverwritesSpec(), NewValuesOverwritesSpec(), nil)
+	require.NoError(t, err)
+	require.NoError(t, engine.RenderTarget(ctx, manifest.Targets["survival"]))
+
+	content, err := os.ReadFile(filepath.Join(workDir, "survival", "permissions.yml"))
+	require.NoError(t, err)
+	assert.Equal(t, "admin: 1\n", string(content))
+	assert.FileExists(t, filepath.Join(workDir, "survival", "world.seedbank.yml"))
+	assert.FileExists(t, filepath.Join(workDir, "survival", "foo.seeds.json"))
+
+	lock := &lockfile.LockFile{Files: lockfile.LockFiles{
+		"survival/permissions.yml":    {},
+		"survival/world.seedbank.yml": {},
+		"survival/foo.seeds.json":     {},
+	}}
+	require.NoError(t, engine.Annotate(lock))
+
+	assert.Contains(t, lock.Seeds, "survival/permissions.yml", "templated seeds must be recorded by their final name")
+	assert.NotContains(t, lock.Files, "survival/permissions.yml")
+	assert.Contains(t, lock.Files, "survival/world.seedbank.yml")
+	assert.Contains(t, lock.Files, "survival/foo.seeds.json")
+}
+
+func TestTrimInfix(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		found    bool
+	}{
+		{"permissions.seed.yml", "permissions.yml", true},
+		{"x.seed.templ.yml", "x.templ.yml", true},
+		{"x.seedbank.yml", "x.seedbank.yml", false},
+		{"foo.seeds.json", "foo.seeds.json", false},
+		{"seed.yml", "seed.yml", false},
+		{"world.seed", "world", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, found := trimInfix(tt.name, internal.SeedInfix)
+			assert.Equal(t, tt.expected, name)
+			assert.Equal(t, tt.found, found)
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 
 	"github.com/sap-gg/gok/internal"
+	"github.com/sap-gg/gok/internal/lockfile"
 )
 
 // Manifest represents the structure of the manifest file used to define rendering targets and their associated templates.
@@ -43,6 +44,10 @@ type ManifestTarget struct {
 
 	// Values are additional values with a scope limited to this target.
 	Values Values `yaml:"values"`
+
+	// Hooks are commands run when the output of this target is applied to a destination.
+	// They are recorded in the lock file, so they travel with the artifact.
+	Hooks *lockfile.Hooks `yaml:"hooks"`
 }
 
 // GlobalSpec represents global values that can be applied to all templates in the manifest.
@@ -64,6 +69,9 @@ func (t *ManifestTarget) Validate() error {
 			return fmt.Errorf("template[%d]: %w", i+1, err)
 		}
 	}
+	if err := t.Hooks.Validate(); err != nil {
+		return fmt.Errorf("hooks: %w", err)
+	}
 	return nil
 }
 
@@ -0,0 +1,52 @@
+package render
+
+import (
+	"github.com/sap-gg/gok/internal/lockfile"
+)
+
+// Observer is notified about the changes the engine makes to the output, e.g. to attribute the
+// content of output files to template layers.
+type Observer interface {
+	// Applied is called after a template layer applied a file to the output.
+	Applied(file *AppliedFile)
+	// Deleted is called after a template layer deleted a path (and everything below it if recursive) of the output.
+	Deleted(dst string, recursive bool)
+}
+
+// AppliedFile describes a file a template layer applied to the output.
+type AppliedFile struct {
+	// Dst is the absolute path of the output file.
+	Dst string
+	// Src is the absolute path of the file in the template, i.e. the artifact spec for artifacts.
+	Src string
+	// Layer is the template layer and the strategy it applied the file with.
+	Layer *lockfile.SourceLayer
+
+	// Template is the unrendered content of templated files.
+	Template string
+	// Rendered is the rendered content of templated files.
+	Rendered []byte
+	// Data is the data templated files were rendered with.
+	Data any
+}
+
+// Observe registers an observer which is notified while targets are rendered.
+func (e *Engine) Observe(observer Observer) {
+	e.observer = observer
+}
+
+// applied records the layer which applied the output file and notifies the observer (if any).
+func (e *Engine) applied(file *AppliedFile) error {
+	rendered, err := e.file(file.Dst)
+	if err != nil {
+		return err
+	}
+	rendered.layers = append(rendered.layers, file.Layer)
+	if file.Layer.Strategy == lockfile.StrategyArtifact {
+		rendered.artifact = true
+	}
+	if e.observer != nil {
+		e.observer.Applied(file)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package render
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sap-gg/gok/internal/glob"
+)
+
+// mode returns the mode of the last permission rule matching the path (relative to the target output)
+// which declares a mode, or false if no such rule exists.
+func (t *renderedTarget) mode(rel string) (fs.FileMode, bool) {
+	var (
+		mode  fs.FileMode
+		found bool
+	)
+	for _, rule := range t.permissionRules {
+		if rule.Mode != "" && glob.Match(rule.Path, rel) {
+			mode, found = rule.mode, true
+		}
+	}
+	return mode, found
+}
+
+// ownership returns the uid and gid of the last permission rules matching the path (relative to the target output)
+// which declare them. A nil value means the ownership is not managed.
+func (t *renderedTarget) ownership(rel string) (uid, gid *int) {
+	for _, rule := range t.permissionRules {
+		if !glob.Match(rule.Path, rel) {
+			continue
+		}
+		if rule.UID != nil {
+			uid = rule.UID
+		}
+		if rule.GID != nil {
+			gid = rule.GID
+		}
+	}
+	return uid, gid
+}
+
+// ApplyPermissions applies the modes declared by the permission rules of all rendered targets to the output files.
+// It must be called after all targets and artifacts are rendered, as rules of later templates may match
+// files of earlier templates. Symlinks are never changed.
+func (e *Engine) ApplyPermissions() error {
+	return filepath.WalkDir(e.workDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == e.workDir || d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		rel, err := e.workDirResolver.Relative(path)
+		if err != nil {
+			return fmt.Errorf("relative output path %q: %w", path, err)
+		}
+		target, targetRel, ok := e.targetFor(filepath.ToSlash(rel))
+		if !ok {
+			return nil
+		}
+		mode, ok := target.mode(targetRel)
+		if !ok {
+			return nil
+		}
+		log.Debug().Msgf("setting mode of %q to %04o", rel, mode)
+		if err := os.Chmod(path, mode); err != nil {
+			return fmt.Errorf("chmod %q: %w", path, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,78 @@
+package render
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sap-gg/gok/internal/lockfile"
+)
+
+func TestEngineApplyPermissions(t *testing.T) {
+	workDir := t.TempDir()
+	resolver, err := NewGenericPathResolver(workDir)
+	require.NoError(t, err)
+
+	for _, name := range []string{"survival/server.properties", "survival/secrets.yml", "survival/start.sh"} {
+		p := filepath.Join(workDir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte("content"), 0o644))
+	}
+
+	engine := &Engine{
+		workDir:         workDir,
+		workDirResolver: resolver,
+		targets: map[string]*renderedTarget{
+			"survival": {
+				id:     "survival",
+				output: "survival",
+				permissionRules: []*PermissionRule{
+					{Path: "*.yml", Mode: "0640", mode: 0o640},
+					{Path: "secrets.yml", Mode: "0600", mode: 0o600},
+					{Path: "*.sh", Mode: "0755", mode: 0o755},
+				},
+			},
+		},
+	}
+	require.NoError(t, engine.ApplyPermissions())
+
+	for name, expected := range map[string]os.FileMode{
+		"survival/server.properties": 0o644,
+		"survival/secrets.yml":       0o600,
+		"survival/start.sh":          0o755,
+	} {
+		info, err := os.Stat(filepath.Join(workDir, filepath.FromSlash(name)))
+		require.NoError(t, err)
+		assert.Equal(t, expected, info.Mode().Perm(), name)
+	}
+}
+
+func TestEngineAnnotateOwnership(t *testing.T) {
+	uid, gid, otherGID := 1000, 1000, 50
+	engine := &Engine{
+		targets: map[string]*renderedTarget{
+			"survival": {
+				id:     "survival",
+				output: "survival",
+				permissionRules: []*PermissionRule{
+					{Path: "**", UID: &uid, GID: &gid},
+					{Path: "logs/", GID: &otherGID},
+				},
+			},
+		},
+	}
+
+	lock := &lockfile.LockFile{Files: lockfile.LockFiles{
+		"survival/server.properties": {},
+		"survival/logs/latest.log":   {},
+		"proxy/config.yml":           {},
+	}}
+	require.NoError(t, engine.Annotate(lock))
+
+	assert.Equal(t, "1000:1000", lock.Files["survival/server.properties"].FormatOwnership())
+	assert.Equal(t, "1000:50", lock.Files["survival/logs/latest.log"].FormatOwnership())
+	assert.False(t, lock.Files["proxy/config.yml"].HasOwnership())
+}
@@ -6,9 +6,12 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/sap-gg/gok/internal"
+	"github.com/sap-gg/gok/internal/glob"
+	"github.com/sap-gg/gok/internal/lockfile"
 )
 
 // TemplateSpec represents a single template specification inside the manifest, including the path to the template file.
@@ -45,6 +48,53 @@ type TemplateManifest struct {
 	// Only values specified here will be passed from the manifest to this template
 	// (optional, default is to receive no values)
 	Imports *TemplateImports `yaml:"imports"`
+
+	// Conflicts are per-path policies defining how manual changes to rendered files are handled on apply.
+	// If multiple rules match a file, the last matching rule of the last template wins. (optional)
+	Conflicts []*ConflictRule `yaml:"conflicts"`
+
+	// Seeds are globs (relative to the target output) of files which are only created if absent
+	// and never managed afterward, e.g. files the server takes ownership of. (optional)
+	// Alternatively, files can be marked as seed using the internal.SeedInfix infix.
+	Seeds []string `yaml:"seeds"`
+
+	// ExclusiveDirs are directories (relative to the target output) which are exclusively managed by gok.
+	// Untracked files inside them can be removed using 'gok apply --prune'. (optional)
+	ExclusiveDirs []string `yaml:"exclusiveDirs" validate:"dive,required"`
+
+	// Directories (relative to the target output) which are created even if they are empty. (optional)
+	Directories []string `yaml:"directories" validate:"dive,required"`
+
+	// Permissions override the mode and ownership of matching files.
+	// If multiple rules match a file, the last matching rule of the last template wins per attribute. (optional)
+	Permissions []*PermissionRule `yaml:"permissions"`
+}
+
+// ConflictRule maps a glob (relative to the target output) to a conflict policy.
+type ConflictRule struct {
+	// Path is a glob pattern relative to the target output, "**" matches any number of directories
+	Path string `yaml:"path" validate:"required"`
+
+	// Policy is the conflict policy for all matching files
+	Policy lockfile.ConflictPolicy `yaml:"policy" validate:"required,oneof=fail keep-local take-desired merge backup-and-replace"`
+}
+
+// PermissionRule maps a glob (relative to the target output) to a file mode and ownership.
+type PermissionRule struct {
+	// Path is a glob pattern relative to the target output, "**" matches any number of directories
+	Path string `yaml:"path" validate:"required"`
+
+	// Mode is the octal permission mode, e.g. "0600" (optional)
+	Mode string `yaml:"mode"`
+
+	// UID is the numeric owner applied by 'gok apply' (optional)
+	UID *int `yaml:"uid" validate:"omitempty,min=0"`
+
+	// GID is the numeric group applied by 'gok apply' (optional)
+	GID *int `yaml:"gid" validate:"omitempty,min=0"`
+
+	// mode is the parsed Mode
+	mode fs.FileMode
 }
 
 // NameOrDefault returns the template name, or the base name of the given path if the name is not set.
@@ -146,5 +196,28 @@ func ReadTemplateManifest(ctx context.Context, dirPath string) (*TemplateManifes
 			m.Version, internal.TemplateManifestVersion)
 	}
 
+	for i, rule := range m.Conflicts {
+		if err := glob.Validate(rule.Path); err != nil {
+			return nil, fmt.Errorf("conflicts[%d]: invalid path %q: %w", i, rule.Path, err)
+		}
+	}
+	for i, rule := range m.Permissions {
+		if err := glob.Validate(rule.Path); err != nil {
+			return nil, fmt.Errorf("permissions[%d]: invalid path %q: %w", i, rule.Path, err)
+		}
+		if rule.Mode != "" {
+			mode, err := strconv.ParseUint(rule.Mode, 8, 32)
+			if err != nil || mode > 0o777 {
+				return nil, fmt.Errorf("permissions[%d]: invalid mode %q (expected octal, e.g. 0644)", i, rule.Mode)
+			}
+			rule.mode = fs.FileMode(mode)
+		}
+	}
+	for i, seed := range m.Seeds {
+		if err := glob.Validate(seed); err != nil {
+			return nil, fmt.Errorf("seeds[%d]: invalid path %q: %w", i, seed, err)
+		}
+	}
+
 	return &m, nil
 }
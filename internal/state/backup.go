@@ -0,0 +1,192 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/sap-gg/gok/internal"
+)
+
+const (
+	backupsDirName = "backups"
+
+	// backupTimeFormat is used to name backup directories, it sorts chronologically
+	backupTimeFormat = "20060102T150405.000Z"
+	// legacyBackupTimeFormat is the format of backups created before milliseconds were added
+	legacyBackupTimeFormat = "20060102T150405Z"
+)
+
+// Backup collects copies of destination files before they are replaced or removed.
+type Backup struct {
+	// ID is the name of the backup directory, i.e. the time the backup was created
+	ID   string
+	Time time.Time
+
+	destinationDir string
+	dir            string
+	saved          []string
+	// claimed is true once the directory of the backup was created by this backup (or it was read from disk)
+	claimed bool
+}
+
+// NewBackup creates a Backup stored in a directory named after the given time.
+// Nothing is written until the first file is saved.
+func (s *Store) NewBackup(t time.Time) *Backup {
+	t = t.UTC().Truncate(time.Millisecond)
+	return s.newBackup(t.Format(backupTimeFormat), t)
+}
+
+func (s *Store) newBackup(id string, t time.Time) *Backup {
+	return &Backup{
+		ID:             id,
+		Time:           t,
+		destinationDir: s.destinationDir,
+		dir:            filepath.Join(s.root, backupsDirName, id),
+	}
+}
+
+// claim creates the directory of the backup. If a backup with the same time already exists, e.g. of another
+// apply in the same millisecond, the time is moved forward, so existing backups are never overwritten.
+func (b *Backup) claim() error {
+	if err := os.MkdirAll(filepath.Dir(b.dir), 0o755); err != nil {
+		return fmt.Errorf("create backups directory: %w", err)
+	}
+	for {
+		err := os.Mkdir(b.dir, 0o755)
+		if err == nil {
+			b.claimed = true
+			return nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("create backup directory: %w", err)
+		}
+		b.Time = b.Time.Add(time.Millisecond)
+		b.ID = b.Time.Format(backupTimeFormat)
+		b.dir = filepath.Join(filepath.Dir(b.dir), b.ID)
+	}
+}
+
+// Save copies the destination file (or symlink) at rel into the backup, keeping its mode.
+func (b *Backup) Save(rel string) error {
+	if !b.claimed {
+		if err := b.claim(); err != nil {
+			return err
+		}
+	}
+	src := filepath.Join(b.destinationDir, filepath.FromSlash(rel))
+	if err := internal.CopyPath(src, filepath.Join(b.dir, filepath.FromSlash(rel))); err != nil {
+		return err
+	}
+	b.saved = append(b.saved, rel)
+	return nil
+}
+
+// Dir returns the directory of the backup.
+func (b *Backup) Dir() string {
+	return b.dir
+}
+
+// Saved returns the paths (relative to the destination) of all saved files.
+func (b *Backup) Saved() []string {
+	return b.saved
+}
+
+// Contains returns true if the backup contains the file at rel.
+func (b *Backup) Contains(rel string) bool {
+	return slices.Contains(b.saved, rel)
+}
+
+// Restore copies the saved content of the file at rel back into the destination.
+func (b *Backup) Restore(rel string) error {
+	if !b.Contains(rel) {
+		return fmt.Errorf("backup %s doesn't contain %s", b.ID, rel)
+	}
+	dst := filepath.Join(b.destinationDir, filepath.FromSlash(rel))
+	if info, err := os.Lstat(dst); err == nil && info.IsDir() {
+		return fmt.Errorf("%s is a directory in the destination", rel)
+	}
+	if err := internal.CopyPath(filepath.Join(b.dir, filepath.FromSlash(rel)), dst); err != nil {
+		return fmt.Errorf("restore %s: %w", rel, err)
+	}
+	return nil
+}
+
+// Backups returns all backups of the destination, oldest first.
+func (s *Store) Backups() ([]*Backup, error) {
+	dir := filepath.Join(s.root, backupsDirName)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read backups: %w", err)
+	}
+
+	var backups []*Backup
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		t, err := time.Parse(backupTimeFormat, entry.Name())
+		if err != nil {
+			if t, err = time.Parse(legacyBackupTimeFormat, entry.Name()); err != nil {
+				continue
+			}
+		}
+		b := s.newBackup(entry.Name(), t)
+		b.claimed = true
+		err = filepath.WalkDir(b.dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(b.dir, path)
+			if err != nil {
+				return err
+			}
+			b.saved = append(b.saved, filepath.ToSlash(rel))
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("read backup %s: %w", b.ID, err)
+		}
+		backups = append(backups, b)
+	}
+	// legacy names don't sort chronologically with the current ones
+	slices.SortStableFunc(backups, func(a, b *Backup) int {
+		return a.Time.Compare(b.Time)
+	})
+	return backups, nil
+}
+
+// LatestBackup returns the most recent backup containing the file at rel, or nil if there is none.
+func (s *Store) LatestBackup(rel string) (*Backup, error) {
+	backups, err := s.Backups()
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range slices.Backward(backups) {
+		if b.Contains(rel) {
+			return b, nil
+		}
+	}
+	return nil, nil
+}
+
+// FindBackup returns the backup with the given ID.
+func (s *Store) FindBackup(id string) (*Backup, error) {
+	backups, err := s.Backups()
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range backups {
+		if b.ID == id {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("no backup %s found in %s", id, s.destinationDir)
+}
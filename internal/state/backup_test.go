@@ -0,0 +1,91 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackups(t *testing.T) {
+	dest := t.TempDir()
+	store := New(dest)
+	p := filepath.Join(dest, "server.properties")
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// two applies, each replacing a locally edited file
+	require.NoError(t, os.WriteFile(p, []byte("motd=first"), 0o600))
+	first := store.NewBackup(start)
+	require.NoError(t, first.Save("server.properties"))
+	require.NoError(t, os.WriteFile(p, []byte("motd=second"), 0o600))
+	second := store.NewBackup(start.Add(time.Hour))
+	require.NoError(t, second.Save("server.properties"))
+	require.NoError(t, os.WriteFile(filepath.Join(dest, "ops.json"), []byte("[]"), 0o644))
+	require.NoError(t, second.Save("ops.json"))
+	require.NoError(t, os.WriteFile(p, []byte("motd=desired"), 0o644))
+
+	backups, err := store.Backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+	assert.Equal(t, "20250101T120000.000Z", backups[0].ID)
+	assert.Equal(t, start, backups[0].Time)
+	assert.Equal(t, []string{"ops.json", "server.properties"}, backups[1].Saved())
+
+	latest, err := store.LatestBackup("server.properties")
+	require.NoError(t, err)
+	require.NoError(t, latest.Restore("server.properties"))
+	content, err := os.ReadFile(p)
+	require.NoError(t, err)
+	assert.Equal(t, "motd=second", string(content))
+	info, err := os.Stat(p)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm(), "the mode must be restored")
+
+	older, err := store.FindBackup("20250101T120000.000Z")
+	require.NoError(t, err)
+	require.NoError(t, older.Restore("server.properties"))
+	content, err = os.ReadFile(p)
+	require.NoError(t, err)
+	assert.Equal(t, "motd=first", string(content))
+	assert.Error(t, older.Restore("ops.json"), "files not contained in the backup can't be restored")
+
+	none, err := store.LatestBackup("bukkit.yml")
+	require.NoError(t, err)
+	assert.Nil(t, none)
+}
+
+func TestBackupsAtTheSameTime(t *testing.T) {
+	dest := t.TempDir()
+	store := New(dest)
+	p := filepath.Join(dest, "server.properties")
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// two applies within the same millisecond
+	require.NoError(t, os.WriteFile(p, []byte("motd=first"), 0o644))
+	first := store.NewBackup(now)
+	second := store.NewBackup(now)
+	require.NoError(t, first.Save("server.properties"))
+	require.NoError(t, os.WriteFile(p, []byte("motd=second"), 0o644))
+	require.NoError(t, second.Save("server.properties"))
+	assert.NotEqual(t, first.ID, second.ID)
+
+	// a backup of an older version named with second precision
+	legacy := filepath.Join(store.Root(), backupsDirName, "20250101T110000Z")
+	require.NoError(t, os.MkdirAll(legacy, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(legacy, "server.properties"), []byte("motd=legacy"), 0o644))
+
+	backups, err := store.Backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 3)
+	assert.Equal(t, "20250101T110000Z", backups[0].ID)
+	assert.Equal(t, first.ID, backups[1].ID)
+	assert.Equal(t, second.ID, backups[2].ID)
+
+	require.NoError(t, backups[1].Restore("server.properties"))
+	content, err := os.ReadFile(p)
+	require.NoError(t, err)
+	assert.Equal(t, "motd=first", string(content), "the first backup must not be overwritten")
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package state
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockGuard takes an exclusive OS lock of the file at path (created if necessary), blocking until it's available.
+// The lock is released by the returned function, or by the OS if the process exits.
+func lockGuard(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open guard %q: %w", path, err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("lock guard %q: %w", path, err)
+	}
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		_ = f.Close()
+	}, nil
+}
@@ -0,0 +1,40 @@
+//go:build windows
+
+package state
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+const (
+	// guardTimeout is the maximum time to wait for a guard held by another process
+	guardTimeout = 10 * time.Second
+
+	// errorSharingViolation is returned by CreateFile if the file is opened by another process
+	errorSharingViolation syscall.Errno = 32
+)
+
+// lockGuard takes an exclusive OS lock of the file at path (created if necessary), blocking until it's available.
+// The lock is released by the returned function, or by the OS if the process exits.
+func lockGuard(path string) (func(), error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, fmt.Errorf("guard path %q: %w", path, err)
+	}
+	deadline := time.Now().Add(guardTimeout)
+	for {
+		// opening the file without sharing it makes the handle exclusive
+		h, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+			syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+		if err == nil {
+			return func() { _ = syscall.CloseHandle(h) }, nil
+		}
+		if !errors.Is(err, errorSharingViolation) || !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("lock guard %q: %w", path, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,218 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	applyLockFileName = "apply.lock"
+	// takeoverGuardFileName is locked by the OS while a stale lock is taken over, so only one process removes it
+	takeoverGuardFileName = "apply.lock.guard"
+
+	// lockPollInterval is the time between attempts to acquire a held lock
+	lockPollInterval = 500 * time.Millisecond
+	// lockGracePeriod is the age after which an empty or unreadable lock is considered stale,
+	// e.g. if its holder crashed between creating and writing it
+	lockGracePeriod = time.Minute
+)
+
+// LockInfo describes the process holding the lock of a destination.
+type LockInfo struct {
+	PID  int       `json:"pid"`
+	Host string    `json:"host"`
+	Time time.Time `json:"time"`
+
+	// raw is the content of the lock file, used to check if the lock changed
+	raw []byte
+	// invalid is true if the lock file could not be parsed, Time is its modification time then
+	invalid bool
+}
+
+// String returns a human-friendly description of the lock holder.
+func (i *LockInfo) String() string {
+	return fmt.Sprintf("pid %d on %s since %s", i.PID, i.Host, i.Time.Local().Format(time.DateTime))
+}
+
+// stale returns true if the lock was taken by a process on this host which no longer exists.
+// Locks of other hosts are never considered stale, as their processes can't be checked.
+// Empty or unreadable locks are stale once they are older than lockGracePeriod.
+func (i *LockInfo) stale() bool {
+	if i.invalid {
+		return time.Since(i.Time) > lockGracePeriod
+	}
+	host, err := os.Hostname()
+	if err != nil || host != i.Host {
+		return false
+	}
+	return !processAlive(i.PID)
+}
+
+// LockedError is returned by Lock if the destination is locked by another process.
+type LockedError struct {
+	Holder *LockInfo
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("destination is locked by another gok process (%s)", e.Holder)
+}
+
+// Lock is an exclusive lock of a destination, held while the destination is changed.
+type Lock struct {
+	path string
+	// stateDir is removed on unlock if it's empty, i.e. it was only created for the lock
+	stateDir string
+}
+
+// Lock takes the exclusive lock of the destination. If the lock is held by another process, it's retried until
+// the timeout expires (no retries if the timeout is 0), then a *LockedError is returned.
+// Stale locks of processes which no longer exist are taken over.
+func (s *Store) Lock(ctx context.Context, timeout time.Duration) (*Lock, error) {
+	path := filepath.Join(s.root, applyLockFileName)
+
+	deadline := time.Now().Add(timeout)
+	for {
+		// the state directory is removed when the previous holder releases the lock, if it's empty
+		if err := os.MkdirAll(s.root, 0o755); err != nil {
+			return nil, fmt.Errorf("create state directory: %w", err)
+		}
+		err := createLock(path)
+		if err == nil {
+			return &Lock{path: path, stateDir: s.root}, nil
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("create lock %q: %w", path, err)
+		}
+
+		holder, err := readLock(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			// released in the meantime
+			continue
+		case err != nil:
+			return nil, err
+		case holder.stale():
+			if err := s.removeStaleLock(path, holder); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if !time.Now().Before(deadline) {
+			return nil, &LockedError{Holder: holder}
+		}
+		log.Debug().Msgf("waiting for lock of %s (%s)", s.destinationDir, holder)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// removeStaleLock removes the lock at path if it's still held by the stale holder.
+// Other processes might take over the same stale lock at the same time: the removal happens while holding the
+// takeover guard, so a lock which was taken over by another process in the meantime is never removed.
+func (s *Store) removeStaleLock(path string, stale *LockInfo) error {
+	release, err := lockGuard(filepath.Join(s.root, takeoverGuardFileName))
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	current, err := readLock(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(current.raw, stale.raw) || !current.Time.Equal(stale.Time) {
+		// taken over by another process
+		return nil
+	}
+
+	log.Warn().Msgf("removing stale lock of %s (%s)", s.destinationDir, stale)
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove stale lock %q: %w", path, err)
+	}
+	return nil
+}
+
+// LockHolder returns the process holding the lock of the destination, or nil if it's not locked.
+// Stale locks are not reported.
+func (s *Store) LockHolder() (*LockInfo, error) {
+	holder, err := readLock(filepath.Join(s.root, applyLockFileName))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if holder.stale() {
+		return nil, nil
+	}
+	return holder, nil
+}
+
+// Unlock releases the lock.
+func (l *Lock) Unlock() error {
+	if err := os.Remove(l.path); err != nil {
+		return fmt.Errorf("remove lock %q: %w", l.path, err)
+	}
+	// fails if the state directory contains anything else (e.g. the takeover guard), which is fine
+	_ = os.Remove(l.stateDir)
+	return nil
+}
+
+func createLock(path string) error {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	data, err := json.Marshal(&LockInfo{PID: os.Getpid(), Host: host, Time: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		_ = os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+func readLock(path string) (*LockInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	info := LockInfo{raw: data}
+	if err := json.Unmarshal(data, &info); err != nil {
+		// the lock might be read while it's written, it's considered held by an unknown process
+		// until it's older than the grace period
+		stat, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		return &LockInfo{Host: "unknown host", Time: stat.ModTime(), raw: data, invalid: true}, nil
+	}
+	return &info, nil
+}
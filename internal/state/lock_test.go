@@ -0,0 +1,201 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sap-gg/gok/internal"
+)
+
+func TestLock(t *testing.T) {
+	dest := t.TempDir()
+	store := New(dest)
+
+	lock, err := store.Lock(context.Background(), 0)
+	require.NoError(t, err)
+
+	holder, err := store.LockHolder()
+	require.NoError(t, err)
+	require.NotNil(t, holder)
+	assert.Equal(t, os.Getpid(), holder.PID)
+
+	_, err = store.Lock(context.Background(), 0)
+	var lockedErr *LockedError
+	require.True(t, errors.As(err, &lockedErr), "a held lock must not be taken twice")
+	assert.Equal(t, os.Getpid(), lockedErr.Holder.PID)
+
+	require.NoError(t, lock.Unlock())
+	holder, err = store.LockHolder()
+	require.NoError(t, err)
+	assert.Nil(t, holder)
+	assert.NoDirExists(t, filepath.Join(dest, internal.StateDirName), "a state directory only created for the lock must be removed")
+}
+
+func TestLockWaits(t *testing.T) {
+	store := New(t.TempDir())
+	lock, err := store.Lock(context.Background(), 0)
+	require.NoError(t, err)
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		_ = lock.Unlock()
+	}()
+	second, err := store.Lock(context.Background(), 10*time.Second)
+	require.NoError(t, err, "the lock must be taken once it's released")
+	require.NoError(t, second.Unlock())
+
+	lock, err = store.Lock(context.Background(), 0)
+	require.NoError(t, err)
+	defer lock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = store.Lock(ctx, time.Hour)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestLockStale(t *testing.T) {
+	// the pid of a process which has exited
+	cmd := exec.Command("go", "version")
+	require.NoError(t, cmd.Run())
+	deadPID := cmd.Process.Pid
+
+	host, err := os.Hostname()
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name  string
+		info  LockInfo
+		stale bool
+	}{
+		{name: "dead process on this host", info: LockInfo{PID: deadPID, Host: host}, stale: true},
+		{name: "dead process on another host", info: LockInfo{PID: deadPID, Host: "other-" + host}, stale: false},
+		{name: "running process on this host", info: LockInfo{PID: os.Getpid(), Host: host}, stale: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := New(t.TempDir())
+			require.NoError(t, os.MkdirAll(store.Root(), 0o755))
+			data, err := json.Marshal(&tc.info)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(filepath.Join(store.Root(), applyLockFileName), data, 0o644))
+
+			holder, err := store.LockHolder()
+			require.NoError(t, err)
+			assert.Equal(t, tc.stale, holder == nil)
+
+			lock, err := store.Lock(context.Background(), 0)
+			if tc.stale {
+				require.NoError(t, err, "stale locks must be taken over")
+				require.NoError(t, lock.Unlock())
+			} else {
+				var lockedErr *LockedError
+				assert.True(t, errors.As(err, &lockedErr))
+			}
+		})
+	}
+}
+
+func TestLockConcurrentTakeover(t *testing.T) {
+	// the pid of a process which has exited
+	cmd := exec.Command("go", "version")
+	require.NoError(t, cmd.Run())
+	deadPID := cmd.Process.Pid
+
+	host, err := os.Hostname()
+	require.NoError(t, err)
+	data, err := json.Marshal(&LockInfo{PID: deadPID, Host: host})
+	require.NoError(t, err)
+
+	t.Run("late takeover", func(t *testing.T) {
+		store := New(t.TempDir())
+		require.NoError(t, os.MkdirAll(store.Root(), 0o755))
+		path := filepath.Join(store.Root(), applyLockFileName)
+		require.NoError(t, os.WriteFile(path, data, 0o644))
+
+		// both processes read the stale holder, then the first one takes over
+		stale, err := readLock(path)
+		require.NoError(t, err)
+		require.True(t, stale.stale())
+		lock, err := store.Lock(context.Background(), 0)
+		require.NoError(t, err)
+		defer lock.Unlock()
+
+		require.NoError(t, store.removeStaleLock(path, stale))
+		holder, err := store.LockHolder()
+		require.NoError(t, err)
+		require.NotNil(t, holder, "a lock taken over in the meantime must not be removed")
+		assert.Equal(t, os.Getpid(), holder.PID)
+	})
+
+	for range 20 {
+		store := New(t.TempDir())
+		require.NoError(t, os.MkdirAll(store.Root(), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(store.Root(), applyLockFileName), data, 0o644))
+
+		var (
+			wg    sync.WaitGroup
+			mu    sync.Mutex
+			locks []*Lock
+		)
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				lock, err := store.Lock(context.Background(), 0)
+				if err != nil {
+					var lockedErr *LockedError
+					assert.True(t, errors.As(err, &lockedErr), "unexpected error: %v", err)
+					return
+				}
+				mu.Lock()
+				locks = append(locks, lock)
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+
+		require.Len(t, locks, 1, "a stale lock must only be taken over once")
+		require.NoError(t, locks[0].Unlock())
+	}
+}
+
+func TestLockInvalid(t *testing.T) {
+	testCases := []struct {
+		name  string
+		age   time.Duration
+		stale bool
+	}{
+		{name: "fresh", age: 0, stale: false},
+		{name: "older than grace period", age: 2 * lockGracePeriod, stale: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := New(t.TempDir())
+			require.NoError(t, os.MkdirAll(store.Root(), 0o755))
+			path := filepath.Join(store.Root(), applyLockFileName)
+			// e.g. the holder crashed between creating and writing the lock
+			require.NoError(t, os.WriteFile(path, nil, 0o644))
+			modTime := time.Now().Add(-tc.age)
+			require.NoError(t, os.Chtimes(path, modTime, modTime))
+
+			lock, err := store.Lock(context.Background(), 0)
+			if tc.stale {
+				require.NoError(t, err, "empty locks older than the grace period must be taken over")
+				require.NoError(t, lock.Unlock())
+			} else {
+				var lockedErr *LockedError
+				assert.True(t, errors.As(err, &lockedErr))
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package state
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileOwner returns the uid and gid of the file, or -1 if they are not available.
+func fileOwner(info fs.FileInfo) (uid, gid int) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid), int(stat.Gid)
+	}
+	return -1, -1
+}
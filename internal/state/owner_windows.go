@@ -0,0 +1,10 @@
+//go:build windows
+
+package state
+
+import "io/fs"
+
+// fileOwner returns -1 for both values, as Windows has no numeric file ownership.
+func fileOwner(fs.FileInfo) (uid, gid int) {
+	return -1, -1
+}
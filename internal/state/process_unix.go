@@ -0,0 +1,18 @@
+//go:build !windows
+
+package state
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processAlive returns true if a process with the pid exists.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	// EPERM: the process exists, but belongs to another user
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package state
+
+import "os"
+
+// processAlive returns true if a process with the pid exists.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	// on Windows, FindProcess fails if the process doesn't exist
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = p.Release()
+	return true
+}
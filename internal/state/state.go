@@ -0,0 +1,131 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sap-gg/gok/internal"
+	"github.com/sap-gg/gok/internal/glob"
+	"github.com/sap-gg/gok/internal/lockfile"
+	"github.com/sap-gg/gok/internal/merge"
+)
+
+const baseDirName = "base"
+
+// Store manages the state directory (internal.StateDirName) inside a destination directory.
+type Store struct {
+	destinationDir string
+	// root is the path to the state directory
+	root string
+}
+
+// New creates a Store for the given destination directory.
+func New(destinationDir string) *Store {
+	return &Store{
+		destinationDir: destinationDir,
+		root:           filepath.Join(destinationDir, internal.StateDirName),
+	}
+}
+
+// Root returns the path to the state directory.
+func (s *Store) Root() string {
+	return s.root
+}
+
+// BasePath returns the path where the last-applied content of the file at rel is kept.
+func (s *Store) BasePath(rel string) string {
+	return filepath.Join(s.root, baseDirName, filepath.FromSlash(rel))
+}
+
+// ReadBase returns the last-applied content of the file at rel.
+// If no content was recorded, an error wrapping fs.ErrNotExist is returned.
+func (s *Store) ReadBase(rel string) ([]byte, error) {
+	return os.ReadFile(s.BasePath(rel))
+}
+
+// RecordBase records the content of the file at src as the last-applied content of the file at rel.
+// The recorded content is only accessible by the owner, files may contain secrets.
+func (s *Store) RecordBase(rel, src string) error {
+	baseDir := filepath.Join(s.root, baseDirName)
+	if err := os.MkdirAll(baseDir, 0o700); err != nil {
+		return fmt.Errorf("create base directory: %w", err)
+	}
+	// base directories of older versions were accessible by everyone
+	if err := os.Chmod(baseDir, 0o700); err != nil {
+		return fmt.Errorf("restrict base directory: %w", err)
+	}
+	if err := copyFile(src, s.BasePath(rel)); err != nil {
+		return fmt.Errorf("record base of %q: %w", rel, err)
+	}
+	return nil
+}
+
+// SyncBase records the content of all structured files of the lock from desiredDir as their last-applied content
+// and removes recorded content of files which are no longer part of the lock.
+// Only paths selected by the filter are changed (all paths if it's nil).
+func (s *Store) SyncBase(desiredDir string, lock *lockfile.LockFile, filter *glob.Filter) error {
+	for path, entry := range lock.Files {
+		if _, ok := merge.FormatFor(path); !ok || entry.Type != lockfile.TypeFile || !filter.Match(path) {
+			continue
+		}
+		if err := s.RecordBase(path, filepath.Join(desiredDir, filepath.FromSlash(path))); err != nil {
+			return err
+		}
+	}
+
+	baseDir := filepath.Join(s.root, baseDirName)
+	err := filepath.WalkDir(baseDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(baseDir, path)
+		if err != nil {
+			return fmt.Errorf("determining relative path: %w", err)
+		}
+		if _, ok := lock.Files[filepath.ToSlash(rel)]; ok || !filter.Match(filepath.ToSlash(rel)) {
+			return nil
+		}
+		log.Debug().Str("path", rel).Msg("removing stale base content")
+		return os.Remove(path)
+	})
+	if err != nil {
+		return fmt.Errorf("pruning base content: %w", err)
+	}
+	return nil
+}
+
+func copyFile(srcPath, dstPath string) error {
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0o700); err != nil {
+		return fmt.Errorf("create parent directories for %q: %w", dstPath, err)
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("open source file %q: %w", srcPath, err)
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("create destination file %q: %w", dstPath, err)
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return err
+	}
+	// the mode is only used by OpenFile if the file didn't exist yet
+	return dst.Chmod(0o600)
+}
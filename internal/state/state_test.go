@@ -0,0 +1,39 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordBase(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits are not supported on windows")
+	}
+	dest := t.TempDir()
+	store := New(dest)
+	src := filepath.Join(t.TempDir(), "secrets.yml")
+	require.NoError(t, os.WriteFile(src, []byte("password: hunter2\n"), 0o600))
+
+	// base content recorded by older versions was accessible by everyone
+	stale := store.BasePath("plugins/Secret/secrets.yml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(stale), 0o755))
+	require.NoError(t, os.WriteFile(stale, []byte("old"), 0o644))
+
+	require.NoError(t, store.RecordBase("plugins/Secret/secrets.yml", src))
+
+	content, err := store.ReadBase("plugins/Secret/secrets.yml")
+	require.NoError(t, err)
+	assert.Equal(t, "password: hunter2\n", string(content))
+
+	info, err := os.Stat(stale)
+	require.NoError(t, err)
+	assert.Zero(t, info.Mode().Perm()&0o077, "base content must not be accessible by group or others")
+	info, err = os.Stat(filepath.Join(store.Root(), baseDirName))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+}
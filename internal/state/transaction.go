@@ -0,0 +1,585 @@
+package state
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/sap-gg/gok/internal"
+)
+
+const (
+	transactionDirName = "transaction"
+	journalFileName    = "journal.jsonl"
+	// originalDirName keeps copies of the original paths, desiredDirName the staged desired content
+	originalDirName = "original"
+	desiredDirName  = "desired"
+)
+
+// Transaction records the original state of destination paths before they are changed,
+// so that all changes can be rolled back if applying fails.
+//
+// All steps are recorded in a journal inside the state directory, so that a transaction interrupted
+// by a crash can be rolled back or forward later (see Store.Resume).
+type Transaction struct {
+	destinationDir string
+	// stateDir is removed on rollback if it didn't exist before the transaction
+	stateDir        string
+	stateDirExisted bool
+	// dir contains the journal, the originals and the staged desired content
+	dir       string
+	journal   *os.File
+	startedAt time.Time
+
+	operations []*Operation
+	records    []*transactionRecord
+	tracked    map[string]struct{}
+	done       map[string]struct{}
+	backup     *Backup
+}
+
+type transactionRecord struct {
+	Rel string `json:"path"`
+	// Existed is false if the path didn't exist before, i.e. it's removed on rollback
+	Existed bool `json:"existed,omitempty"`
+	// Dir is true if the path was a directory before
+	Dir bool `json:"dir,omitempty"`
+	// UID and GID are the original ownership, -1 if unknown
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+}
+
+// journalEntry is a single line of the journal, exactly one field is set.
+type journalEntry struct {
+	Begin  *journalBegin      `json:"begin,omitempty"`
+	Intent []*Operation       `json:"intent,omitempty"`
+	Track  *transactionRecord `json:"track,omitempty"`
+	Done   string             `json:"done,omitempty"`
+}
+
+type journalBegin struct {
+	StartedAt       time.Time `json:"startedAt"`
+	StateDirExisted bool      `json:"stateDirExisted"`
+}
+
+// Operation is a change of a single destination path, executed by Transaction.Execute.
+type Operation struct {
+	Path string `json:"path"`
+	// Remove is true if the path is removed, otherwise it's replaced with the staged desired content.
+	Remove bool `json:"remove,omitempty"`
+	// Backup is true if the current content is saved to a backup before it's changed.
+	Backup bool `json:"backup,omitempty"`
+	// From is the path which is renamed to Path, if set.
+	From string `json:"from,omitempty"`
+
+	// source is the path of the desired content (or only its mode if content is set), used for staging
+	source  string
+	content []byte
+}
+
+// CopyOperation replaces the destination path with a copy of the file, symlink or directory at source.
+func CopyOperation(path, source string) *Operation {
+	return &Operation{Path: path, source: source}
+}
+
+// ContentOperation replaces the destination path with the content, using the mode of the file at
+// modeSource (0644 if empty).
+func ContentOperation(path string, content []byte, modeSource string) *Operation {
+	return &Operation{Path: path, source: modeSource, content: content}
+}
+
+// RenameOperation renames the destination path from to path. The desired content at source is only used
+// if from no longer exists when the operation is executed.
+func RenameOperation(from, path, source string) *Operation {
+	return &Operation{Path: path, From: from, source: source}
+}
+
+// RemoveOperation removes the destination path.
+func RemoveOperation(path string) *Operation {
+	return &Operation{Path: path, Remove: true}
+}
+
+// InterruptedError is returned by Begin if the destination contains an interrupted transaction.
+type InterruptedError struct {
+	StartedAt time.Time
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("an apply started at %s was interrupted, run 'gok recover' to roll it forward or back",
+		e.StartedAt.Local().Format(time.DateTime))
+}
+
+// Begin starts a new Transaction for the destination. A leftover transaction which didn't change anything
+// is discarded, otherwise an *InterruptedError is returned.
+func (s *Store) Begin() (*Transaction, error) {
+	leftover, err := s.Interrupted()
+	if err != nil {
+		return nil, err
+	}
+	if leftover != nil {
+		return nil, &InterruptedError{StartedAt: leftover.StartedAt}
+	}
+
+	dir := filepath.Join(s.root, transactionDirName)
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("remove leftover transaction %q: %w", dir, err)
+	}
+	existed, err := hasState(s.root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create transaction directory: %w", err)
+	}
+	journal, err := os.OpenFile(filepath.Join(dir, journalFileName), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("create journal: %w", err)
+	}
+
+	t := &Transaction{
+		destinationDir:  s.destinationDir,
+		stateDir:        s.root,
+		stateDirExisted: existed,
+		dir:             dir,
+		journal:         journal,
+		startedAt:       time.Now().UTC(),
+		tracked:         make(map[string]struct{}),
+		done:            make(map[string]struct{}),
+		backup:          s.NewBackup(time.Now()),
+	}
+	if err := t.appendJournal(&journalEntry{Begin: &journalBegin{
+		StartedAt:       t.startedAt,
+		StateDirExisted: existed,
+	}}); err != nil {
+		journal.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// Backup returns the backup of files replaced by operations with Backup set.
+func (t *Transaction) Backup() *Backup {
+	return t.backup
+}
+
+// Intend stages the desired content of all operations inside the state directory and records them in the journal,
+// so they can be executed (or finished after a crash) without the original desired state.
+// It must be called before the operations are executed.
+func (t *Transaction) Intend(operations []*Operation) error {
+	for _, op := range operations {
+		if op.Remove {
+			continue
+		}
+		if err := op.stage(t.stagedPath(op.Path)); err != nil {
+			return fmt.Errorf("stage %q: %w", op.Path, err)
+		}
+	}
+	t.operations = append(t.operations, operations...)
+	return t.appendJournal(&journalEntry{Intent: operations})
+}
+
+func (op *Operation) stage(dst string) error {
+	if op.content == nil {
+		if err := internal.CopyPath(op.source, dst); err != nil {
+			return err
+		}
+		return syncFile(dst)
+	}
+
+	mode := fs.FileMode(0o644)
+	if op.source != "" {
+		info, err := os.Stat(op.source)
+		if err != nil {
+			return err
+		}
+		mode = info.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(dst, op.content, mode); err != nil {
+		return err
+	}
+	return syncFile(dst)
+}
+
+// Execute tracks the path of the intended operation and applies it to the destination.
+func (t *Transaction) Execute(op *Operation) error {
+	if err := t.Track(op.Path); err != nil {
+		return err
+	}
+	if op.From != "" {
+		if err := t.Track(op.From); err != nil {
+			return err
+		}
+	}
+	dst := filepath.Join(t.destinationDir, filepath.FromSlash(op.Path))
+	if op.Backup {
+		if _, err := os.Lstat(dst); err == nil {
+			if err := t.backup.Save(op.Path); err != nil {
+				return fmt.Errorf("failed to backup %s: %w", op.Path, err)
+			}
+		}
+	}
+
+	switch {
+	case op.Remove:
+		if err := removePath(op.Path, dst); err != nil {
+			return err
+		}
+	case op.From != "" && t.exists(op.From):
+		log.Info().Str("path", op.Path).Str("from", op.From).Msg("rename")
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return fmt.Errorf("failed to rename %s: %w", op.From, err)
+		}
+		if err := os.Rename(filepath.Join(t.destinationDir, filepath.FromSlash(op.From)), dst); err != nil {
+			return fmt.Errorf("failed to rename %s: %w", op.From, err)
+		}
+	default:
+		log.Info().Str("path", op.Path).Msg("copy/update")
+		if err := internal.CopyPath(t.stagedPath(op.Path), dst); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", op.Path, err)
+		}
+	}
+
+	t.done[op.Path] = struct{}{}
+	return t.appendJournal(&journalEntry{Done: op.Path})
+}
+
+// exists returns true if the destination path at rel exists.
+func (t *Transaction) exists(rel string) bool {
+	_, err := os.Lstat(filepath.Join(t.destinationDir, filepath.FromSlash(rel)))
+	return err == nil
+}
+
+// removePath removes the destination path at dst. Missing paths and non-empty directories are only logged.
+func removePath(rel, dst string) error {
+	log.Info().Str("path", rel).Msg("remove")
+	if err := os.Remove(dst); err != nil {
+		if os.IsNotExist(err) {
+			log.Warn().Msgf("file %s already removed", rel)
+			return nil
+		}
+		if info, statErr := os.Lstat(dst); statErr == nil && info.IsDir() {
+			log.Warn().Msgf("directory %s is not empty, keeping it", rel)
+			return nil
+		}
+		return fmt.Errorf("failed to remove %s: %w", rel, err)
+	}
+	return nil
+}
+
+// Track records the original state of the destination path at rel. It must be called before the path is changed.
+// Tracking the same path multiple times only records the first (original) state.
+func (t *Transaction) Track(rel string) error {
+	if _, ok := t.tracked[rel]; ok {
+		return nil
+	}
+
+	record := &transactionRecord{Rel: rel, UID: -1, GID: -1}
+	src := filepath.Join(t.destinationDir, filepath.FromSlash(rel))
+	info, err := os.Lstat(src)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// nothing to keep, the path is removed on rollback
+	case err != nil:
+		return fmt.Errorf("stat %q: %w", src, err)
+	case info.IsDir():
+		// only empty directories are managed, so there's no content to keep
+		record.Existed, record.Dir = true, true
+	default:
+		original := t.originalPath(rel)
+		if err := internal.CopyPath(src, original); err != nil {
+			return fmt.Errorf("keep original of %q: %w", rel, err)
+		}
+		if err := syncFile(original); err != nil {
+			return fmt.Errorf("keep original of %q: %w", rel, err)
+		}
+		record.Existed = true
+		record.UID, record.GID = fileOwner(info)
+	}
+
+	// the record is only written once the original is kept, a crash before leaves the path unchanged
+	if err := t.appendJournal(&journalEntry{Track: record}); err != nil {
+		return err
+	}
+	t.tracked[rel] = struct{}{}
+	t.records = append(t.records, record)
+	return nil
+}
+
+// Rollback restores the original state of all tracked paths in reverse order and ends the transaction.
+// It continues on errors and returns all of them combined.
+func (t *Transaction) Rollback() error {
+	var combined error
+	for _, record := range slices.Backward(t.records) {
+		if err := t.restore(record); err != nil {
+			combined = errors.Join(combined, fmt.Errorf("restore %q: %w", record.Rel, err))
+		}
+	}
+	if combined != nil {
+		log.Error().Msgf("rollback incomplete, original files are kept in %s", t.dir)
+		return combined
+	}
+	t.closeJournal()
+	if !t.stateDirExisted {
+		return removeState(t.stateDir)
+	}
+	return os.RemoveAll(t.dir)
+}
+
+// RollForward executes all intended operations which were not done yet and ends the transaction.
+// It returns the paths of all intended operations which were not removed.
+func (t *Transaction) RollForward() ([]string, error) {
+	if len(t.operations) == 0 && len(t.records) > 0 {
+		return nil, fmt.Errorf("the journal contains no intended operations, it can only be rolled back")
+	}
+	var written []string
+	for _, op := range t.operations {
+		if !op.Remove {
+			written = append(written, op.Path)
+		}
+		if _, ok := t.done[op.Path]; ok {
+			continue
+		}
+		if err := t.Execute(op); err != nil {
+			return nil, err
+		}
+	}
+	return written, t.Commit()
+}
+
+func (t *Transaction) restore(record *transactionRecord) error {
+	dst := filepath.Join(t.destinationDir, filepath.FromSlash(record.Rel))
+
+	info, err := os.Lstat(dst)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		if info.IsDir() && record.Dir {
+			return nil // directories are only created or removed, never changed
+		}
+		if err := os.Remove(dst); err != nil {
+			if info.IsDir() {
+				log.Warn().Err(err).Msgf("keeping directory %s created during apply", record.Rel)
+				return nil
+			}
+			return err
+		}
+	}
+
+	switch {
+	case !record.Existed:
+		return nil
+	case record.Dir:
+		return os.MkdirAll(dst, 0o755)
+	}
+
+	if err := internal.CopyPath(t.originalPath(record.Rel), dst); err != nil {
+		return err
+	}
+	if record.UID >= 0 || record.GID >= 0 {
+		if err := os.Lchown(dst, record.UID, record.GID); err != nil {
+			log.Debug().Err(err).Msgf("cannot restore ownership of %s", record.Rel)
+		}
+	}
+	log.Debug().Msgf("restored %s", record.Rel)
+	return nil
+}
+
+// Commit ends the transaction and discards the original state.
+func (t *Transaction) Commit() error {
+	t.closeJournal()
+	if err := os.RemoveAll(t.dir); err != nil {
+		return fmt.Errorf("remove transaction %q: %w", t.dir, err)
+	}
+	return nil
+}
+
+func (t *Transaction) originalPath(rel string) string {
+	return filepath.Join(t.dir, originalDirName, filepath.FromSlash(rel))
+}
+
+func (t *Transaction) stagedPath(rel string) string {
+	return filepath.Join(t.dir, desiredDirName, filepath.FromSlash(rel))
+}
+
+// appendJournal writes the entry as a single line to the journal and flushes it to disk.
+func (t *Transaction) appendJournal(entry *journalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encode journal entry: %w", err)
+	}
+	if _, err := t.journal.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	if err := t.journal.Sync(); err != nil {
+		return fmt.Errorf("sync journal: %w", err)
+	}
+	return nil
+}
+
+func (t *Transaction) closeJournal() {
+	if t.journal != nil {
+		_ = t.journal.Close()
+		t.journal = nil
+	}
+}
+
+// Journal describes an interrupted transaction.
+type Journal struct {
+	StartedAt time.Time
+	// Operations are the intended operations, Done the paths of the ones which were completed
+	Operations []*Operation
+	Done       []string
+	// Changed are the paths which were (possibly) changed
+	Changed []string
+}
+
+// Interrupted returns the journal of an interrupted transaction in the destination,
+// or nil if there is none or it didn't change anything.
+func (s *Store) Interrupted() (*Journal, error) {
+	t, err := s.load()
+	if err != nil || t == nil {
+		return nil, err
+	}
+	t.closeJournal()
+	if len(t.records) == 0 {
+		return nil, nil
+	}
+
+	j := &Journal{StartedAt: t.startedAt, Operations: t.operations}
+	for _, record := range t.records {
+		j.Changed = append(j.Changed, record.Rel)
+	}
+	for _, op := range t.operations {
+		if _, ok := t.done[op.Path]; ok {
+			j.Done = append(j.Done, op.Path)
+		}
+	}
+	return j, nil
+}
+
+// Resume continues an interrupted transaction, which can then be rolled back or forward.
+func (s *Store) Resume() (*Transaction, error) {
+	t, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, fmt.Errorf("no interrupted apply found in %s", s.destinationDir)
+	}
+	t.backup = s.NewBackup(time.Now())
+	return t, nil
+}
+
+// load reads the journal of a leftover transaction and opens it for appending, or returns nil if there is none.
+func (s *Store) load() (*Transaction, error) {
+	dir := filepath.Join(s.root, transactionDirName)
+	path := filepath.Join(dir, journalFileName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open journal: %w", err)
+	}
+
+	t := &Transaction{
+		destinationDir: s.destinationDir,
+		stateDir:       s.root,
+		dir:            dir,
+		journal:        f,
+		tracked:        make(map[string]struct{}),
+		done:           make(map[string]struct{}),
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// the last line might be incomplete if writing it was interrupted
+			log.Debug().Err(err).Msg("skipping invalid journal entry")
+			continue
+		}
+		switch {
+		case entry.Begin != nil:
+			t.startedAt = entry.Begin.StartedAt
+			t.stateDirExisted = entry.Begin.StateDirExisted
+		case entry.Intent != nil:
+			t.operations = append(t.operations, entry.Intent...)
+		case entry.Track != nil:
+			t.tracked[entry.Track.Rel] = struct{}{}
+			t.records = append(t.records, entry.Track)
+		case entry.Done != "":
+			t.done[entry.Done] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("read journal: %w", err)
+	}
+	return t, nil
+}
+
+// syncFile flushes the regular file at path to disk.
+func syncFile(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// hasState returns true if the state directory contains anything besides the apply lock.
+func hasState(stateDir string) (bool, error) {
+	entries, err := os.ReadDir(stateDir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("read state directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != applyLockFileName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// removeState removes the state directory, except for the apply lock (which is removed when it's released).
+func removeState(stateDir string) error {
+	entries, err := os.ReadDir(stateDir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == applyLockFileName {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(stateDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	// fails if the directory still contains the lock
+	_ = os.Remove(stateDir)
+	return nil
+}
@@ -0,0 +1,231 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransactionRollback(t *testing.T) {
+	dest := t.TempDir()
+	write := func(rel, content string) {
+		p := filepath.Join(dest, filepath.FromSlash(rel))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+	}
+	read := func(rel string) string {
+		content, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(rel)))
+		require.NoError(t, err)
+		return string(content)
+	}
+	write("server.properties", "motd=old")
+	write("plugins/Old.jar", "old")
+	require.NoError(t, os.Symlink("server.properties", filepath.Join(dest, "link.properties")))
+
+	tx, err := New(dest).Begin()
+	require.NoError(t, err)
+
+	// modify
+	require.NoError(t, tx.Track("server.properties"))
+	write("server.properties", "motd=new")
+	require.NoError(t, tx.Track("server.properties"))
+	write("server.properties", "motd=newer")
+	// remove
+	require.NoError(t, tx.Track("plugins/Old.jar"))
+	require.NoError(t, os.Remove(filepath.Join(dest, "plugins", "Old.jar")))
+	// create
+	require.NoError(t, tx.Track("plugins/New.jar"))
+	write("plugins/New.jar", "new")
+	// replace symlink
+	require.NoError(t, tx.Track("link.properties"))
+	require.NoError(t, os.Remove(filepath.Join(dest, "link.properties")))
+	write("link.properties", "no longer a link")
+	// create empty directory
+	require.NoError(t, tx.Track("logs"))
+	require.NoError(t, os.Mkdir(filepath.Join(dest, "logs"), 0o755))
+
+	require.NoError(t, tx.Rollback())
+
+	assert.Equal(t, "motd=old", read("server.properties"))
+	assert.Equal(t, "old", read("plugins/Old.jar"))
+	assert.NoFileExists(t, filepath.Join(dest, "plugins", "New.jar"))
+	assert.NoDirExists(t, filepath.Join(dest, "logs"))
+	link, err := os.Readlink(filepath.Join(dest, "link.properties"))
+	require.NoError(t, err)
+	assert.Equal(t, "server.properties", link)
+	assert.NoDirExists(t, tx.dir, "the transaction must be removed")
+}
+
+func TestTransactionCommit(t *testing.T) {
+	dest := t.TempDir()
+	p := filepath.Join(dest, "server.properties")
+	require.NoError(t, os.WriteFile(p, []byte("motd=old"), 0o644))
+
+	tx, err := New(dest).Begin()
+	require.NoError(t, err)
+	require.NoError(t, tx.Track("server.properties"))
+	require.NoError(t, os.WriteFile(p, []byte("motd=new"), 0o644))
+	require.NoError(t, tx.Commit())
+
+	content, err := os.ReadFile(p)
+	require.NoError(t, err)
+	assert.Equal(t, "motd=new", string(content))
+	assert.NoDirExists(t, tx.dir)
+}
+
+func TestTransactionRollbackNewStateWithLock(t *testing.T) {
+	dest := t.TempDir()
+	store := New(dest)
+	lock, err := store.Lock(context.Background(), 0)
+	require.NoError(t, err)
+
+	tx, err := store.Begin()
+	require.NoError(t, err)
+	require.NoError(t, tx.Track("server.properties"))
+	require.NoError(t, os.WriteFile(filepath.Join(dest, "server.properties"), []byte("motd=new"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Dir(store.BasePath("server.properties")), 0o755))
+	require.NoError(t, os.WriteFile(store.BasePath("server.properties"), []byte("motd=new"), 0o644))
+	require.NoError(t, tx.Rollback())
+
+	assert.NoFileExists(t, filepath.Join(dest, "server.properties"))
+	assert.NoDirExists(t, filepath.Join(store.Root(), baseDirName), "state created during the transaction must be removed")
+	assert.FileExists(t, filepath.Join(store.Root(), applyLockFileName), "the lock must be kept until it's released")
+
+	require.NoError(t, lock.Unlock())
+	assert.NoDirExists(t, store.Root())
+}
+
+// interruptedApply executes the first of three intended operations and abandons the transaction, like a crash would.
+func interruptedApply(t *testing.T) string {
+	dest, desired := t.TempDir(), t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dest, "server.properties"), []byte("motd=old"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dest, "old.txt"), []byte("old"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(desired, "server.properties"), []byte("motd=new"), 0o600))
+
+	tx, err := New(dest).Begin()
+	require.NoError(t, err)
+	operations := []*Operation{
+		CopyOperation("server.properties", filepath.Join(desired, "server.properties")),
+		RemoveOperation("old.txt"),
+		ContentOperation("plugins/config.yml", []byte("merged: true"), ""),
+	}
+	require.NoError(t, tx.Intend(operations))
+	require.NoError(t, tx.Execute(operations[0]))
+	tx.closeJournal()
+
+	// the desired state is not needed anymore, it was staged
+	require.NoError(t, os.RemoveAll(desired))
+	return dest
+}
+
+func TestTransactionInterrupted(t *testing.T) {
+	dest := interruptedApply(t)
+	store := New(dest)
+
+	journal, err := store.Interrupted()
+	require.NoError(t, err)
+	require.NotNil(t, journal)
+	assert.Len(t, journal.Operations, 3)
+	assert.Equal(t, []string{"server.properties"}, journal.Done)
+	assert.Equal(t, []string{"server.properties"}, journal.Changed)
+
+	_, err = store.Begin()
+	var interruptedErr *InterruptedError
+	require.True(t, errors.As(err, &interruptedErr), "an interrupted transaction must not be discarded")
+	assert.Equal(t, journal.StartedAt, interruptedErr.StartedAt)
+}
+
+func TestTransactionRollForward(t *testing.T) {
+	dest := interruptedApply(t)
+	store := New(dest)
+
+	tx, err := store.Resume()
+	require.NoError(t, err)
+	written, err := tx.RollForward()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"server.properties", "plugins/config.yml"}, written)
+
+	content, err := os.ReadFile(filepath.Join(dest, "server.properties"))
+	require.NoError(t, err)
+	assert.Equal(t, "motd=new", string(content))
+	info, err := os.Stat(filepath.Join(dest, "server.properties"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm(), "the mode of the desired file must be kept")
+	assert.NoFileExists(t, filepath.Join(dest, "old.txt"))
+	content, err = os.ReadFile(filepath.Join(dest, "plugins", "config.yml"))
+	require.NoError(t, err)
+	assert.Equal(t, "merged: true", string(content))
+
+	journal, err := store.Interrupted()
+	require.NoError(t, err)
+	assert.Nil(t, journal)
+}
+
+func TestTransactionRollBackInterrupted(t *testing.T) {
+	dest := interruptedApply(t)
+	store := New(dest)
+
+	tx, err := store.Resume()
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+
+	content, err := os.ReadFile(filepath.Join(dest, "server.properties"))
+	require.NoError(t, err)
+	assert.Equal(t, "motd=old", string(content))
+	assert.FileExists(t, filepath.Join(dest, "old.txt"))
+	assert.NoDirExists(t, store.Root(), "the state directory didn't exist before the apply")
+}
+
+func TestTransactionDiscardsUnusedJournal(t *testing.T) {
+	dest := t.TempDir()
+	store := New(dest)
+	tx, err := store.Begin()
+	require.NoError(t, err)
+	require.NoError(t, tx.Intend([]*Operation{RemoveOperation("old.txt")}))
+	tx.closeJournal()
+
+	journal, err := store.Interrupted()
+	require.NoError(t, err)
+	assert.Nil(t, journal, "a transaction which didn't change anything is not interrupted")
+
+	tx, err = store.Begin()
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+}
+
+func TestTransactionRename(t *testing.T) {
+	dest, desired := t.TempDir(), t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dest, "plugins", "Foo"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dest, "plugins", "Foo", "config.yml"), []byte("a: 1"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(desired, "config.yml"), []byte("a: 1"), 0o644))
+	op := RenameOperation("plugins/Foo/config.yml", "plugins/foo/config.yml", filepath.Join(desired, "config.yml"))
+
+	tx, err := New(dest).Begin()
+	require.NoError(t, err)
+	require.NoError(t, tx.Intend([]*Operation{op}))
+	require.NoError(t, tx.Execute(op))
+	assert.NoFileExists(t, filepath.Join(dest, "plugins", "Foo", "config.yml"))
+	assert.FileExists(t, filepath.Join(dest, "plugins", "foo", "config.yml"))
+
+	require.NoError(t, tx.Rollback())
+	assert.FileExists(t, filepath.Join(dest, "plugins", "Foo", "config.yml"))
+	assert.NoFileExists(t, filepath.Join(dest, "plugins", "foo", "config.yml"))
+
+	t.Run("should copy the staged content if the renamed path is gone", func(t *testing.T) {
+		tx, err := New(dest).Begin()
+		require.NoError(t, err)
+		require.NoError(t, tx.Intend([]*Operation{op}))
+		require.NoError(t, os.Remove(filepath.Join(dest, "plugins", "Foo", "config.yml")))
+		require.NoError(t, tx.Execute(op))
+		require.NoError(t, tx.Commit())
+
+		content, err := os.ReadFile(filepath.Join(dest, "plugins", "foo", "config.yml"))
+		require.NoError(t, err)
+		assert.Equal(t, "a: 1", string(content))
+	})
+}